@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"maps"
+	"math"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// annotationLabel is the user label that the estimate of a policy is written to
+const annotationLabel = "appe-monthly-cost"
+
+// annotateSink writes the estimate of every policy into a user label, e.g. "12usd_2026-01-31" for $12 per month estimated on January 31st,
+// so that it is shown in the Cloud Console and in diffs of infrastructure as code. Unless apply is set, the changes are only logged.
+// Policies that failed to be estimated or that don't exist (e.g. from --grafanaRules) are skipped, as are labels whose price wouldn't change.
+type annotateSink struct {
+	ctx         context.Context
+	router      *clientRouter
+	apply       bool
+	date        string
+	definitions policyDefinitions
+	changed     int
+	failed      int
+}
+
+func newAnnotateSink(ctx context.Context, router *clientRouter, apply bool, runTime time.Time, definitions policyDefinitions) *annotateSink {
+	return &annotateSink{ctx: ctx, router: router, apply: apply, date: runTime.Format(time.DateOnly), definitions: definitions}
+}
+
+func (s *annotateSink) write(p *policy) error {
+	if _, ok := s.definitions[p.Name]; ok || p.Error != "" {
+		return nil
+	}
+	price := fmt.Sprintf("%dusd", int64(math.Round(p.Price)))
+	if annotatedPrice(p.UserLabels[annotationLabel]) == price {
+		return nil
+	}
+	value := price + "_" + s.date
+	if !s.apply {
+		log.Printf("Would set label %s=%s on %s (%s)\n", annotationLabel, value, p.DisplayName, p.Name)
+		s.changed++
+		return nil
+	}
+	// The labels of the result may be outdated, e.g. if it was loaded with --resume, so the current ones are read to not revert any changes
+	c := s.router.forProject(p.ProjectId)
+	ctx := c.quota.context(s.ctx, p.ProjectId)
+	current, err := c.alertPolicy.GetAlertPolicy(ctx, &monitoringpb.GetAlertPolicyRequest{Name: p.Name})
+	if err != nil {
+		slog.Error("Failed to annotate policy", "policy", p.Name, "error", err, "code", errorCode(err))
+		s.failed++
+		return nil
+	}
+	// Writing the same price again would only change the date, which marks the policy as modified for --cacheOnlyChanged and --modifiedSince
+	if annotatedPrice(current.GetUserLabels()[annotationLabel]) == price {
+		return nil
+	}
+	// Only the user labels are updated, all other fields of the policy are left as they are
+	labels := maps.Clone(current.GetUserLabels())
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[annotationLabel] = value
+	_, err = c.alertPolicy.UpdateAlertPolicy(ctx, &monitoringpb.UpdateAlertPolicyRequest{
+		AlertPolicy: &monitoringpb.AlertPolicy{Name: p.Name, UserLabels: labels},
+		UpdateMask:  &fieldmaskpb.FieldMask{Paths: []string{"user_labels"}},
+	})
+	if err != nil {
+		slog.Error("Failed to annotate policy", "policy", p.Name, "error", err, "code", errorCode(err))
+		s.failed++
+		return nil
+	}
+	s.changed++
+	return nil
+}
+
+// annotatedPrice returns the rounded price of an annotation, e.g. "12usd" for "12usd_2026-01-31"
+func annotatedPrice(value string) string {
+	price, _, _ := strings.Cut(value, "_")
+	return price
+}
+
+func (s *annotateSink) close(partial bool) error {
+	if !s.apply {
+		log.Printf("Preview: %d policies would be annotated with the label %s. Use --annotate apply to write it.\n", s.changed, annotationLabel)
+		return nil
+	}
+	log.Printf("Annotated %d policies with the label %s, %d failed\n", s.changed, annotationLabel, s.failed)
+	return nil
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	resourcemanager "cloud.google.com/go/resourcemanager/apiv3"
+	cloudasset "google.golang.org/api/cloudasset/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// alertPolicyAssetType is the Cloud Asset Inventory type of alerting policies
+const alertPolicyAssetType = "monitoring.googleapis.com/AlertPolicy"
+
+// policyAssets are the alerting policies found with Cloud Asset Inventory, grouped by the ID of their project
+type policyAssets struct {
+	mu       sync.Mutex
+	projects map[string][]*monitoringpb.AlertPolicy
+}
+
+func newPolicyAssets() *policyAssets {
+	return &policyAssets{projects: map[string][]*monitoringpb.AlertPolicy{}}
+}
+
+// take returns the policies found for a project and releases them. It reports false if the project wasn't found with
+// Cloud Asset Inventory, e.g. because it was added from a metrics scope, in which case its policies need to be listed with the API.
+func (a *policyAssets) take(projectId string) ([]*monitoringpb.AlertPolicy, bool) {
+	if a == nil {
+		return nil, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	alertPolicies, ok := a.projects[projectId]
+	delete(a.projects, projectId)
+	return alertPolicies, ok
+}
+
+// queueAlertPolicies puts the policies of a project that were found with Cloud Asset Inventory on the policies channel,
+// sampled like the ones listed by listAlertPolicies. It returns the number of policies put on the channel.
+func queueAlertPolicies(projectId string, alertPolicies []*monitoringpb.AlertPolicy, policiesIn chan *monitoringpb.AlertPolicy, policySampler *sampler) int {
+	if policySampler != nil {
+		alertPolicies = policySampler.sample(projectId, alertPolicies)
+	}
+	for _, alertPolicy := range alertPolicies {
+		policiesIn <- alertPolicy
+	}
+	return len(alertPolicies)
+}
+
+// listAlertPolicyAssets lists all alerting policies under parent with Cloud Asset Inventory and keeps the ones matching the filter in assets.
+// This replaces listing the projects under parent and then listing the policies in each of them. Once all policies are listed,
+// the projects they belong to are put on the projects channel, so that they are limited, tested and resumed like listed projects.
+func listAlertPolicyAssets(ctx context.Context, assetService *cloudasset.Service, projectsClient *resourcemanager.ProjectsClient, parent string, projects chan string, assets *policyAssets, filter *policyFilter, recursive bool, excludedFolders []string, limit *projectLimit) error {
+	projectIds := map[string]string{}
+	found := map[string][]*monitoringpb.AlertPolicy{}
+	err := assetService.Assets.List(parent).AssetTypes(alertPolicyAssetType).ContentType("RESOURCE").PageSize(1000).Pages(ctx, func(resp *cloudasset.ListAssetsResponse) error {
+		for _, asset := range resp.Assets {
+			// The first ancestor is the project of the policy, followed by its folders and organization
+			ancestors := asset.Ancestors
+			if !recursive && (len(ancestors) < 2 || ancestors[1] != parent) {
+				continue
+			}
+			if slices.ContainsFunc(ancestors, func(ancestor string) bool {
+				return strings.HasPrefix(ancestor, "folders/") && slices.Contains(excludedFolders, strings.TrimPrefix(ancestor, "folders/"))
+			}) {
+				continue
+			}
+			if asset.Resource == nil {
+				continue
+			}
+			alertPolicy := &monitoringpb.AlertPolicy{}
+			err := protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(asset.Resource.Data, alertPolicy)
+			if err != nil {
+				slog.Warn("Failed to parse policy", "policy", asset.Name, "error", err)
+				continue
+			}
+			if !filter.matches(alertPolicy) {
+				continue
+			}
+			// Policies may reference their project by number, which we replace by the project ID to be consistent with the other discovery methods
+			project := getProjectId(alertPolicy)
+			if isNumeric(project) {
+				id, ok := projectIds[project]
+				if !ok {
+					id, err = resolveProject(ctx, projectsClient, project)
+					if err != nil {
+						slog.Warn("Failed to resolve project", "project", project, "error", err, "code", errorCode(err))
+						id = project
+					}
+					projectIds[project] = id
+				}
+				alertPolicy.Name = strings.Replace(alertPolicy.GetName(), "projects/"+project+"/", "projects/"+id+"/", 1)
+				project = id
+			}
+			found[project] = append(found[project], alertPolicy)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Failed to list policy assets", "parent", parent, "error", err, "code", errorCode(err))
+		return err
+	}
+	assets.mu.Lock()
+	for project, alertPolicies := range found {
+		assets.projects[project] = append(assets.projects[project], alertPolicies...)
+	}
+	assets.mu.Unlock()
+	for _, project := range slices.Sorted(maps.Keys(found)) {
+		if limit.reached() {
+			break
+		}
+		projects <- project
+	}
+	return nil
+}
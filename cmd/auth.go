@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/auth"
+	"cloud.google.com/go/auth/credentials"
+	"github.com/spf13/pflag"
+)
+
+// readOnlyScopes are the OAuth scopes that appe needs by default, which only allow reading monitoring and resource data
+var readOnlyScopes = []string{
+	"https://www.googleapis.com/auth/monitoring.read",
+	"https://www.googleapis.com/auth/cloud-platform.read-only",
+}
+
+// monitoringWriteScope is the scope to write the metrics of --metricsProject and --costMetricsProject
+const monitoringWriteScope = "https://www.googleapis.com/auth/monitoring.write"
+
+// cloudPlatformScope is the only scope that Cloud Asset Inventory accepts
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// scopeRequirement is an OAuth scope that an input or output needs in addition to the read-only scopes
+type scopeRequirement struct {
+	scope string
+	// needed reports whether the value of the flag needs the scope, or is nil if every value does
+	needed func(value string) bool
+}
+
+// flagScopes are the scope requirements of the inputs and outputs by the flag that enables them.
+// Cloud Asset Inventory doesn't accept read-only scopes, and writing to GCS, BigQuery, Pub/Sub or Firestore, reading budgets, watching for changes and annotating policies need the broader scope as well.
+var flagScopes = map[string]scopeRequirement{
+	"discovery":           {cloudPlatformScope, func(value string) bool { return value == "asset" }},
+	"csvOut":              {cloudPlatformScope, isGCSObject},
+	"lookerStudioOut":     {cloudPlatformScope, isGCSObject},
+	"sarifOut":            {cloudPlatformScope, isGCSObject},
+	"recommendationsOut":  {cloudPlatformScope, isGCSObject},
+	"metricIngestionOut":  {cloudPlatformScope, isGCSObject},
+	"unusedMetricsOut":    {cloudPlatformScope, isGCSObject},
+	"overlapsOut":         {cloudPlatformScope, isGCSObject},
+	"chargebackOut":       {cloudPlatformScope, isGCSObject},
+	"baselineOut":         {cloudPlatformScope, isGCSObject},
+	"snapshotBucket":      {cloudPlatformScope, nil},
+	"bigQueryTable":       {cloudPlatformScope, nil},
+	"billingAccount":      {cloudPlatformScope, nil},
+	"pubsubSubscription":  {cloudPlatformScope, nil},
+	"pubsubTopic":         {cloudPlatformScope, nil},
+	"firestoreCollection": {cloudPlatformScope, nil},
+	"annotate":            {cloudPlatformScope, func(value string) bool { return value == "apply" }},
+}
+
+// isGCSObject reports whether a path is a GCS object given as gs://BUCKET/OBJECT
+func isGCSObject(path string) bool {
+	return strings.HasPrefix(path, "gs://")
+}
+
+// requiredScopes returns the scopes that the inputs and outputs enabled by the flags need in addition to the read-only scopes
+func requiredScopes(flags *pflag.FlagSet) []string {
+	var scopes []string
+	flags.Visit(func(flag *pflag.Flag) {
+		r, ok := flagScopes[flag.Name]
+		if !ok || flag.Value.String() == "" || r.needed != nil && !r.needed(flag.Value.String()) {
+			return
+		}
+		if !slices.Contains(scopes, r.scope) {
+			scopes = append(scopes, r.scope)
+		}
+	})
+	return scopes
+}
+
+// loadCredentials loads a credentials file and makes sure that a token can be obtained with it before any client uses it.
+// Besides service account keys, this supports external account configurations for workload identity federation (e.g. from AWS or an OIDC provider),
+// so that the gRPC monitoring clients and the REST services all authenticate with the same token source.
+// Tokens are requested with client, or the default HTTP client if it is nil.
+func loadCredentials(ctx context.Context, path string, scopes []string, client *http.Client) (*auth.Credentials, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Type string `json:"type"`
+	}
+	err = json.Unmarshal(b, &file)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a credentials file: %v", path, err)
+	}
+	creds, err := credentials.DetectDefault(&credentials.DetectOptions{
+		CredentialsJSON: b,
+		Scopes:          scopes,
+		Client:          client,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// For external accounts, this exchanges the external token for a Google access token
+	_, err = creds.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get a token for %s credentials: %v", file.Type, err)
+	}
+	return creds, nil
+}
+
+// defaultCredentials returns the application default credentials, which request their tokens with client
+func defaultCredentials(scopes []string, client *http.Client) (*auth.Credentials, error) {
+	return credentials.DetectDefault(&credentials.DetectOptions{
+		Scopes: scopes,
+		Client: client,
+	})
+}
@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+// baseline holds the prices of a previous run to compare the current results to
+type baseline struct {
+	prices map[string]float64
+	total  float64
+}
+
+// readBaseline reads the prices of all policies from a CSV file written by a previous run with --csvOut,
+// or from a JSON file if its name ends with .json
+func readBaseline(path string) (*baseline, error) {
+	if strings.HasSuffix(path, ".json") {
+		return readJSONBaseline(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	// Metadata and the marker of partial results are skipped
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	name, price := -1, -1
+	for i, column := range records[0] {
+		switch column {
+		case "Policy Name":
+			name = i
+		case "Price":
+			price = i
+		}
+	}
+	if name < 0 || price < 0 {
+		return nil, fmt.Errorf("%s has no \"Policy Name\" and \"Price\" columns", path)
+	}
+	b := &baseline{prices: map[string]float64{}}
+	for _, record := range records[1:] {
+		if len(record) <= max(name, price) || record[name] == "" {
+			continue
+		}
+		p, err := strconv.ParseFloat(record[price], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid price %q of %s: %v", record[price], record[name], err)
+		}
+		b.prices[record[name]] = p
+		b.total += p
+	}
+	return b, nil
+}
+
+// delta returns the difference of the price of a policy to the baseline, and false if the policy isn't in the baseline
+func (b *baseline) delta(p *policy) (float64, bool) {
+	if b == nil {
+		return 0, false
+	}
+	price, ok := b.prices[p.Name]
+	return p.Price - price, ok
+}
+
+// readJSONBaseline reads the prices of all policies from a JSON file written by --baselineOut or a snapshot stored by --snapshotBucket
+func readJSONBaseline(path string) (*baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s snapshot
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return nil, err
+	}
+	b := &baseline{prices: map[string]float64{}}
+	for _, p := range s.Policies {
+		b.prices[p.Name] = p.Price
+		b.total += p.Price
+	}
+	return b, nil
+}
+
+// maxIncrease is the allowed increase of a price over the baseline, either relative to it in percent or absolute in USD
+type maxIncrease struct {
+	value   float64
+	percent bool
+}
+
+// parseMaxIncrease parses an increase like "10%" or "5"
+func parseMaxIncrease(s string) (maxIncrease, error) {
+	var m maxIncrease
+	value, found := strings.CutSuffix(strings.TrimSpace(s), "%")
+	v, err := strconv.ParseFloat(strings.TrimPrefix(value, "$"), 64)
+	if err != nil || v < 0 {
+		return m, fmt.Errorf("invalid maximum increase %q, must be a percentage like \"10%%\" or an amount in USD like \"5\"", s)
+	}
+	return maxIncrease{value: v, percent: found}, nil
+}
+
+// exceeded reports whether the price grew beyond the allowed increase over the price of the baseline.
+// A relative increase can't be computed without a price in the baseline, so only absolute increases apply to new policies.
+// The prices are compared in cents as they are shown, so that rounding errors don't exceed an increase that looks allowed.
+func (m maxIncrease) exceeded(before float64, after float64, inBaseline bool) bool {
+	before, after = math.Round(before*100)/100, math.Round(after*100)/100
+	if !m.percent {
+		return after-before > m.value
+	}
+	if !inBaseline || before <= 0 {
+		return false
+	}
+	return (after-before)/before*100 > m.value
+}
+
+// String formats the increase as it was given
+func (m maxIncrease) String() string {
+	if m.percent {
+		return strconv.FormatFloat(m.value, 'f', -1, 64) + "%"
+	}
+	return fmt.Sprintf("$%.2f", m.value)
+}
+
+// regressionGate fails the run if the total price or the price of any policy grew beyond the allowed increase over the baseline,
+// e.g. to test in a deployment pipeline that changes to alerting policies don't raise their costs unnoticed
+type regressionGate struct {
+	baseline    *baseline
+	max         maxIncrease
+	total       float64
+	regressions []string
+}
+
+func newRegressionGate(b *baseline, max maxIncrease) *regressionGate {
+	return &regressionGate{baseline: b, max: max}
+}
+
+func (g *regressionGate) write(p *policy) error {
+	g.total += p.Price
+	before, inBaseline := g.baseline.prices[p.Name]
+	if g.max.exceeded(before, p.Price, inBaseline) {
+		g.regressions = append(g.regressions, fmt.Sprintf("%s (%s): $%.2f, %s vs. baseline", p.DisplayName, p.Name, p.Price, formatDelta(p.Price-before)))
+	}
+	return nil
+}
+
+func (g *regressionGate) close(partial bool) error {
+	slices.Sort(g.regressions)
+	// The total of a partial run is lower than it should be, so it can't exceed the baseline for the right reasons
+	if !partial && g.max.exceeded(g.baseline.total, g.total, true) {
+		g.regressions = append([]string{fmt.Sprintf("Total: $%.2f, %s vs. baseline", g.total, formatDelta(g.total-g.baseline.total))}, g.regressions...)
+	}
+	return nil
+}
+
+// print logs all prices that grew beyond the allowed increase
+func (g *regressionGate) print() {
+	if g == nil {
+		return
+	}
+	if len(g.regressions) == 0 {
+		log.Printf("No price grew by more than %s over the baseline\n", g.max)
+		return
+	}
+	log.Printf("Found %d price(s) that grew by more than %s over the baseline:\n", len(g.regressions), g.max)
+	for _, regression := range g.regressions {
+		log.Printf("  - %s\n", regression)
+	}
+}
+
+// violated reports whether any price grew beyond the allowed increase
+func (g *regressionGate) violated() bool {
+	return g != nil && len(g.regressions) > 0
+}
+
+// baselineSink writes the results of a run as a JSON file that later runs can use as their --baseline
+type baselineSink struct {
+	path     string
+	storage  *storage.Service
+	snapshot snapshot
+}
+
+func newBaselineSink(path string, storageService *storage.Service, scope string, tags map[string]string, runTime time.Time) *baselineSink {
+	return &baselineSink{path: path, storage: storageService, snapshot: snapshot{RunTime: runTime, Scope: scope, Tags: tags, Policies: []policyResult{}}}
+}
+
+func (s *baselineSink) write(p *policy) error {
+	s.snapshot.Policies = append(s.snapshot.Policies, newPolicyResult(p, s.snapshot.RunTime))
+	return nil
+}
+
+func (s *baselineSink) close(partial bool) error {
+	// A partial baseline would let the missing policies pass as new in later runs
+	if partial {
+		log.Println("The baseline isn't written, as the run was stopped before all policies were processed")
+		return nil
+	}
+	b, err := json.MarshalIndent(s.snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, upload, err := createOutput(s.path, s.storage)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return finishOutput(f, upload)
+}
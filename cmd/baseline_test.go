@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadBaseline(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    map[string]float64
+		total   float64
+		wantErr string
+	}{
+		{
+			name:    "csv",
+			file:    "out.csv",
+			content: "# version: 1.0.0\nPolicy Name,Display Name,Price\nprojects/p/alertPolicies/1,CPU,1.50\nprojects/p/alertPolicies/2,Memory,0.25\n",
+			want:    map[string]float64{"projects/p/alertPolicies/1": 1.5, "projects/p/alertPolicies/2": 0.25},
+			total:   1.75,
+		},
+		{
+			name:    "csv of a partial run",
+			file:    "out.csv",
+			content: "Policy Name,Price\nprojects/p/alertPolicies/1,2\n,\n# partial: the run was stopped before all policies were processed\n",
+			want:    map[string]float64{"projects/p/alertPolicies/1": 2},
+			total:   2,
+		},
+		{
+			name:    "json",
+			file:    "baseline.json",
+			content: `{"runTime":"2026-01-31T00:00:00Z","scope":"--project=p","policies":[{"name":"projects/p/alertPolicies/1","price":3},{"name":"projects/p/alertPolicies/2","price":0.5}]}`,
+			want:    map[string]float64{"projects/p/alertPolicies/1": 3, "projects/p/alertPolicies/2": 0.5},
+			total:   3.5,
+		},
+		{name: "empty", file: "out.csv", wantErr: "is empty"},
+		{name: "missing columns", file: "out.csv", content: "Name,Cost\na,1\n", wantErr: `has no "Policy Name" and "Price" columns`},
+		{name: "invalid price", file: "out.csv", content: "Policy Name,Price\na,free\n", wantErr: `invalid price "free" of a`},
+		{name: "invalid json", file: "baseline.json", content: "[", wantErr: "unexpected end of JSON input"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.file)
+			err := os.WriteFile(path, []byte(test.content), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := readBaseline(path)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(b.prices, test.want) {
+				t.Errorf("prices = %v, want %v", b.prices, test.want)
+			}
+			if b.total != test.total {
+				t.Errorf("total = %v, want %v", b.total, test.total)
+			}
+		})
+	}
+}
+
+func TestParseMaxIncrease(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    maxIncrease
+		wantErr bool
+	}{
+		{value: "10%", want: maxIncrease{value: 10, percent: true}},
+		{value: " 2.5% ", want: maxIncrease{value: 2.5, percent: true}},
+		{value: "5", want: maxIncrease{value: 5}},
+		{value: "$5", want: maxIncrease{value: 5}},
+		{value: "0", want: maxIncrease{}},
+		{value: "-1", wantErr: true},
+		{value: "ten", wantErr: true},
+		{value: "%", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := parseMaxIncrease(test.value)
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("error = nil, want an error for %q", test.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("parseMaxIncrease(%q) = %+v, want %+v", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestExceeded(t *testing.T) {
+	tests := []struct {
+		name       string
+		max        maxIncrease
+		before     float64
+		after      float64
+		inBaseline bool
+		want       bool
+	}{
+		{name: "absolute within", max: maxIncrease{value: 5}, before: 10, after: 15, inBaseline: true},
+		{name: "absolute beyond", max: maxIncrease{value: 5}, before: 10, after: 15.01, inBaseline: true, want: true},
+		{name: "absolute new policy", max: maxIncrease{value: 5}, after: 6, want: true},
+		{name: "absolute decrease", max: maxIncrease{}, before: 10, after: 9, inBaseline: true},
+		{name: "absolute below a cent", max: maxIncrease{}, before: 10, after: 10.004, inBaseline: true},
+		{name: "absolute rounding error", max: maxIncrease{value: 0.1}, before: 0.2, after: 0.1 + 0.2, inBaseline: true},
+		{name: "relative within", max: maxIncrease{value: 10, percent: true}, before: 10, after: 11, inBaseline: true},
+		{name: "relative beyond", max: maxIncrease{value: 10, percent: true}, before: 10, after: 11.01, inBaseline: true, want: true},
+		{name: "relative below a cent", max: maxIncrease{value: 10, percent: true}, before: 0.1, after: 0.104, inBaseline: true},
+		{name: "relative new policy", max: maxIncrease{value: 10, percent: true}, after: 100},
+		{name: "relative free before", max: maxIncrease{value: 10, percent: true}, after: 100, inBaseline: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.max.exceeded(test.before, test.after, test.inBaseline); got != test.want {
+				t.Errorf("exceeded(%v, %v, %v) = %v, want %v", test.before, test.after, test.inBaseline, got, test.want)
+			}
+		})
+	}
+}
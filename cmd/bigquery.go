@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	bigquery "google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+// bigQueryBatch is the number of rows that are inserted into BigQuery at once
+const bigQueryBatch = 500
+
+// bigQuerySchema is the schema of the history table. Each row is the result of a policy in a run.
+var bigQuerySchema = &bigquery.TableSchema{Fields: []*bigquery.TableFieldSchema{
+	{Name: "run_id", Type: "STRING", Mode: "REQUIRED", Description: "Unique ID of the run"},
+	{Name: "run_time", Type: "TIMESTAMP", Mode: "REQUIRED", Description: "Start of the run"},
+	{Name: "project_id", Type: "STRING"},
+	{Name: "policy_name", Type: "STRING", Mode: "REQUIRED"},
+	{Name: "display_name", Type: "STRING"},
+	{Name: "conditions", Type: "INTEGER"},
+	{Name: "time_series", Type: "INTEGER"},
+	{Name: "price", Type: "FLOAT"},
+	{Name: "error", Type: "STRING"},
+	{Name: "tags", Type: "RECORD", Mode: "REPEATED", Description: "Tags of the run given with --tag", Fields: []*bigquery.TableFieldSchema{
+		{Name: "key", Type: "STRING"},
+		{Name: "value", Type: "STRING"},
+	}},
+}}
+
+// bigQueryTable is a table given as PROJECT.DATASET.TABLE
+type bigQueryTable struct {
+	project string
+	dataset string
+	table   string
+}
+
+func parseBigQueryTable(s string) (bigQueryTable, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return bigQueryTable{}, fmt.Errorf("table %q must be in the format PROJECT.DATASET.TABLE", s)
+	}
+	return bigQueryTable{project: parts[0], dataset: parts[1], table: parts[2]}, nil
+}
+
+func (t bigQueryTable) String() string {
+	return fmt.Sprintf("%s.%s.%s", t.project, t.dataset, t.table)
+}
+
+// ensureTable creates the history table partitioned by day of the run, unless it already exists.
+// Columns that were added to the schema after the table was created are added to it.
+func ensureTable(ctx context.Context, service *bigquery.Service, t bigQueryTable) error {
+	table, err := service.Tables.Get(t.project, t.dataset, t.table).Context(ctx).Do()
+	if err == nil {
+		fields := table.Schema.Fields
+		for _, field := range bigQuerySchema.Fields {
+			if !slices.ContainsFunc(fields, func(f *bigquery.TableFieldSchema) bool { return f.Name == field.Name }) {
+				fields = append(fields, field)
+			}
+		}
+		if len(fields) == len(table.Schema.Fields) {
+			return nil
+		}
+		_, err = service.Tables.Patch(t.project, t.dataset, t.table, &bigquery.Table{Schema: &bigquery.TableSchema{Fields: fields}}).Context(ctx).Do()
+		return err
+	}
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound {
+		return err
+	}
+	_, err = service.Tables.Insert(t.project, t.dataset, &bigquery.Table{
+		TableReference:   &bigquery.TableReference{ProjectId: t.project, DatasetId: t.dataset, TableId: t.table},
+		Schema:           bigQuerySchema,
+		TimePartitioning: &bigquery.TimePartitioning{Type: "DAY", Field: "run_time"},
+		Description:      "Estimated prices of alerting policies written by appe",
+	}).Context(ctx).Do()
+	return err
+}
+
+// bigQuerySink appends the results of a run to the history table in BigQuery, all with the same run ID and time
+type bigQuerySink struct {
+	ctx     context.Context
+	service *bigquery.Service
+	table   bigQueryTable
+	runId   string
+	runTime time.Time
+	rows    []*bigquery.TableDataInsertAllRequestRows
+}
+
+func newBigQuerySink(ctx context.Context, service *bigquery.Service, table bigQueryTable, runTime time.Time) (*bigQuerySink, error) {
+	err := ensureTable(ctx, service, table)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	if err != nil {
+		return nil, err
+	}
+	s := &bigQuerySink{ctx: ctx, service: service, table: table, runId: hex.EncodeToString(id), runTime: runTime}
+	slog.Info("Writing results to BigQuery", "table", table.String(), "runId", s.runId)
+	return s, nil
+}
+
+func (s *bigQuerySink) write(p *policy) error {
+	s.rows = append(s.rows, &bigquery.TableDataInsertAllRequestRows{
+		// The insert ID lets BigQuery drop duplicates if a batch is retried
+		InsertId: s.runId + "/" + p.Name,
+		Json: map[string]bigquery.JsonValue{
+			"run_id":       s.runId,
+			"run_time":     s.runTime.Format(time.RFC3339Nano),
+			"project_id":   p.ProjectId,
+			"policy_name":  p.Name,
+			"display_name": p.DisplayName,
+			"conditions":   p.Conditions,
+			"time_series":  p.TimeSeries,
+			"price":        p.Price,
+			"error":        p.Error,
+		},
+	})
+	if len(p.Tags) > 0 {
+		var tags []map[string]string
+		for _, key := range slices.Sorted(maps.Keys(p.Tags)) {
+			tags = append(tags, map[string]string{"key": key, "value": p.Tags[key]})
+		}
+		s.rows[len(s.rows)-1].Json["tags"] = tags
+	}
+	if len(s.rows) >= bigQueryBatch {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush inserts all buffered rows
+func (s *bigQuerySink) flush() error {
+	if len(s.rows) == 0 {
+		return nil
+	}
+	response, err := s.service.Tabledata.InsertAll(s.table.project, s.table.dataset, s.table.table, &bigquery.TableDataInsertAllRequest{Rows: s.rows}).Context(s.ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to insert rows into %s: %v", s.table, err)
+	}
+	if len(response.InsertErrors) > 0 && len(response.InsertErrors[0].Errors) > 0 {
+		return fmt.Errorf("failed to insert %d rows into %s: %s", len(response.InsertErrors), s.table, response.InsertErrors[0].Errors[0].Message)
+	}
+	s.rows = s.rows[:0]
+	return nil
+}
+
+func (s *bigQuerySink) close(partial bool) error {
+	if partial {
+		slog.Warn("The run in BigQuery is partial, as it was stopped before all policies were processed", "runId", s.runId)
+	}
+	return s.flush()
+}
+
+// newBigQueryService creates a BigQuery client for the commands that only query BigQuery, using the credentials file at path
+// or the credentials stored by "appe auth login" if it is empty and they exist, otherwise the application default credentials
+func newBigQueryService(ctx context.Context, path string) (*bigquery.Service, error) {
+	if path == "" {
+		if _, err := os.Stat(defaultCredentialsPath()); err == nil {
+			path = defaultCredentialsPath()
+		}
+	}
+	var options []option.ClientOption
+	if path != "" {
+		creds, err := loadCredentials(ctx, path, []string{bigquery.BigqueryScope}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load credentials %s: %v", path, err)
+		}
+		options = append(options, option.WithAuthCredentials(creds))
+	}
+	service, err := bigquery.NewService(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create BigQuery client: %v", err)
+	}
+	return service, nil
+}
+
+// queryBigQuery runs a standard SQL query with named string parameters (e.g. @run_id) and returns the values of all rows as strings
+func queryBigQuery(ctx context.Context, service *bigquery.Service, project string, query string, params map[string]string) ([][]string, error) {
+	useLegacySql := false
+	var queryParameters []*bigquery.QueryParameter
+	for name, value := range params {
+		queryParameters = append(queryParameters, &bigquery.QueryParameter{
+			Name:           name,
+			ParameterType:  &bigquery.QueryParameterType{Type: "STRING"},
+			ParameterValue: &bigquery.QueryParameterValue{Value: value},
+		})
+	}
+	response, err := service.Jobs.Query(project, &bigquery.QueryRequest{
+		Query:           query,
+		UseLegacySql:    &useLegacySql,
+		ParameterMode:   "NAMED",
+		QueryParameters: queryParameters,
+		TimeoutMs:       60000,
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	rows, complete, pageToken, job := response.Rows, response.JobComplete, response.PageToken, response.JobReference
+	for !complete || pageToken != "" {
+		results, err := service.Jobs.GetQueryResults(project, job.JobId).Location(job.Location).PageToken(pageToken).TimeoutMs(60000).Context(ctx).Do()
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, results.Rows...)
+		complete, pageToken = results.JobComplete, results.PageToken
+	}
+	values := make([][]string, len(rows))
+	for i, row := range rows {
+		for _, cell := range row.F {
+			value, _ := cell.V.(string)
+			values[i] = append(values[i], value)
+		}
+	}
+	return values, nil
+}
+
+// parseFloat parses a numeric value returned by BigQuery, which is 0 for NULL
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
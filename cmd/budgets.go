@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/resourcemanager/apiv3/resourcemanagerpb"
+	billingbudgets "google.golang.org/api/billingbudgets/v1"
+)
+
+// budgetPeriodMonths is the number of months of each calendar period of a budget, as appe estimates the price per month
+var budgetPeriodMonths = map[string]float64{"": 1, "MONTH": 1, "QUARTER": 3, "YEAR": 12}
+
+// budgetSink sums up the estimated price per project and compares it to the Cloud Billing budgets of Cloud Monitoring
+// in a billing account once all results have been received
+type budgetSink struct {
+	ctx            context.Context
+	service        *billingbudgets.Service
+	router         *clientRouter
+	billingAccount string
+	prices         map[string]float64
+}
+
+func newBudgetSink(ctx context.Context, service *billingbudgets.Service, router *clientRouter, billingAccount string) *budgetSink {
+	return &budgetSink{
+		ctx:            ctx,
+		service:        service,
+		router:         router,
+		billingAccount: strings.TrimPrefix(billingAccount, "billingAccounts/"),
+		prices:         map[string]float64{},
+	}
+}
+
+func (s *budgetSink) write(p *policy) error {
+	s.prices[p.ProjectId] += p.Price
+	return nil
+}
+
+func (s *budgetSink) close(partial bool) error {
+	// Budgets filter projects by their number, so we need to look up the number of every project with results
+	numbers := map[string]string{}
+	for projectId := range s.prices {
+		project, err := s.router.forProject(projectId).projects.GetProject(s.ctx, &resourcemanagerpb.GetProjectRequest{Name: "projects/" + projectId})
+		if err != nil {
+			slog.Warn("Failed to look up project number for budgets", "project", projectId, "error", err, "code", errorCode(err))
+			continue
+		}
+		numbers[project.GetName()] = projectId
+	}
+
+	found := 0
+	err := s.service.BillingAccounts.Budgets.List("billingAccounts/"+s.billingAccount).Pages(s.ctx, func(response *billingbudgets.GoogleCloudBillingBudgetsV1ListBudgetsResponse) error {
+		for _, budget := range response.Budgets {
+			filter := budget.BudgetFilter
+			if filter == nil || !slices.Contains(filter.Services, monitoringService) {
+				continue
+			}
+			found++
+			name := budget.DisplayName
+			if name == "" {
+				name = budget.Name
+			}
+			if len(filter.ResourceAncestors) > 0 || len(filter.Labels) > 0 || len(filter.Subaccounts) > 0 || filter.CustomPeriod != nil {
+				log.Printf("Budget %s filters by folders, labels, subaccounts or a custom period, which can't be compared to the estimate\n", name)
+				continue
+			}
+			if budget.Amount == nil || budget.Amount.SpecifiedAmount == nil {
+				log.Printf("Budget %s is based on the spend of the last period, which can't be compared to the estimate\n", name)
+				continue
+			}
+			amount := budget.Amount.SpecifiedAmount
+			if amount.CurrencyCode != "" && amount.CurrencyCode != "USD" {
+				log.Printf("Budget %s is in %s, but the estimate is in USD\n", name, amount.CurrencyCode)
+				continue
+			}
+			// A budget without projects applies to all projects of the billing account
+			estimate := 0.0
+			if len(filter.Projects) == 0 {
+				for _, price := range s.prices {
+					estimate += price
+				}
+			} else {
+				for _, project := range filter.Projects {
+					estimate += s.prices[numbers[project]]
+				}
+			}
+			estimate *= budgetPeriodMonths[filter.CalendarPeriod]
+			limit := float64(amount.Units) + float64(amount.Nanos)/1e9
+			percent := 0.0
+			if limit > 0 {
+				percent = estimate / limit * 100
+			}
+			log.Printf("Budget %s: alerting policies will cost approximately $%f of $%.2f (%.0f%% of budget)\n", name, estimate, limit, percent)
+			if estimate > limit {
+				slog.Warn("The estimated alerting spend would breach the budget", "budget", name, "estimate", estimate, "amount", limit)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Failed to list budgets", "billingAccount", s.billingAccount, "error", err)
+		return nil
+	}
+	if found == 0 {
+		log.Printf("No budgets for Cloud Monitoring were found in billing account %s\n", s.billingAccount)
+	} else if partial {
+		log.Println("The budget comparison is based on partial results, as the run was stopped before all policies were processed")
+	}
+	return nil
+}
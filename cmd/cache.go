@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// countCache stores the number of time series returned by a query on disk, so that repeated runs don't need to execute
+// the same queries again. Each entry is stored in its own file, which allows multiple threads to use the cache at once.
+type countCache struct {
+	dir string
+	ttl time.Duration
+}
+
+type countCacheEntry struct {
+	Count int       `json:"count"`
+	Time  time.Time `json:"time"`
+}
+
+func newCountCache(dir string, ttl time.Duration) (*countCache, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &countCache{
+		dir: dir,
+		ttl: ttl,
+	}, nil
+}
+
+// cacheKey hashes all parts that identify a query, e.g. the project, the query or filter and the length of the time window
+func cacheKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// count returns the cached number of time series for key if there is an entry that hasn't expired yet.
+// Otherwise, it calls f to count them and caches the result if there was no error.
+// It is safe to call on a nil cache, in which case f is always called.
+func (c *countCache) count(key string, f func() (int, error)) (int, error) {
+	if c == nil {
+		return f()
+	}
+	path := filepath.Join(c.dir, key+".json")
+	if b, err := os.ReadFile(path); err == nil {
+		entry := &countCacheEntry{}
+		if json.Unmarshal(b, entry) == nil && time.Since(entry.Time) < c.ttl {
+			return entry.Count, nil
+		}
+	}
+	count, err := f()
+	if err != nil {
+		return count, err
+	}
+	b, err := json.Marshal(&countCacheEntry{
+		Count: count,
+		Time:  time.Now(),
+	})
+	if err != nil {
+		return count, nil
+	}
+	// We write to a temporary file first, so that other threads never read a partially written entry
+	tmp, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return count, nil
+	}
+	_, err = tmp.Write(b)
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return count, nil
+}
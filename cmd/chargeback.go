@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+// chargebackNone is the group of policies without a value for the chargeback key
+const chargebackNone = "(none)"
+
+// subtotal is the sum of the estimates of a group of policies, e.g. all policies with the same value of the chargeback key
+type subtotal struct {
+	policies   int
+	conditions int
+	timeSeries int
+	price      float64
+}
+
+// add adds the estimate of a policy to the subtotal
+func (t *subtotal) add(p *policy) {
+	t.policies++
+	t.conditions += p.Conditions
+	t.timeSeries += p.TimeSeries
+	t.price += p.Price
+}
+
+// chargebackSink sums up the estimates by the value of a label of the policies or their projects and logs a cost table once the run is done,
+// e.g. to charge the costs of alerting back to the teams that own the policies. The key is the name of a label, which is looked up on the
+// policy first and on its project otherwise, or policy:LABEL or project:LABEL to only look it up on one of them.
+type chargebackSink struct {
+	ctx      context.Context
+	paths    *folderPaths
+	scope    string
+	label    string
+	path     string
+	storage  *storage.Service
+	groups   map[string]*subtotal
+	total    float64
+	policies int
+}
+
+func newChargebackSink(ctx context.Context, paths *folderPaths, key string, path string, storageService *storage.Service) (*chargebackSink, error) {
+	scope, label, found := strings.Cut(key, ":")
+	if !found {
+		scope, label = "", key
+	}
+	if scope != "" && scope != "policy" && scope != "project" {
+		return nil, fmt.Errorf("invalid chargeback key %q, must be LABEL, policy:LABEL or project:LABEL", key)
+	}
+	if label == "" {
+		return nil, fmt.Errorf("invalid chargeback key %q, the label is missing", key)
+	}
+	return &chargebackSink{ctx: ctx, paths: paths, scope: scope, label: label, path: path, storage: storageService, groups: map[string]*subtotal{}}, nil
+}
+
+// value returns the value of the chargeback key of a policy
+func (s *chargebackSink) value(p *policy) string {
+	if s.scope != "project" {
+		if value := p.UserLabels[s.label]; value != "" {
+			return value
+		}
+	}
+	if s.scope != "policy" {
+		if value := s.paths.project(s.ctx, p.ProjectId).GetLabels()[s.label]; value != "" {
+			return value
+		}
+	}
+	return chargebackNone
+}
+
+func (s *chargebackSink) write(p *policy) error {
+	value := s.value(p)
+	group, ok := s.groups[value]
+	if !ok {
+		group = &subtotal{}
+		s.groups[value] = group
+	}
+	group.add(p)
+	s.total += p.Price
+	s.policies++
+	return nil
+}
+
+func (s *chargebackSink) close(partial bool) error {
+	// The most expensive groups come first, policies without a value last
+	values := slices.Collect(maps.Keys(s.groups))
+	slices.SortFunc(values, func(a, b string) int {
+		if (a == chargebackNone) != (b == chargebackNone) {
+			if a == chargebackNone {
+				return 1
+			}
+			return -1
+		}
+		if s.groups[a].price != s.groups[b].price {
+			if s.groups[a].price > s.groups[b].price {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+
+	records := [][]string{{s.label, "Policies", "Conditions", "Time Series", "Price", "Share"}}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Chargeback by %s:\n", s.label)
+	for _, value := range values {
+		group := s.groups[value]
+		share := 0.0
+		if s.total > 0 {
+			share = group.price / s.total * 100
+		}
+		fmt.Fprintf(&b, "  %s: %d policies with %d condition(s) and %d time series, $%f (%.1f%%)\n", value, group.policies, group.conditions, group.timeSeries, group.price, share)
+		records = append(records, []string{value, strconv.Itoa(group.policies), strconv.Itoa(group.conditions), strconv.Itoa(group.timeSeries), strconv.FormatFloat(group.price, 'f', 2, 64), strconv.FormatFloat(share, 'f', 1, 64)})
+	}
+	fmt.Fprintf(&b, "  Total: %d policies, $%f", s.policies, s.total)
+	log.Println(b.String())
+	if partial {
+		log.Println("The chargeback is based on partial results, as the run was stopped before all policies were processed")
+	}
+	if s.path == "" {
+		return nil
+	}
+	return writeCSVOutput(s.path, s.storage, records)
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+)
+
+// chatTopPolicies is the number of the most expensive policies listed in the Google Chat card
+const chatTopPolicies = 10
+
+// chatSink posts a card with a summary of the run to a Google Chat webhook once all results have been received
+type chatSink struct {
+	webhook   string
+	client    *http.Client
+	baseline  *baseline
+	policies  int
+	price     float64
+	failed    int
+	expensive topPolicies
+}
+
+func newChatSink(webhook string, client *http.Client, b *baseline) *chatSink {
+	return &chatSink{webhook: webhook, client: client, baseline: b, expensive: topPolicies{n: chatTopPolicies}}
+}
+
+func (s *chatSink) write(p *policy) error {
+	s.policies++
+	s.price += p.Price
+	if p.Error != "" {
+		s.failed++
+	}
+	s.expensive.add(p)
+	return nil
+}
+
+func (s *chatSink) close(partial bool) error {
+	total := fmt.Sprintf("<b>$%.2f</b> per month for %d policies", s.price, s.policies)
+	if s.baseline != nil {
+		total += fmt.Sprintf(" (%s vs. baseline)", formatDelta(s.price-s.baseline.total))
+	}
+	summary := []map[string]any{
+		{"decoratedText": map[string]any{"topLabel": "Total", "text": total}},
+	}
+	if s.failed > 0 {
+		summary = append(summary, map[string]any{"decoratedText": map[string]any{"topLabel": "Failed", "text": fmt.Sprintf("%d policies failed to be estimated", s.failed)}})
+	}
+	if partial {
+		summary = append(summary, map[string]any{"textParagraph": map[string]any{"text": "The run was stopped before all policies were processed, the results are incomplete."}})
+	}
+	// Each policy links to its page in the Cloud Console
+	var top []map[string]any
+	for _, p := range s.expensive.sorted() {
+		price := fmt.Sprintf("$%.2f", p.Price)
+		if delta, ok := s.baseline.delta(p); ok {
+			price += " (" + formatDelta(delta) + ")"
+		} else if s.baseline != nil {
+			price += " (new)"
+		}
+		top = append(top, map[string]any{"decoratedText": map[string]any{
+			"topLabel": p.ProjectId,
+			"text":     fmt.Sprintf("%s: %s", html.EscapeString(p.DisplayName), price),
+			"button": map[string]any{
+				"text":    "Open",
+				"onClick": map[string]any{"openLink": map[string]any{"url": policyLink(p)}},
+			},
+		}})
+	}
+	sections := []map[string]any{{"widgets": summary}}
+	if len(top) > 0 {
+		sections = append(sections, map[string]any{"header": fmt.Sprintf("Top %d policies", len(top)), "widgets": top})
+	}
+	body, err := json.Marshal(map[string]any{
+		"cardsV2": []map[string]any{{
+			"cardId": "appe",
+			"card": map[string]any{
+				"header":   map[string]any{"title": "Alerting Policy Price Estimate"},
+				"sections": sections,
+			},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	// The results were already output, so a failed notification doesn't fail the run
+	err = postJSON(s.client, s.webhook, body, nil)
+	if err != nil {
+		slog.Error("Failed to post summary to Google Chat", "error", err)
+	}
+	return nil
+}
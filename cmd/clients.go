@@ -0,0 +1,402 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	metricsscope "cloud.google.com/go/monitoring/metricsscope/apiv1"
+	resourcemanager "cloud.google.com/go/resourcemanager/apiv3"
+	"golang.org/x/time/rate"
+	bigquery "google.golang.org/api/bigquery/v2"
+	billingbudgets "google.golang.org/api/billingbudgets/v1"
+	cloudasset "google.golang.org/api/cloudasset/v1"
+	cloudbilling "google.golang.org/api/cloudbilling/v1"
+	firestore "google.golang.org/api/firestore/v1"
+	logging "google.golang.org/api/logging/v2"
+	monitoring_v1 "google.golang.org/api/monitoring/v1"
+	"google.golang.org/api/option"
+	pubsub "google.golang.org/api/pubsub/v1"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// clients are all API clients that use the same credentials
+type clients struct {
+	alertPolicy   *monitoring.AlertPolicyClient
+	query         *monitoring.QueryClient
+	metric        *monitoring.MetricClient
+	projects      *resourcemanager.ProjectsClient
+	folders       *resourcemanager.FoldersClient
+	organizations *resourcemanager.OrganizationsClient
+	metricsScopes *metricsscope.MetricsScopesClient
+	asset         *cloudasset.Service
+	billing       *cloudbilling.APIService
+	bigquery      *bigquery.Service
+	budgets       *billingbudgets.Service
+	storage       *storage.Service
+	pubsub        *pubsub.Service
+	firestore     *firestore.Service
+	logging       *logging.Service
+	monitoring_v1 *monitoring_v1.Service
+	// quota attributes the quota of requests to the project they are made for, if --quotaPerProject is set
+	quota *quotaAttribution
+	// estimator estimates the price of policies with the clients
+	estimator *estimator
+}
+
+// services are the REST services that only some inputs and outputs use, so that they are only created if one of them is enabled
+type services struct {
+	asset     bool
+	billing   bool
+	bigquery  bool
+	budgets   bool
+	storage   bool
+	pubsub    bool
+	firestore bool
+	logging   bool
+}
+
+// neededServices returns the services that the inputs and outputs enabled by o use
+func neededServices(o *runOptions) services {
+	return services{
+		asset:     o.discovery == "asset",
+		billing:   o.checkCatalog,
+		bigquery:  o.bigQueryTableName != "",
+		budgets:   o.billingAccount != "",
+		storage:   isGCSObject(o.csvOut) || isGCSObject(o.lookerStudioOut) || isGCSObject(o.baselineOut) || o.snapshotBucket != "" || isGCSObject(o.chargebackOut) || isGCSObject(o.overlapsOut) || isGCSObject(o.unusedMetricsOut) || isGCSObject(o.metricIngestionOut) || isGCSObject(o.recommendationsOut) || isGCSObject(o.sarifOut) || slices.ContainsFunc(o.terraformStates, isGCSObject),
+		pubsub:    o.pubsubSubscription != "" || o.pubsubTopic != "",
+		firestore: o.firestoreCollectionPath != "",
+		logging:   o.ingestion,
+	}
+}
+
+// newClients creates all gRPC clients with clientOptions and the REST clients with restOptions, of the optional services only those that are needed.
+// The monitoring and resource manager clients additionally use their own options, the policy and time series clients wait for their rate limiters before every call.
+func newClients(ctx context.Context, needed services, clientOptions []option.ClientOption, restOptions []option.ClientOption, monitoringOptions []option.ClientOption, resourcemanagerOptions []option.ClientOption, monitoringRESTOptions []option.ClientOption, policiesRate *rate.Limiter, timeSeriesRate *rate.Limiter) (*clients, error) {
+	c := &clients{}
+	var err error
+	c.alertPolicy, err = monitoring.NewAlertPolicyClient(ctx, slices.Concat(clientOptions, monitoringOptions, []option.ClientOption{rateLimitOption(policiesRate)})...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create alert policy client: %v", err)
+	}
+	c.query, err = monitoring.NewQueryClient(ctx, slices.Concat(clientOptions, monitoringOptions, []option.ClientOption{rateLimitOption(timeSeriesRate)})...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create query client: %v", err)
+	}
+	c.metric, err = monitoring.NewMetricClient(ctx, slices.Concat(clientOptions, monitoringOptions, []option.ClientOption{rateLimitOption(timeSeriesRate)})...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metric client: %v", err)
+	}
+	c.projects, err = resourcemanager.NewProjectsClient(ctx, slices.Concat(clientOptions, resourcemanagerOptions)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create projects client: %v", err)
+	}
+	c.folders, err = resourcemanager.NewFoldersClient(ctx, slices.Concat(clientOptions, resourcemanagerOptions)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create folders client: %v", err)
+	}
+	c.organizations, err = resourcemanager.NewOrganizationsClient(ctx, slices.Concat(clientOptions, resourcemanagerOptions)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create organizations client: %v", err)
+	}
+	c.metricsScopes, err = metricsscope.NewMetricsScopesClient(ctx, slices.Concat(clientOptions, monitoringOptions)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metrics scopes client: %v", err)
+	}
+	c.monitoring_v1, err = monitoring_v1.NewService(ctx, slices.Concat(restOptions, monitoringRESTOptions)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create monitoring v1 client: %v", err)
+	}
+	if needed.asset {
+		c.asset, err = cloudasset.NewService(ctx, restOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cloud asset client: %v", err)
+		}
+	}
+	if needed.billing {
+		c.billing, err = cloudbilling.NewService(ctx, restOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create cloud billing client: %v", err)
+		}
+	}
+	if needed.bigquery {
+		c.bigquery, err = bigquery.NewService(ctx, restOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create bigquery client: %v", err)
+		}
+	}
+	if needed.budgets {
+		c.budgets, err = billingbudgets.NewService(ctx, restOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create billing budgets client: %v", err)
+		}
+	}
+	if needed.storage {
+		c.storage, err = storage.NewService(ctx, restOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create storage client: %v", err)
+		}
+	}
+	if needed.pubsub {
+		c.pubsub, err = pubsub.NewService(ctx, restOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create pubsub client: %v", err)
+		}
+	}
+	if needed.firestore {
+		c.firestore, err = firestore.NewService(ctx, restOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create firestore client: %v", err)
+		}
+	}
+	if needed.logging {
+		c.logging, err = logging.NewService(ctx, restOptions...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create logging client: %v", err)
+		}
+	}
+	return c, nil
+}
+
+// clientRouter decides which clients to use for a target, so that different organizations, folders or projects can be scanned with different credentials.
+// Projects use the clients of the target they were found in, or the default clients if they weren't found in any target with its own credentials.
+type clientRouter struct {
+	defaults *clients
+	// targets maps targets like "organizations/123" to their clients
+	targets map[string]*clients
+	// projects maps the IDs of found projects to the clients of their target
+	projects sync.Map
+}
+
+// newClientRouter returns a router that uses the default clients for all targets until others are added
+func newClientRouter(defaults *clients) *clientRouter {
+	return &clientRouter{defaults: defaults, targets: map[string]*clients{}}
+}
+
+// add makes the router use c for target, e.g. "folders/123"
+func (r *clientRouter) add(target string, c *clients) {
+	r.targets[target] = c
+}
+
+// resolved makes the router use the clients of a target given by its number, display name path or domain for its ID as well
+func (r *clientRouter) resolved(target string, id string) {
+	kind, _, _ := strings.Cut(target, "/")
+	if c, ok := r.targets[target]; ok {
+		r.targets[kind+"/"+id] = c
+	}
+}
+
+// all returns the default clients and all clients that were added
+func (r *clientRouter) all() []*clients {
+	all := []*clients{r.defaults}
+	for _, c := range r.targets {
+		if !slices.Contains(all, c) {
+			all = append(all, c)
+		}
+	}
+	return all
+}
+
+// forTarget returns the clients to use for target
+func (r *clientRouter) forTarget(target string) *clients {
+	if c, ok := r.targets[target]; ok {
+		return c
+	}
+	return r.defaults
+}
+
+// forProject returns the clients of the target the project was found in
+func (r *clientRouter) forProject(projectId string) *clients {
+	if c, ok := r.projects.Load(projectId); ok {
+		return c.(*clients)
+	}
+	return r.forTarget("projects/" + projectId)
+}
+
+// route returns a channel that assigns c to the project of every item put on it, before passing the item on to out.
+// The returned function must be called once all items were put on the channel and returns once all of them were passed on.
+// If c are the default clients, there is nothing to assign and out is returned directly.
+func route[T any](r *clientRouter, c *clients, out chan T, projectId func(T) string) (chan T, func()) {
+	if c == r.defaults {
+		return out, func() {}
+	}
+	in := make(chan T)
+	done := make(chan struct{})
+	go func() {
+		for item := range in {
+			r.projects.LoadOrStore(projectId(item), c)
+			out <- item
+		}
+		close(done)
+	}()
+	return in, func() {
+		close(in)
+		<-done
+	}
+}
+
+// parseTargetCredentials parses credentials files for targets in the format "TARGET=PATH", e.g. "organizations/123=customer.json"
+func parseTargetCredentials(pairs []string) (map[string]string, error) {
+	targetCredentials := make(map[string]string, len(pairs))
+	for i := range pairs {
+		target, path, found := strings.Cut(pairs[i], "=")
+		kind, name, _ := strings.Cut(target, "/")
+		if !found || path == "" || name == "" || (kind != "organizations" && kind != "folders" && kind != "projects") {
+			return nil, fmt.Errorf("target credentials %q must be in the format organizations/ORG=PATH, folders/FOLDER=PATH or projects/PROJECT=PATH", pairs[i])
+		}
+		targetCredentials[target] = path
+	}
+	return targetCredentials, nil
+}
+
+// runClients are the clients of a run for every credentials file, along with the fake or replay that may answer their requests
+type runClients struct {
+	router         *clientRouter
+	timeSeriesRate *rate.Limiter
+	// metrics writes the metrics of --metricsProject and --costMetricsProject, if one of them was given
+	metrics *monitoring.MetricClient
+	// stops stop the fake or replay once the run is done
+	stops []func()
+}
+
+// newRunClients creates the clients of a run with the options of its flags. They must be closed once the run is done. If a recorder
+// is given, the responses of all requests to Cloud Monitoring and Resource Manager are recorded, and replay answers them instead of
+// Google Cloud if it is given.
+func newRunClients(ctx context.Context, o *runOptions, selfMetrics *runMetrics, replay *replayServer, recorder *apiRecorder) (*runClients, error) {
+	r := &runClients{}
+	// All clients share the same options, the policy and time series clients additionally wait for their rate limiters before every call.
+	// The gRPC connections of the monitoring clients can be tuned, as they handle by far the most calls.
+	clientOptions := slices.Concat([]option.ClientOption{option.WithQuotaProject(o.quotaProject), option.WithScopes(o.scopes...)}, o.apiProxy.options())
+	// Failed calls of the gRPC clients are counted for the metrics of the run
+	monitoringOptions := append(grpcOptions(o.grpcPoolSize, o.grpcKeepalive, o.grpcKeepaliveTimeout), selfMetrics.errorCountOption())
+	// Requests can be redirected to other endpoints, e.g. for Private Service Connect
+	monitoringGRPCEndpoint, monitoringRESTEndpoint := endpointOptions(o.monitoringEndpoint)
+	resourcemanagerOptions, _ := endpointOptions(o.resourcemanagerEndpoint)
+	// The fake answers all requests to Cloud Monitoring and Resource Manager locally, e.g. to try appe without access to Google Cloud
+	if o.fakeServer != nil {
+		err := o.fakeServer.start()
+		if err != nil {
+			return nil, fmt.Errorf("failed to start fake: %v", err)
+		}
+		r.stops = append(r.stops, o.fakeServer.stop)
+		monitoringGRPCEndpoint, monitoringRESTEndpoint = o.fakeServer.clientOptions()
+		resourcemanagerOptions = slices.Clone(monitoringGRPCEndpoint)
+		clientOptions = append(clientOptions, option.WithoutAuthentication())
+		slog.Info("Using the fake instead of Google Cloud", "data", o.fake)
+	}
+	// A replay answers all requests to Cloud Monitoring and Resource Manager with the responses of a recording
+	if replay != nil {
+		err := replay.start()
+		if err != nil {
+			return nil, fmt.Errorf("failed to start replay: %v", err)
+		}
+		r.stops = append(r.stops, replay.stop)
+		monitoringGRPCEndpoint, monitoringRESTEndpoint = replay.clientOptions()
+		resourcemanagerOptions = slices.Clone(monitoringGRPCEndpoint)
+		clientOptions = append(clientOptions, option.WithoutAuthentication())
+	}
+	// A recording captures the responses of all requests to Cloud Monitoring and Resource Manager, so that the run can be replayed with --replay
+	if recorder != nil {
+		monitoringGRPCEndpoint = append(monitoringGRPCEndpoint, recorder.grpcOption())
+		resourcemanagerOptions = append(resourcemanagerOptions, recorder.grpcOption())
+	}
+	monitoringOptions = append(monitoringOptions, monitoringGRPCEndpoint...)
+	resourcemanagerOptions = append(resourcemanagerOptions, selfMetrics.errorCountOption())
+	policiesRate := newRateLimiter(o.qpsPolicies)
+	r.timeSeriesRate = newRateLimiter(o.qpsTimeSeries)
+	needed := neededServices(o)
+
+	// Each credentials file gets its own set of clients
+	clientsByCredentials := map[string]*clients{}
+	clientsFor := func(credentials string) (*clients, error) {
+		if c, ok := clientsByCredentials[credentials]; ok {
+			return c, nil
+		}
+		options, err := credentialsOptions(ctx, clientOptions, credentials, o.scopes, o.apiProxy)
+		if err != nil {
+			return nil, err
+		}
+		restOptions, err := o.apiProxy.restOptions(ctx, options)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP client: %v", err)
+		}
+		c, err := newClients(ctx, needed, options, restOptions, monitoringOptions, resourcemanagerOptions, monitoringRESTEndpoint, policiesRate, r.timeSeriesRate)
+		if err != nil {
+			return nil, err
+		}
+		if recorder != nil {
+			c.monitoring_v1, err = recordingMonitoringV1(ctx, recorder, slices.Concat(restOptions, monitoringRESTEndpoint))
+			if err != nil {
+				return nil, err
+			}
+		}
+		// If requested, the quota of each project is used for its own requests
+		if o.quotaPerProject {
+			c.quota = newQuotaAttribution(c.projects)
+		}
+		clientsByCredentials[credentials] = c
+		return c, nil
+	}
+	// Targets given with --targetCredentials are scanned with their own credentials, all others with the default credentials
+	defaults, err := clientsFor(o.credentials)
+	if err != nil {
+		r.close()
+		return nil, err
+	}
+	r.router = newClientRouter(defaults)
+	for target, path := range o.targetCredentials {
+		c, err := clientsFor(path)
+		if err != nil {
+			r.close()
+			return nil, err
+		}
+		r.router.add(target, c)
+	}
+
+	// Writing metrics needs a broader scope than the read-only scopes of all other clients, otherwise the client is set up like them with the default credentials
+	if o.metricsProject != "" || o.costMetricsProject != "" {
+		metricsOptions := slices.Concat(clientOptions, monitoringGRPCEndpoint, []option.ClientOption{option.WithScopes(monitoringWriteScope)})
+		options, err := credentialsOptions(ctx, metricsOptions, o.credentials, []string{monitoringWriteScope}, o.apiProxy)
+		if err != nil {
+			r.close()
+			return nil, err
+		}
+		r.metrics, err = monitoring.NewMetricClient(ctx, options...)
+		if err != nil {
+			r.close()
+			return nil, fmt.Errorf("failed to create metrics client: %v", err)
+		}
+	}
+	return r, nil
+}
+
+// credentialsOptions returns clientOptions with the credentials file at path, or clientOptions if path is empty.
+// With a proxy, the tokens of the default credentials are requested through it as well.
+func credentialsOptions(ctx context.Context, clientOptions []option.ClientOption, path string, scopes []string, apiProxy *proxy) ([]option.ClientOption, error) {
+	if path != "" {
+		creds, err := loadCredentials(ctx, path, scopes, apiProxy.client())
+		if err != nil {
+			return nil, fmt.Errorf("failed to load credentials %s: %v", path, err)
+		}
+		return append(slices.Clip(clientOptions), option.WithAuthCredentials(creds)), nil
+	}
+	if apiProxy != nil {
+		creds, err := defaultCredentials(scopes, apiProxy.client())
+		if err != nil {
+			return nil, fmt.Errorf("failed to load credentials: %v", err)
+		}
+		return append(slices.Clip(clientOptions), option.WithAuthCredentials(creds)), nil
+	}
+	return slices.Clip(clientOptions), nil
+}
+
+// close stops the fake or replay
+func (r *runClients) close() {
+	for _, stop := range slices.Backward(r.stops) {
+		stop()
+	}
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/spf13/pflag"
+)
+
+// defaultConfigPath returns the path of the config file that is used if --config is not set, e.g. ~/.config/appe/config on Linux
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "appe", "config")
+}
+
+// resetFlags sets all flags back to their default values and marks them as not given
+func resetFlags(flags *pflag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if err != nil {
+			return
+		}
+		// Setting a slice flag appends to it, so it needs to be replaced with the values of its default, e.g. "[a,b]"
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			var values []string
+			if defaults := strings.Trim(flag.DefValue, "[]"); defaults != "" {
+				values = strings.Split(defaults, ",")
+			}
+			err = slice.Replace(values)
+		} else {
+			err = flag.Value.Set(flag.DefValue)
+		}
+		flag.Changed = false
+	})
+	return err
+}
+
+// loadEnv sets all flags that weren't given on the command line to the value of their environment variable, if it is set.
+// The variable of a flag is its name in upper snake case with the prefix "APPE_", e.g. APPE_CSV_OUT for --csvOut.
+func loadEnv(flags *pflag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		value, ok := os.LookupEnv(envName(flag.Name))
+		if !ok || flag.Changed || err != nil {
+			return
+		}
+		if setErr := flags.Set(flag.Name, value); setErr != nil {
+			err = fmt.Errorf("%s: %v", envName(flag.Name), setErr)
+		}
+	})
+	return err
+}
+
+// envName returns the name of the environment variable of a flag
+func envName(flag string) string {
+	var b strings.Builder
+	b.WriteString("APPE_")
+	for i, r := range flag {
+		if i > 0 && unicode.IsUpper(r) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// loadConfig sets all flags that weren't given on the command line to their value in the config file at path.
+// The file contains one flag per line in the format "name = value", empty lines and lines starting with "#" are ignored.
+// If the file doesn't exist and it isn't required, nothing happens.
+func loadConfig(flags *pflag.FlagSet, path string, required bool) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// Flags may be set on multiple lines to add more values to them, so we need to know which ones were given on the command line before setting any
+	changed := map[string]bool{}
+	flags.Visit(func(flag *pflag.Flag) {
+		changed[flag.Name] = true
+	})
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		name, value, found := strings.Cut(text, "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return fmt.Errorf("%s:%d: must be in the format name = value", path, line)
+		}
+		flag := flags.Lookup(name)
+		if flag == nil {
+			return fmt.Errorf("%s:%d: unknown flag %q", path, line, name)
+		}
+		if changed[name] {
+			continue
+		}
+		err = flags.Set(name, strings.Trim(strings.TrimSpace(value), `"`))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+	}
+	return scanner.Err()
+}
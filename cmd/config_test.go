@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		args    []string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:   "values",
+			config: "# defaults\n\nquotaProject = billing\nthreads=16\ncsvOut = \"out.csv\"\n",
+			want:   map[string]string{"quotaProject": "billing", "threads": "16", "csvOut": "out.csv"},
+		},
+		{
+			name:   "command line takes precedence",
+			config: "quotaProject = billing\nthreads = 16\n",
+			args:   []string{"--threads", "4"},
+			want:   map[string]string{"quotaProject": "billing", "threads": "4"},
+		},
+		{name: "unknown flag", config: "quotaProject = billing\nthread = 16\n", wantErr: `:2: unknown flag "thread"`},
+		{name: "missing value", config: "quotaProject\n", wantErr: ":1: must be in the format name = value"},
+		{name: "invalid value", config: "threads = many\n", wantErr: ":1: invalid argument"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flags := pflag.NewFlagSet("appe", pflag.ContinueOnError)
+			flags.String("quotaProject", "", "")
+			flags.String("csvOut", "", "")
+			flags.Int("threads", 8, "")
+			err := flags.Parse(test.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "config")
+			err = os.WriteFile(path, []byte(test.config), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = loadConfig(flags, path, true)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range test.want {
+				if got := flags.Lookup(name).Value.String(); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	flags := pflag.NewFlagSet("appe", pflag.ContinueOnError)
+	if err := loadConfig(flags, path, false); err != nil {
+		t.Errorf("error = %v, want a missing default config file to be ignored", err)
+	}
+	if err := loadConfig(flags, path, true); err == nil {
+		t.Error("error = nil, want an error for a missing config file given with --config")
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "values",
+			env:  map[string]string{"APPE_QUOTA_PROJECT": "billing", "APPE_THREADS": "16", "APPE_CSV_OUT": "gs://bucket/out.csv"},
+			want: map[string]string{"quotaProject": "billing", "threads": "16", "csvOut": "gs://bucket/out.csv"},
+		},
+		{
+			name: "command line takes precedence",
+			env:  map[string]string{"APPE_QUOTA_PROJECT": "billing", "APPE_THREADS": "16"},
+			args: []string{"--threads", "4"},
+			want: map[string]string{"quotaProject": "billing", "threads": "4"},
+		},
+		{name: "other variables", env: map[string]string{"APPE_THREAD": "16", "THREADS": "16"}, want: map[string]string{"threads": "8"}},
+		{name: "invalid value", env: map[string]string{"APPE_THREADS": "many"}, wantErr: "APPE_THREADS: "},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for name, value := range test.env {
+				t.Setenv(name, value)
+			}
+			flags := pflag.NewFlagSet("appe", pflag.ContinueOnError)
+			flags.String("quotaProject", "", "")
+			flags.String("csvOut", "", "")
+			flags.Int("threads", 8, "")
+			err := flags.Parse(test.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = loadEnv(flags)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range test.want {
+				if got := flags.Lookup(name).Value.String(); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
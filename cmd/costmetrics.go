@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// costMetricsBatch is the maximum number of time series that can be written to Cloud Monitoring at once
+const costMetricsBatch = 200
+
+// costMetricType is the custom metric that the estimated price of every policy is written to
+const costMetricType = "custom.googleapis.com/appe/policy_cost"
+
+// costMetricsSink writes the estimated monthly price of every policy as a custom metric to a project, labeled by the project and name of the policy,
+// so that dashboards and alerts on the price of alerting can be built in Cloud Monitoring itself.
+// The time series are written in batches, at least every minute. Failed batches are only logged.
+type costMetricsSink struct {
+	ctx        context.Context
+	client     *monitoring.MetricClient
+	projectId  string
+	timeSeries []*monitoringpb.TimeSeries
+	flushed    time.Time
+}
+
+func newCostMetricsSink(ctx context.Context, client *monitoring.MetricClient, projectId string) *costMetricsSink {
+	return &costMetricsSink{ctx: ctx, client: client, projectId: projectId, flushed: time.Now()}
+}
+
+func (s *costMetricsSink) write(p *policy) error {
+	if p.Error != "" {
+		return nil
+	}
+	s.timeSeries = append(s.timeSeries, &monitoringpb.TimeSeries{
+		Metric: &metricpb.Metric{
+			Type: costMetricType,
+			Labels: map[string]string{
+				"policy_project": p.ProjectId,
+				"policy_name":    p.Name,
+				"display_name":   p.DisplayName,
+			},
+		},
+		Resource: &monitoredrespb.MonitoredResource{
+			Type:   "global",
+			Labels: map[string]string{"project_id": s.projectId},
+		},
+		MetricKind: metricpb.MetricDescriptor_GAUGE,
+		Unit:       "USD",
+		Points: []*monitoringpb.Point{{
+			Interval: &monitoringpb.TimeInterval{EndTime: timestamppb.Now()},
+			Value:    &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_DoubleValue{DoubleValue: p.Price}},
+		}},
+	})
+	if len(s.timeSeries) >= costMetricsBatch || time.Since(s.flushed) > time.Minute {
+		s.flush()
+	}
+	return nil
+}
+
+// flush writes all buffered time series
+func (s *costMetricsSink) flush() {
+	s.flushed = time.Now()
+	if len(s.timeSeries) == 0 {
+		return
+	}
+	err := s.client.CreateTimeSeries(s.ctx, &monitoringpb.CreateTimeSeriesRequest{
+		Name:       "projects/" + s.projectId,
+		TimeSeries: s.timeSeries,
+	})
+	if err != nil {
+		slog.Error("Failed to write cost metrics", "project", s.projectId, "timeSeries", len(s.timeSeries), "error", err, "code", errorCode(err))
+	}
+	s.timeSeries = s.timeSeries[:0]
+}
+
+func (s *costMetricsSink) close(partial bool) error {
+	s.flush()
+	return nil
+}
@@ -0,0 +1,345 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"google.golang.org/api/iterator"
+	monitoring_v1 "google.golang.org/api/monitoring/v1"
+	storage "google.golang.org/api/storage/v1"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// metricMebibytePrice is the price of ingesting a MiB of chargeable metrics into Cloud Monitoring in the first paid tier of $0.2580 per MiB
+	metricMebibytePrice = 0.258
+	// scalarPointBytes and distributionPointBytes are the sizes that Cloud Monitoring charges for a point
+	scalarPointBytes       = 8
+	distributionPointBytes = 80
+	// metricSampleSeries is the number of time series of a metric whose points are counted to estimate the rate of all of its time series
+	metricSampleSeries = 10
+)
+
+var (
+	// chargeableMetricPrefixes are the prefixes of the metric types that are charged by the ingested bytes
+	chargeableMetricPrefixes = []string{"custom.googleapis.com/", "external.googleapis.com/", "workload.googleapis.com/", logMetricPrefix}
+	// prometheusMetricPrefix is the prefix of the metric types of Managed Service for Prometheus, which are charged by the ingested samples instead
+	prometheusMetricPrefix = "external.googleapis.com/prometheus/"
+)
+
+// listChargeableMetrics returns the descriptors of all metrics of a project that are charged by the ingested bytes
+func listChargeableMetrics(ctx context.Context, metricClient *monitoring.MetricClient, projectId string) ([]*metricpb.MetricDescriptor, error) {
+	var filters []string
+	for _, prefix := range chargeableMetricPrefixes {
+		filters = append(filters, fmt.Sprintf("metric.type = starts_with(%q)", prefix))
+	}
+	it := metricClient.ListMetricDescriptors(ctx, &monitoringpb.ListMetricDescriptorsRequest{
+		Name:   "projects/" + projectId,
+		Filter: strings.Join(filters, " OR "),
+	})
+	var descriptors []*metricpb.MetricDescriptor
+	for {
+		descriptor, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return descriptors, nil
+		}
+		if err != nil {
+			return descriptors, err
+		}
+		if !strings.HasPrefix(descriptor.GetType(), prometheusMetricPrefix) {
+			descriptors = append(descriptors, descriptor)
+		}
+	}
+}
+
+// metricIngestion is the estimated ingestion of a single metric per month
+type metricIngestion struct {
+	timeSeries int
+	bytes      float64
+}
+
+// estimateMetricIngestion estimates the bytes a metric ingests per month from its points between start and end.
+// All time series of the metric are counted, but only the points of the first metricSampleSeries of them,
+// whose average number of points is assumed for all others.
+func estimateMetricIngestion(ctx context.Context, metricClient *monitoring.MetricClient, projectId string, descriptor *metricpb.MetricDescriptor, start *timestamppb.Timestamp, end *timestamppb.Timestamp) (metricIngestion, error) {
+	request := &monitoringpb.ListTimeSeriesRequest{
+		Name:     "projects/" + projectId,
+		Filter:   fmt.Sprintf("metric.type = %q", descriptor.GetType()),
+		Interval: &monitoringpb.TimeInterval{StartTime: start, EndTime: end},
+		View:     monitoringpb.ListTimeSeriesRequest_HEADERS,
+	}
+	var ingestion metricIngestion
+	it := metricClient.ListTimeSeries(ctx, request)
+	for {
+		_, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return ingestion, err
+		}
+		ingestion.timeSeries++
+	}
+	if ingestion.timeSeries == 0 {
+		return ingestion, nil
+	}
+
+	request.View = monitoringpb.ListTimeSeriesRequest_FULL
+	it = metricClient.ListTimeSeries(ctx, request)
+	it.PageInfo().MaxSize = metricSampleSeries
+	sampled, points := 0, 0
+	for sampled < metricSampleSeries {
+		timeSeries, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return ingestion, err
+		}
+		sampled++
+		points += len(timeSeries.GetPoints())
+	}
+	if sampled == 0 {
+		return ingestion, nil
+	}
+	pointBytes := scalarPointBytes
+	if descriptor.GetValueType() == metricpb.MetricDescriptor_DISTRIBUTION {
+		pointBytes = distributionPointBytes
+	}
+	// The points of the window are scaled to 30 days
+	perMonth := float64(30*24*time.Hour) / float64(end.AsTime().Sub(start.AsTime()))
+	ingestion.bytes = float64(points) / float64(sampled) * float64(ingestion.timeSeries) * float64(pointBytes) * perMonth
+	return ingestion, nil
+}
+
+// mqlMetricPattern matches the metric types in a query, e.g. custom.googleapis.com/my_metric
+var mqlMetricPattern = regexp.MustCompile(`[a-z0-9.-]+\.googleapis\.com/[A-Za-z0-9_/.]+`)
+
+// conditionMetrics returns the metric types and PromQL metric names that the conditions of a policy reference
+func conditionMetrics(conditions []*monitoringpb.AlertPolicy_Condition) []string {
+	var metrics []string
+	for _, condition := range conditions {
+		for _, filter := range []string{condition.GetConditionThreshold().GetFilter(), condition.GetConditionThreshold().GetDenominatorFilter(), condition.GetConditionAbsent().GetFilter()} {
+			if metricType := filterValue(filter, "metric.type"); metricType != "" {
+				metrics = append(metrics, metricType)
+			}
+		}
+		if mql := condition.GetConditionMonitoringQueryLanguage(); mql != nil {
+			metrics = append(metrics, mqlMetricPattern.FindAllString(mql.GetQuery(), -1)...)
+		}
+		if pql := condition.GetConditionPrometheusQueryLanguage(); pql != nil {
+			metrics = append(metrics, mqlMetricPattern.FindAllString(pql.GetQuery(), -1)...)
+			metrics = append(metrics, promQLMetricNames(pql.GetQuery())...)
+		}
+	}
+	slices.Sort(metrics)
+	return slices.Compact(metrics)
+}
+
+// promQLMetricName returns the name of a Cloud Monitoring metric in PromQL, e.g. custom_googleapis_com:my_metric for custom.googleapis.com/my_metric
+func promQLMetricName(metricType string) string {
+	domain, path, _ := strings.Cut(metricType, "/")
+	return strings.ReplaceAll(domain, ".", "_") + ":" + strings.NewReplacer(".", "_", "/", "_").Replace(path)
+}
+
+// listDashboards returns the JSON of all dashboards of a project
+func listDashboards(ctx context.Context, monitoring_v1Service *monitoring_v1.Service, projectId string) ([]string, error) {
+	var dashboards []string
+	call := monitoring_v1Service.Projects.Dashboards.List("projects/" + projectId)
+	if quotaProject := contextQuotaProject(ctx); quotaProject != "" {
+		call.Header().Set(quotaProjectHeader, quotaProject)
+	}
+	err := call.Pages(ctx, func(response *monitoring_v1.ListDashboardsResponse) error {
+		for _, dashboard := range response.Dashboards {
+			j, err := dashboard.MarshalJSON()
+			if err != nil {
+				return err
+			}
+			dashboards = append(dashboards, string(j))
+		}
+		return nil
+	})
+	return dashboards, err
+}
+
+// writeCSVOutput writes records to a CSV file at path, which may be a GCS object given as gs://BUCKET/OBJECT
+func writeCSVOutput(path string, storageService *storage.Service, records [][]string) error {
+	f, upload, err := createOutput(path, storageService)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	err = w.WriteAll(records)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return finishOutput(f, upload)
+}
+
+// customMetricsReport estimates the ingestion of chargeable metrics in every scanned project once the run is done.
+// It writes the ingestion of each project next to the price of its alerting policies, and the metrics that are ingested
+// but not referenced by any policy of the scan, or optionally any dashboard of the scanned projects, as candidates for deletion.
+type customMetricsReport struct {
+	ctx           context.Context
+	router        *clientRouter
+	storage       *storage.Service
+	start         *timestamppb.Timestamp
+	end           *timestamppb.Timestamp
+	ingestionPath string
+	unusedPath    string
+	dashboards    bool
+	mu            sync.Mutex
+	alerting      map[string]float64
+	referenced    map[string]bool
+}
+
+func newCustomMetricsReport(ctx context.Context, router *clientRouter, storageService *storage.Service, start *timestamppb.Timestamp, end *timestamppb.Timestamp, ingestionPath string, unusedPath string, dashboards bool) *customMetricsReport {
+	return &customMetricsReport{
+		ctx:           ctx,
+		router:        router,
+		storage:       storageService,
+		start:         start,
+		end:           end,
+		ingestionPath: ingestionPath,
+		unusedPath:    unusedPath,
+		dashboards:    dashboards,
+		alerting:      map[string]float64{},
+		referenced:    map[string]bool{},
+	}
+}
+
+// addProject adds a scanned project to the report, even if it has no policies
+func (r *customMetricsReport) addProject(projectId string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.alerting[projectId]; !ok {
+		r.alerting[projectId] = 0
+	}
+}
+
+func (r *customMetricsReport) write(p *policy) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerting[p.ProjectId] += p.Price
+	for _, metric := range p.Metrics {
+		r.referenced[metric] = true
+	}
+	return nil
+}
+
+func (r *customMetricsReport) close(partial bool) error {
+	r.mu.Lock()
+	projects := make([]string, 0, len(r.alerting))
+	for projectId := range r.alerting {
+		projects = append(projects, projectId)
+	}
+	r.mu.Unlock()
+	slices.Sort(projects)
+
+	// Dashboards may show metrics of other projects in their metrics scope, so all of them are searched for every metric
+	var dashboards []string
+	if r.dashboards {
+		for _, projectId := range projects {
+			c := r.router.forProject(projectId)
+			found, err := listDashboards(c.quota.context(r.ctx, projectId), c.monitoring_v1, projectId)
+			if err != nil {
+				slog.Warn("Failed to list dashboards", "project", projectId, "error", err, "code", errorCode(err))
+			}
+			dashboards = append(dashboards, found...)
+		}
+	}
+	used := func(metricType string) bool {
+		if r.referenced[metricType] || r.referenced[promQLMetricName(metricType)] {
+			return true
+		}
+		for _, dashboard := range dashboards {
+			if strings.Contains(dashboard, metricType) || strings.Contains(dashboard, promQLMetricName(metricType)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	ingestionRecords := [][]string{{"Project ID", "Alerting Price", "Metrics", "Time Series", "Ingested MiB", "Ingestion Price"}}
+	unusedRecords := [][]string{{"Project ID", "Metric", "Time Series", "Ingested MiB", "Ingestion Price"}}
+	totalAlerting, totalIngestion, unusedIngestion := 0.0, 0.0, 0.0
+	for _, projectId := range projects {
+		c := r.router.forProject(projectId)
+		ctx := c.quota.context(r.ctx, projectId)
+		descriptors, err := listChargeableMetrics(ctx, c.metric, projectId)
+		if err != nil {
+			slog.Warn("Failed to list metric descriptors", "project", projectId, "error", err, "code", errorCode(err))
+			continue
+		}
+		var project metricIngestion
+		for _, descriptor := range descriptors {
+			ingestion, err := estimateMetricIngestion(ctx, c.metric, projectId, descriptor, r.start, r.end)
+			if err != nil {
+				slog.Warn("Failed to estimate metric ingestion", "project", projectId, "metric", descriptor.GetType(), "error", err, "code", errorCode(err))
+				continue
+			}
+			project.timeSeries += ingestion.timeSeries
+			project.bytes += ingestion.bytes
+			// Metrics without time series in the window aren't ingested, so deleting them wouldn't save anything
+			if ingestion.timeSeries > 0 && !used(descriptor.GetType()) {
+				mebibytes := ingestion.bytes / (1 << 20)
+				unusedIngestion += mebibytes * metricMebibytePrice
+				unusedRecords = append(unusedRecords, []string{
+					projectId,
+					descriptor.GetType(),
+					strconv.Itoa(ingestion.timeSeries),
+					strconv.FormatFloat(mebibytes, 'f', 2, 64),
+					strconv.FormatFloat(mebibytes*metricMebibytePrice, 'f', 2, 64),
+				})
+			}
+		}
+		mebibytes := project.bytes / (1 << 20)
+		price := mebibytes * metricMebibytePrice
+		totalAlerting += r.alerting[projectId]
+		totalIngestion += price
+		ingestionRecords = append(ingestionRecords, []string{
+			projectId,
+			strconv.FormatFloat(r.alerting[projectId], 'f', 2, 64),
+			strconv.Itoa(len(descriptors)),
+			strconv.Itoa(project.timeSeries),
+			strconv.FormatFloat(mebibytes, 'f', 2, 64),
+			strconv.FormatFloat(price, 'f', 2, 64),
+		})
+	}
+
+	if r.ingestionPath != "" {
+		err := writeCSVOutput(r.ingestionPath, r.storage, ingestionRecords)
+		if err != nil {
+			return err
+		}
+		log.Printf("Chargeable metrics of %d project(s) cost approximately $%f per month to ingest, their alerting policies $%f\n", len(projects), totalIngestion, totalAlerting)
+	}
+	if r.unusedPath != "" {
+		err := writeCSVOutput(r.unusedPath, r.storage, unusedRecords)
+		if err != nil {
+			return err
+		}
+		log.Printf("%d metric(s) are ingested for approximately $%f per month without being referenced by any alerting policy\n", len(unusedRecords)-1, unusedIngestion)
+	}
+	if partial {
+		log.Println("The metric reports only contain the projects that were scanned before the run was stopped")
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+var (
+	// datadogQueryPattern matches simple metric monitor queries, e.g. avg(last_5m):avg:gcp.gce.instance.cpu.utilization{zone:us-east1-b} by {instance_id} > 0.9
+	datadogQueryPattern = regexp.MustCompile(`^\s*(avg|sum|min|max)\(last_(\d+)([mhd])\)\s*:\s*(\w+):([\w.]+)\{([^}]*)\}(?:\.\w+\([^)]*\))*(?:\s*by\s*\{([^}]*)\})?(?:\.\w+\([^)]*\))*\s*(?:>=|<=|>|<|==|!=)\s*-?[\d.]+\s*$`)
+	// datadogResourceLabels are labels of Google Cloud metrics in Datadog that are labels of the monitored resource in Cloud Monitoring, all others are metric labels
+	datadogResourceLabels = []string{"instance_id", "zone", "region", "location", "database_id", "bucket_name", "topic_id", "subscription_id", "cluster_name", "namespace_name", "pod_name", "container_name", "service_name", "revision_name", "configuration_name", "function_name", "job_name"}
+	// datadogServices maps the services of Google Cloud metrics in Datadog to their domain in Cloud Monitoring, services that aren't listed have the same name
+	datadogServices = map[string]string{"gce": "compute", "gcs": "storage", "gke": "container"}
+	// datadogAligners maps the time aggregations of Datadog to aligners
+	datadogAligners = map[string]monitoringpb.Aggregation_Aligner{"avg": monitoringpb.Aggregation_ALIGN_MEAN, "sum": monitoringpb.Aggregation_ALIGN_SUM, "min": monitoringpb.Aggregation_ALIGN_MIN, "max": monitoringpb.Aggregation_ALIGN_MAX}
+	// datadogReducers maps the space aggregations of Datadog to reducers
+	datadogReducers = map[string]monitoringpb.Aggregation_Reducer{"avg": monitoringpb.Aggregation_REDUCE_MEAN, "sum": monitoringpb.Aggregation_REDUCE_SUM, "min": monitoringpb.Aggregation_REDUCE_MIN, "max": monitoringpb.Aggregation_REDUCE_MAX}
+	// datadogUnits are the units of the evaluation window of a monitor
+	datadogUnits = map[string]time.Duration{"m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}
+)
+
+// datadogMonitor is a monitor as it is exported from the Datadog UI or returned by its API
+type datadogMonitor struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Query string `json:"query"`
+}
+
+// readDatadogMonitors reads exported Datadog monitors (a single monitor or a list of them) and adds a policy to definitions for each
+// metric monitor whose query could be converted, as if it was migrated to Cloud Monitoring in projectId.
+// Google Cloud metrics (gcp.*) become threshold conditions on the same metric in Cloud Monitoring.
+// All other metrics become PromQL conditions, assuming that they are sent to Managed Service for Prometheus with dots replaced by underscores.
+func readDatadogMonitors(path string, projectId string, definitions policyDefinitions) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var monitors []datadogMonitor
+	err = json.Unmarshal(b, &monitors)
+	if err != nil {
+		var monitor datadogMonitor
+		if json.Unmarshal(b, &monitor) != nil {
+			return err
+		}
+		monitors = []datadogMonitor{monitor}
+	}
+	for _, monitor := range monitors {
+		if monitor.Type != "metric alert" && monitor.Type != "query alert" {
+			slog.Warn("Skipped Datadog monitor that doesn't query metrics", "monitor", monitor.Name, "type", monitor.Type)
+			continue
+		}
+		condition, err := datadogCondition(monitor.Query)
+		if err != nil {
+			slog.Warn("Skipped Datadog monitor whose query can't be converted", "monitor", monitor.Name, "error", err)
+			continue
+		}
+		condition.DisplayName = monitor.Name
+		definitions.add(projectId, "datadog", fmt.Sprint(monitor.ID), &monitoringpb.AlertPolicy{
+			DisplayName: monitor.Name,
+			Conditions:  []*monitoringpb.AlertPolicy_Condition{condition},
+		})
+	}
+	return nil
+}
+
+// datadogCondition converts the query of a metric monitor. Formulas, functions and boolean tag expressions aren't supported.
+func datadogCondition(query string) (*monitoringpb.AlertPolicy_Condition, error) {
+	match := datadogQueryPattern.FindStringSubmatch(query)
+	if match == nil {
+		return nil, fmt.Errorf("unsupported query %q", query)
+	}
+	n, err := strconv.Atoi(match[2])
+	if err != nil {
+		return nil, err
+	}
+	window := time.Duration(n) * datadogUnits[match[3]]
+	timeAggregation, spaceAggregation, metric, tags, groupBy := match[1], match[4], match[5], datadogTags(match[6]), datadogTags(match[7])
+	for _, tag := range tags {
+		if strings.ContainsAny(tag, "() ") {
+			return nil, fmt.Errorf("unsupported tags %q", match[6])
+		}
+	}
+
+	if service, name, found := strings.Cut(strings.TrimPrefix(metric, "gcp."), "."); strings.HasPrefix(metric, "gcp.") && found {
+		if domain, ok := datadogServices[service]; ok {
+			service = domain
+		}
+		filter := []string{fmt.Sprintf("metric.type = %q", service+".googleapis.com/"+strings.ReplaceAll(name, ".", "/"))}
+		for _, tag := range tags {
+			key, value, _ := strings.Cut(strings.TrimPrefix(tag, "!"), ":")
+			// All time series of a policy belong to its project
+			if key == "project_id" {
+				continue
+			}
+			expression := fmt.Sprintf("%s = %q", datadogLabel(key), value)
+			if strings.Contains(value, "*") {
+				expression = fmt.Sprintf("%s = monitoring.regex.full_match(%q)", datadogLabel(key), strings.ReplaceAll(value, "*", ".*"))
+			}
+			if strings.HasPrefix(tag, "!") {
+				expression = "NOT " + expression
+			}
+			filter = append(filter, expression)
+		}
+		aggregation := &monitoringpb.Aggregation{
+			AlignmentPeriod:    durationpb.New(window),
+			PerSeriesAligner:   datadogAligners[timeAggregation],
+			CrossSeriesReducer: datadogReducers[spaceAggregation],
+		}
+		for _, key := range groupBy {
+			aggregation.GroupByFields = append(aggregation.GroupByFields, datadogLabel(key))
+		}
+		return &monitoringpb.AlertPolicy_Condition{Condition: &monitoringpb.AlertPolicy_Condition_ConditionThreshold{
+			ConditionThreshold: &monitoringpb.AlertPolicy_Condition_MetricThreshold{
+				Filter:       strings.Join(filter, " AND "),
+				Aggregations: []*monitoringpb.Aggregation{aggregation},
+			},
+		}}, nil
+	}
+
+	var matchers []string
+	for _, tag := range tags {
+		key, value, _ := strings.Cut(strings.TrimPrefix(tag, "!"), ":")
+		negated := strings.HasPrefix(tag, "!")
+		operator := map[bool]string{false: "=", true: "!="}[negated]
+		if strings.Contains(value, "*") {
+			operator, value = map[bool]string{false: "=~", true: "!~"}[negated], strings.ReplaceAll(value, "*", ".*")
+		}
+		matchers = append(matchers, fmt.Sprintf("%s%s%q", key, operator, value))
+	}
+	promQL := fmt.Sprintf("%s_over_time(%s{%s}[%s])", timeAggregation, strings.ReplaceAll(metric, ".", "_"), strings.Join(matchers, ","), match[2]+match[3])
+	if _, ok := datadogReducers[spaceAggregation]; ok {
+		promQL = fmt.Sprintf("%s by (%s) (%s)", spaceAggregation, strings.Join(groupBy, ","), promQL)
+	}
+	return &monitoringpb.AlertPolicy_Condition{Condition: &monitoringpb.AlertPolicy_Condition_ConditionPrometheusQueryLanguage{
+		ConditionPrometheusQueryLanguage: &monitoringpb.AlertPolicy_Condition_PrometheusQueryLanguageCondition{
+			Query:              promQL,
+			EvaluationInterval: durationpb.New(time.Minute),
+		},
+	}}, nil
+}
+
+// datadogTags splits the tags of a query, e.g. "zone:us-east1-b,!env:dev". "*" stands for all time series.
+func datadogTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && tag != "*" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// datadogLabel returns the label in Cloud Monitoring of a tag of a Google Cloud metric in Datadog
+func datadogLabel(key string) string {
+	if slices.Contains(datadogResourceLabels, key) {
+		return "resource.label." + key
+	}
+	return "metric.label." + key
+}
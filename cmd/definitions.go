@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// policyFields are the fields of an alerting policy that are needed to estimate it. Tools like Config Connector and Pulumi describe policies
+// with the same structure as the API, other fields (e.g. references to notification channels) differ and are ignored.
+var policyFields = []string{"displayName", "combiner", "enabled", "conditions", "userLabels"}
+
+// invalidIdCharacters matches the characters that can't be part of the ID of a policy read from a local definition
+var invalidIdCharacters = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// policyDefinitions are alerting policies that were read from local definitions (e.g. Grafana alert rules) instead of the API.
+// They are estimated as if they were created in a target project and are looked up by their name instead of being fetched.
+type policyDefinitions map[string]*monitoringpb.AlertPolicy
+
+// add adds a policy that would be created in projectId. The source and ID (e.g. "grafana" and the UID of a rule) make up the ID of the policy.
+// Policies are enabled unless their definition says otherwise.
+func (d policyDefinitions) add(projectId string, source string, id string, policy *monitoringpb.AlertPolicy) {
+	policy.Name = fmt.Sprintf("projects/%s/alertPolicies/%s-%s", projectId, source, strings.Trim(invalidIdCharacters.ReplaceAllString(id, "-"), "-"))
+	if policy.Enabled == nil {
+		policy.Enabled = wrapperspb.Bool(true)
+	}
+	d[policy.Name] = policy
+}
+
+// names returns the names of all policies
+func (d policyDefinitions) names() []string {
+	names := make([]string, 0, len(d))
+	for name := range d {
+		names = append(names, name)
+	}
+	return names
+}
+
+// policyFromFields converts the fields of a policy in the structure of the API, e.g. the spec of a Config Connector resource, to an alerting policy
+func policyFromFields(spec map[string]any) (*monitoringpb.AlertPolicy, error) {
+	fields := map[string]any{}
+	for _, field := range policyFields {
+		if value, ok := spec[field]; ok {
+			fields[field] = value
+		}
+	}
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	policy := &monitoringpb.AlertPolicy{}
+	err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(b, policy)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+var (
+	// filterLabelPattern matches labels that are compared to a single value in a filter, e.g. resource.label.zone = "us-central1-a"
+	filterLabelPattern = regexp.MustCompile(`\b(metric|resource)\.labels?\.([A-Za-z0-9_]+)\s*=\s*"`)
+	// groupByLabelPattern matches a label in the group by fields of an aggregation, e.g. resource.labels.zone
+	groupByLabelPattern = regexp.MustCompile(`^(metric|resource)\.labels?\.([A-Za-z0-9_]+)$`)
+)
+
+// estimateTimeSeries approximates the number of time series of a threshold or absence condition without querying them.
+// Every label that is kept by the aggregations of the condition multiplies the number of time series by its cardinality.
+// The labels are taken from the metric and monitored resource descriptors, their cardinality from --labelCardinality.
+// Labels that the filter compares to a single value always have a cardinality of 1.
+func (e *estimator) estimateTimeSeries(ctx context.Context, name string, filter string, aggregations []*monitoringpb.Aggregation) (int, error) {
+	metricType := filterValue(filter, "metric.type")
+	if metricType == "" {
+		return 0, fmt.Errorf("filter %q has no metric type", filter)
+	}
+	var metricDescriptor *metricpb.MetricDescriptor
+	err := e.limiter.run(ctx, func() (err error) {
+		metricDescriptor, err = e.metricClient.GetMetricDescriptor(ctx, &monitoringpb.GetMetricDescriptorRequest{
+			Name: name + "/metricDescriptors/" + metricType,
+		})
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	labels := []string{}
+	for _, label := range metricDescriptor.GetLabels() {
+		labels = append(labels, "metric.label."+label.GetKey())
+	}
+	resourceType := filterValue(filter, "resource.type")
+	if resourceType == "" && len(metricDescriptor.GetMonitoredResourceTypes()) == 1 {
+		resourceType = metricDescriptor.GetMonitoredResourceTypes()[0]
+	}
+	if resourceType != "" {
+		var resourceDescriptor *monitoredrespb.MonitoredResourceDescriptor
+		err = e.limiter.run(ctx, func() (err error) {
+			resourceDescriptor, err = e.metricClient.GetMonitoredResourceDescriptor(ctx, &monitoringpb.GetMonitoredResourceDescriptorRequest{
+				Name: name + "/monitoredResourceDescriptors/" + resourceType,
+			})
+			return err
+		})
+		if err != nil {
+			return 0, err
+		}
+		for _, label := range resourceDescriptor.GetLabels() {
+			// All time series of a condition belong to the project of the policy
+			if label.GetKey() != "project_id" {
+				labels = append(labels, "resource.label."+label.GetKey())
+			}
+		}
+	}
+
+	// The last aggregation that reduces across time series decides which labels are kept
+	for _, aggregation := range aggregations {
+		if aggregation.GetCrossSeriesReducer() != monitoringpb.Aggregation_REDUCE_NONE {
+			labels = []string{}
+			for _, field := range aggregation.GetGroupByFields() {
+				labels = append(labels, normalizeLabel(field))
+			}
+		}
+	}
+
+	pinned := map[string]bool{}
+	for _, match := range filterLabelPattern.FindAllStringSubmatch(filter, -1) {
+		pinned[match[1]+".label."+match[2]] = true
+	}
+	count := 1
+	for _, label := range labels {
+		if !pinned[label] {
+			count *= e.labelCardinality(label)
+		}
+	}
+	return count, nil
+}
+
+// labelCardinality returns the number of distinct values that is assumed for a label, defaulting to the value given for "*" or 1
+func (e *estimator) labelCardinality(label string) int {
+	if n, ok := e.cardinalities[label]; ok {
+		return n
+	}
+	if n, ok := e.cardinalities["*"]; ok {
+		return n
+	}
+	return 1
+}
+
+// filterValue returns the value that a field (e.g. "metric.type") is compared to in a filter, or an empty string if it isn't
+func filterValue(filter string, field string) string {
+	match := regexp.MustCompile(`\b` + regexp.QuoteMeta(field) + `\s*=\s*"([^"]*)"`).FindStringSubmatch(filter)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// normalizeLabel brings the different notations of a label (e.g. "resource.labels.zone" and "resource.label.zone") into the same form
+func normalizeLabel(label string) string {
+	match := groupByLabelPattern.FindStringSubmatch(label)
+	if match == nil {
+		return label
+	}
+	return match[1] + ".label." + match[2]
+}
+
+// parseCardinalities parses label cardinalities in the format "label=count", e.g. "resource.label.zone=3"
+func parseCardinalities(pairs []string) (map[string]int, error) {
+	cardinalities := make(map[string]int, len(pairs))
+	for i := range pairs {
+		key, value, found := strings.Cut(pairs[i], "=")
+		n, err := strconv.Atoi(value)
+		if !found || key == "" || err != nil || n < 1 {
+			return nil, fmt.Errorf("label cardinality %q must be in the format label=count with a positive count", pairs[i])
+		}
+		cardinalities[normalizeLabel(key)] = n
+	}
+	return cardinalities, nil
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+)
+
+// describeQuery returns the query that would be executed to count the time series of a condition,
+// or false if the condition is not queried
+func describeQuery(condition *monitoringpb.AlertPolicy_Condition) (string, bool) {
+	if mql := condition.GetConditionMonitoringQueryLanguage(); mql != nil {
+		return "MQL: " + mql.GetQuery(), true
+	}
+	if pql := condition.GetConditionPrometheusQueryLanguage(); pql != nil {
+		return fmt.Sprintf("PromQL (every %ds): %s", pql.GetEvaluationInterval().GetSeconds(), pql.GetQuery()), true
+	}
+	if threshold := condition.GetConditionThreshold(); threshold != nil {
+		return "Threshold filter: " + threshold.GetFilter(), true
+	}
+	if absent := condition.GetConditionAbsent(); absent != nil {
+		return "Absence filter: " + absent.GetFilter(), true
+	}
+	return "", false
+}
+
+// printQueries logs the queries that would be executed to estimate a policy and returns their number
+func printQueries(alertPolicy *monitoringpb.AlertPolicy) int {
+	var b strings.Builder
+	n := 0
+	for _, condition := range alertPolicy.GetConditions() {
+		query, ok := describeQuery(condition)
+		if !ok {
+			fmt.Fprintf(&b, "  - %s: not queried\n", condition.GetDisplayName())
+			continue
+		}
+		fmt.Fprintf(&b, "  - %s: %s\n", condition.GetDisplayName(), query)
+		n++
+	}
+	// The policy is logged at once, so that the lines of policies processed in parallel don't get mixed up
+	log.Printf("Alerting Policy %s (%s) would execute %d queries:\n%s", alertPolicy.GetDisplayName(), alertPolicy.GetName(), n, strings.TrimSuffix(b.String(), "\n"))
+	return n
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+	"google.golang.org/grpc"
+)
+
+// endpointOptions returns the client options to send the requests of a gRPC client and a REST service to endpoint instead of the default endpoint of the API.
+// The endpoint is given as "host" or "host:port", e.g. a Private Service Connect endpoint like "monitoring-myendpoint.p.googleapis.com".
+// If endpoint is empty, both are nil.
+func endpointOptions(endpoint string) (grpcOption []option.ClientOption, restOption []option.ClientOption) {
+	if endpoint == "" {
+		return nil, nil
+	}
+	host := endpoint
+	if _, _, err := net.SplitHostPort(endpoint); err != nil {
+		host = net.JoinHostPort(endpoint, "443")
+	}
+	return []option.ClientOption{option.WithEndpoint(host)}, []option.ClientOption{option.WithEndpoint("https://" + endpoint + "/")}
+}
+
+// proxy sends requests through an HTTP(S) proxy, except those to the hosts in noProxy. Unlike the HTTPS_PROXY and NO_PROXY environment variables,
+// it only applies to the clients it is passed to, so that it doesn't leak into later runs in the same process or into child processes.
+// A nil proxy leaves the clients as they are, so that they still honor the environment variables.
+type proxy struct {
+	url func(*url.URL) (*url.URL, error)
+}
+
+// newProxy returns a proxy for proxyURL. If noProxy is empty, the hosts in the NO_PROXY environment variable are connected to directly.
+func newProxy(proxyURL string, noProxy []string) (*proxy, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("proxy %q must be a URL like \"http://proxy.example.com:3128\"", proxyURL)
+	}
+	config := &httpproxy.Config{HTTPProxy: proxyURL, HTTPSProxy: proxyURL, NoProxy: strings.Join(noProxy, ",")}
+	if len(noProxy) == 0 {
+		config.NoProxy = httpproxy.FromEnvironment().NoProxy
+	}
+	return &proxy{url: config.ProxyFunc()}, nil
+}
+
+// transport returns a transport for REST requests that uses the proxy
+func (p *proxy) transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if p != nil {
+		t.Proxy = func(r *http.Request) (*url.URL, error) { return p.url(r.URL) }
+	}
+	return t
+}
+
+// client returns an HTTP client that uses the proxy, or nil to use the default client if there is no proxy
+func (p *proxy) client() *http.Client {
+	if p == nil {
+		return nil
+	}
+	return &http.Client{Transport: p.transport()}
+}
+
+// dial connects to addr through the proxy by tunneling the connection with CONNECT, or directly if addr is in noProxy
+func (p *proxy) dial(ctx context.Context, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	proxyURL, err := p.url(&url.URL{Scheme: "https", Host: addr})
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), map[string]string{"http": "80", "https": "443"}[proxyURL.Scheme])
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	request := &http.Request{Method: http.MethodConnect, URL: &url.URL{Host: addr}, Host: addr, Header: http.Header{}}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		request.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+	err = request.Write(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused to connect to %s: %s", addr, response.Status)
+	}
+	// The server only speaks once the client has, but anything already read from the tunnel must not be lost
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// options returns the client options that make the gRPC clients tunnel their connections through the proxy
+func (p *proxy) options() []option.ClientOption {
+	if p == nil {
+		return nil
+	}
+	return []option.ClientOption{option.WithGRPCDialOption(grpc.WithContextDialer(p.dial))}
+}
+
+// restOptions returns opts with an HTTP client that sends the requests of a REST service through the proxy.
+// As the client replaces the one that the service would create from opts, it is authenticated with opts here.
+func (p *proxy) restOptions(ctx context.Context, opts []option.ClientOption) ([]option.ClientOption, error) {
+	if p == nil {
+		return opts, nil
+	}
+	t, err := htransport.NewTransport(ctx, p.transport(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return append(slices.Clip(opts), option.WithHTTPClient(&http.Client{Transport: t})), nil
+}
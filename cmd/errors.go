@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// errorSummary collects the errors of a run by category, so that they can be summarized at its end
+// instead of having to search the interleaved log messages. It is safe for concurrent use.
+type errorSummary struct {
+	mu sync.Mutex
+	// conditions counts the failed conditions by the status code of their error
+	conditions map[string]int
+}
+
+func newErrorSummary() *errorSummary {
+	return &errorSummary{conditions: map[string]int{}}
+}
+
+// conditionFailed records the error of a condition
+func (s *errorSummary) conditionFailed(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conditions[errorCode(err)]++
+}
+
+// print logs the summary of all errors along with the given counts of projects and policies, if there were any
+func (s *errorSummary) print(skippedProjects int64, failedProjects int64, disabledProjects int64, failedPolicies int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := []string{}
+	if skippedProjects > 0 {
+		lines = append(lines, fmt.Sprintf("%d project(s) skipped because of missing permissions", skippedProjects))
+	}
+	if disabledProjects > 0 {
+		lines = append(lines, fmt.Sprintf("%d project(s) skipped because the Monitoring API is not enabled", disabledProjects))
+	}
+	if failedProjects > 0 {
+		lines = append(lines, fmt.Sprintf("%d project(s) whose policies couldn't be listed completely", failedProjects))
+	}
+	for _, code := range slices.Sorted(maps.Keys(s.conditions)) {
+		lines = append(lines, fmt.Sprintf("%d condition(s) failed with %s", s.conditions[code], code))
+	}
+	if failedPolicies > 0 {
+		lines = append(lines, fmt.Sprintf("%d policy(ies) with errors in the output", failedPolicies))
+	}
+	if len(lines) == 0 {
+		return
+	}
+	log.Println("Summary of errors:")
+	for _, line := range lines {
+		log.Printf("  %s\n", line)
+	}
+}
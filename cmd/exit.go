@@ -0,0 +1,36 @@
+package cmd
+
+// Exit codes in strict mode. They are combined if multiple apply, e.g. 6 means that policies failed and projects were skipped.
+// 1 is used for fatal errors that stop the run.
+const (
+	exitPolicyErrors    = 2
+	exitSkippedProjects = 4
+	exitPartial         = 8
+)
+
+// exitViolations is added to the exit code if the results violate the Rego policies given with --regoPolicy, also outside of strict mode
+const exitViolations = 16
+
+// exitRegressions is added to the exit code if a price grew beyond --maxIncrease over the baseline, also outside of strict mode
+const exitRegressions = 32
+
+// exitInterrupted is the exit code outside of strict mode if the run was interrupted by SIGINT or SIGTERM, following the shell convention for SIGINT
+const exitInterrupted = 130
+
+// exitCode is the code the application exits with once the run is done
+var exitCode int
+
+// strictExitCode returns the exit code for a run in strict mode
+func strictExitCode(policyErrors bool, skippedProjects bool, partial bool) int {
+	code := 0
+	if policyErrors {
+		code |= exitPolicyErrors
+	}
+	if skippedProjects {
+		code |= exitSkippedProjects
+	}
+	if partial {
+		code |= exitPartial
+	}
+	return code
+}
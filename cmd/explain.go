@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+)
+
+// conditionKind returns the kind of a condition and the period in seconds at which it is executed.
+// MQL, threshold and absence conditions are executed every 30 seconds, PromQL conditions at their evaluation interval.
+func conditionKind(condition *monitoringpb.AlertPolicy_Condition) (string, int64) {
+	switch {
+	case condition.GetConditionMonitoringQueryLanguage() != nil:
+		return "MQL", 30
+	case condition.GetConditionPrometheusQueryLanguage() != nil:
+		return "PromQL", condition.GetConditionPrometheusQueryLanguage().GetEvaluationInterval().GetSeconds()
+	case condition.GetConditionThreshold() != nil:
+		return "threshold", 30
+	case condition.GetConditionAbsent() != nil:
+		return "absence", 30
+	}
+	return "other", 30
+}
+
+// explainCondition describes how the price of a condition was calculated from its number of time series
+func explainCondition(condition *monitoringpb.AlertPolicy_Condition, timeSeries int, price float64, err error) string {
+	kind, period := conditionKind(condition)
+	if kind == "other" {
+		return fmt.Sprintf("%s (%s): not queried, $0", condition.GetDisplayName(), kind)
+	}
+	explanation := fmt.Sprintf("%s (%s): %d time series * (2592000 s per month / %d s period) * $0.35 per 1M time series = $%f",
+		condition.GetDisplayName(), kind, timeSeries, period, price)
+	if err != nil {
+		explanation += fmt.Sprintf(" (incomplete: %v)", err)
+	}
+	return explanation
+}
+
+// printExplanation logs how the price of a policy adds up from the fee of its conditions and the prices of their time series
+func printExplanation(p *policy, explanations []string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Price of Alerting Policy %s (%s):\n", p.DisplayName, p.Name)
+	fmt.Fprintf(&b, "  %d condition(s) * $1.50 per condition = $%f\n", p.Conditions, 1.5*float64(p.Conditions))
+	for _, explanation := range explanations {
+		fmt.Fprintf(&b, "  + %s\n", explanation)
+	}
+	fmt.Fprintf(&b, "  = $%f per month", p.Price)
+	log.Println(b.String())
+}
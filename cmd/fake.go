@@ -0,0 +1,547 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/iam/apiv1/iampb"
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"cloud.google.com/go/resourcemanager/apiv3/resourcemanagerpb"
+	"google.golang.org/api/option"
+	"google.golang.org/genproto/googleapis/api/metric"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// fakeDemo is the value of --fake that uses the built-in demo data instead of a file
+const fakeDemo = "demo"
+
+// fakePageSize is the page size of the fake if a request doesn't set one
+const fakePageSize = 1000
+
+// fakeState is the data the fake server answers with. It is read from a JSON file given with --fake.
+type fakeState struct {
+	Organizations []fakeResource `json:"organizations"`
+	Folders       []fakeResource `json:"folders"`
+	Projects      []fakeResource `json:"projects"`
+	// Policies are alerting policies in the JSON format of the Cloud Monitoring API, their names determine their projects
+	Policies []json.RawMessage `json:"policies"`
+	// TimeSeries maps filters, MQL and PromQL queries to the number of time series they return, all others return DefaultTimeSeries
+	TimeSeries        map[string]int `json:"timeSeries"`
+	DefaultTimeSeries int            `json:"defaultTimeSeries"`
+	// Errors maps filters, queries, project IDs and policy names to the gRPC code (e.g. "RESOURCE_EXHAUSTED") that requests for them fail with
+	Errors map[string]string `json:"errors"`
+	// MissingPermissions are the IDs of projects on which the caller has none of the tested permissions
+	MissingPermissions []string `json:"missingPermissions"`
+	// Latency delays every response, e.g. to see how the threads of the pipeline keep up with a slow API
+	Latency string `json:"latency"`
+}
+
+// fakeResource is an organization, folder or project of the fake
+type fakeResource struct {
+	Id string `json:"id"`
+	// Number is the project number, which defaults to the ID
+	Number string `json:"number"`
+	// Parent is the name of the parent, e.g. "organizations/123" or "folders/456"
+	Parent      string            `json:"parent"`
+	DisplayName string            `json:"displayName"`
+	Domain      string            `json:"domain"`
+	Labels      map[string]string `json:"labels"`
+}
+
+// fakeDemoState is a small organization to try appe with, without access to Google Cloud
+var fakeDemoState = fakeState{
+	Organizations: []fakeResource{{Id: "123456789", DisplayName: "example.com", Domain: "example.com"}},
+	Folders: []fakeResource{
+		{Id: "1001", Parent: "organizations/123456789", DisplayName: "Prod"},
+		{Id: "1002", Parent: "organizations/123456789", DisplayName: "Dev"},
+	},
+	Projects: []fakeResource{
+		{Id: "shop-prod", Number: "111111111111", Parent: "folders/1001", DisplayName: "Shop", Labels: map[string]string{"team": "shop"}},
+		{Id: "payments-prod", Number: "222222222222", Parent: "folders/1001", DisplayName: "Payments", Labels: map[string]string{"team": "payments"}},
+		{Id: "sandbox-dev", Number: "333333333333", Parent: "folders/1002", DisplayName: "Sandbox"},
+	},
+	Policies: []json.RawMessage{
+		json.RawMessage(`{"name": "projects/shop-prod/alertPolicies/1", "displayName": "High CPU per instance", "enabled": true, "userLabels": {"team": "shop"}, "conditions": [{"displayName": "CPU above 90%", "conditionThreshold": {"filter": "metric.type=\"compute.googleapis.com/instance/cpu/utilization\" AND resource.type=\"gce_instance\"", "comparison": "COMPARISON_GT", "thresholdValue": 0.9, "duration": "300s", "aggregations": [{"alignmentPeriod": "60s", "perSeriesAligner": "ALIGN_MEAN"}]}}]}`),
+		json.RawMessage(`{"name": "projects/shop-prod/alertPolicies/2", "displayName": "Checkout errors", "enabled": true, "conditions": [{"displayName": "5xx responses", "conditionPrometheusQueryLanguage": {"query": "sum by (path) (rate(http_requests_total{code=~\"5..\"}[5m])) > 1", "evaluationInterval": "30s"}}]}`),
+		json.RawMessage(`{"name": "projects/payments-prod/alertPolicies/3", "displayName": "Payment latency", "enabled": true, "userLabels": {"team": "payments"}, "conditions": [{"displayName": "p99 latency", "conditionMonitoringQueryLanguage": {"query": "fetch k8s_container | metric 'custom.googleapis.com/payment/latency' | every 1m", "duration": "60s"}}, {"displayName": "No payments", "conditionAbsent": {"filter": "metric.type=\"custom.googleapis.com/payment/count\" AND resource.type=\"k8s_container\"", "duration": "600s"}}]}`),
+		json.RawMessage(`{"name": "projects/sandbox-dev/alertPolicies/4", "displayName": "Disk usage", "enabled": true, "conditions": [{"displayName": "Disk above 80%", "conditionThreshold": {"filter": "metric.type=\"agent.googleapis.com/disk/percent_used\" AND resource.type=\"gce_instance\"", "comparison": "COMPARISON_GT", "thresholdValue": 80, "duration": "60s"}}]}`),
+	},
+	TimeSeries: map[string]int{
+		`metric.type="compute.googleapis.com/instance/cpu/utilization" AND resource.type="gce_instance"`: 420,
+		`sum by (path) (rate(http_requests_total{code=~"5.."}[5m])) > 1`:                                 35,
+		`fetch k8s_container | metric 'custom.googleapis.com/payment/latency' | every 1m`:                120,
+		`metric.type="custom.googleapis.com/payment/count" AND resource.type="k8s_container"`:            12,
+	},
+	DefaultTimeSeries: 1500,
+	Latency:           "20ms",
+}
+
+// fakeServer answers the Cloud Monitoring and Resource Manager requests of appe from a fakeState, on a local gRPC server for the
+// gRPC clients and a local HTTP server for the PromQL API. It is wired in through the endpoints of the clients like a
+// Private Service Connect endpoint, so the whole pipeline runs unchanged, e.g. to try appe or to test its concurrency and pricing.
+type fakeServer struct {
+	monitoringpb.UnimplementedAlertPolicyServiceServer
+	monitoringpb.UnimplementedMetricServiceServer
+	monitoringpb.UnimplementedQueryServiceServer
+
+	state   fakeState
+	latency time.Duration
+	errors  map[string]codes.Code
+
+	mu       sync.Mutex
+	policies []*monitoringpb.AlertPolicy
+
+	localServer
+
+	// calls counts the requests per method, inFlight and maxInFlight the concurrent requests
+	calls       sync.Map
+	inFlight    atomic.Int64
+	maxInFlight atomic.Int64
+}
+
+// readFakeState reads the data of the fake from a JSON file, or returns the demo data for "demo"
+func readFakeState(path string) (fakeState, error) {
+	if path == fakeDemo {
+		return fakeDemoState, nil
+	}
+	var state fakeState
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(b, &state)
+	return state, err
+}
+
+// newFakeServer parses the policies and errors of state
+func newFakeServer(state fakeState) (*fakeServer, error) {
+	f := &fakeServer{state: state, errors: map[string]codes.Code{}}
+	var err error
+	if state.Latency != "" {
+		f.latency, err = time.ParseDuration(state.Latency)
+		if err != nil {
+			return nil, fmt.Errorf("invalid latency %q: %v", state.Latency, err)
+		}
+	}
+	for key, name := range state.Errors {
+		var code codes.Code
+		err = code.UnmarshalJSON([]byte(strconv.Quote(name)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid error code %q of %s: %v", name, key, err)
+		}
+		f.errors[key] = code
+	}
+	for i, raw := range state.Policies {
+		alertPolicy := &monitoringpb.AlertPolicy{}
+		err = protojson.Unmarshal(raw, alertPolicy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid policy %d: %v", i, err)
+		}
+		f.policies = append(f.policies, alertPolicy)
+	}
+	return f, nil
+}
+
+// localServer serves gRPC and HTTP on random local ports, so that the clients can send their requests to it instead of Google Cloud
+type localServer struct {
+	grpcServer *grpc.Server
+	httpServer *http.Server
+	grpcAddr   string
+	httpAddr   string
+}
+
+// serve starts serving grpcServer and handler
+func (l *localServer) serve(grpcServer *grpc.Server, handler http.Handler) error {
+	grpcListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	httpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		grpcListener.Close()
+		return err
+	}
+	l.grpcAddr, l.httpAddr = grpcListener.Addr().String(), httpListener.Addr().String()
+	l.grpcServer, l.httpServer = grpcServer, &http.Server{Handler: handler}
+	go l.grpcServer.Serve(grpcListener)
+	go l.httpServer.Serve(httpListener)
+	return nil
+}
+
+// close stops both servers
+func (l *localServer) close() {
+	l.grpcServer.Stop()
+	l.httpServer.Close()
+}
+
+// clientOptions returns the options that point the gRPC clients and the REST clients of Cloud Monitoring to the server
+func (l *localServer) clientOptions() (grpcOptions []option.ClientOption, restOptions []option.ClientOption) {
+	return []option.ClientOption{option.WithEndpoint(l.grpcAddr), option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials()))},
+		[]option.ClientOption{option.WithEndpoint("http://" + l.httpAddr + "/")}
+}
+
+// start starts the fake on random local ports
+func (f *fakeServer) start() error {
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		defer f.track(info.FullMethod)()
+		return handler(ctx, req)
+	}))
+	monitoringpb.RegisterAlertPolicyServiceServer(grpcServer, f)
+	monitoringpb.RegisterMetricServiceServer(grpcServer, f)
+	monitoringpb.RegisterQueryServiceServer(grpcServer, f)
+	resourcemanagerpb.RegisterProjectsServer(grpcServer, &fakeProjects{f: f})
+	resourcemanagerpb.RegisterFoldersServer(grpcServer, &fakeFolders{f: f})
+	resourcemanagerpb.RegisterOrganizationsServer(grpcServer, &fakeOrganizations{f: f})
+	return f.serve(grpcServer, http.HandlerFunc(f.queryRange))
+}
+
+// stop stops the fake and logs how often each method was called and how many requests were handled at once
+func (f *fakeServer) stop() {
+	f.close()
+	f.calls.Range(func(method, calls any) bool {
+		slog.Debug("Fake requests", "method", method, "calls", calls.(*atomic.Int64).Load())
+		return true
+	})
+	slog.Debug("Fake concurrency", "maxInFlight", f.maxInFlight.Load())
+}
+
+// track counts a request and delays it by the latency. The returned function must be called once the request is answered.
+func (f *fakeServer) track(method string) func() {
+	calls, _ := f.calls.LoadOrStore(method, &atomic.Int64{})
+	calls.(*atomic.Int64).Add(1)
+	n := f.inFlight.Add(1)
+	for {
+		current := f.maxInFlight.Load()
+		if n <= current || f.maxInFlight.CompareAndSwap(current, n) {
+			break
+		}
+	}
+	time.Sleep(f.latency)
+	return func() { f.inFlight.Add(-1) }
+}
+
+// fail returns the configured error for any of keys
+func (f *fakeServer) fail(keys ...string) error {
+	for _, key := range keys {
+		if code, ok := f.errors[key]; ok {
+			return status.Errorf(code, "fake error for %s", key)
+		}
+	}
+	return nil
+}
+
+// timeSeries returns the number of time series of a filter or query
+func (f *fakeServer) timeSeries(query string) int {
+	if n, ok := f.state.TimeSeries[query]; ok {
+		return n
+	}
+	return f.state.DefaultTimeSeries
+}
+
+// fakePage returns the offset and end of the requested page of n items and the token of the next page
+func fakePage(n int, pageSize int32, pageToken string) (int, int, string) {
+	offset, _ := strconv.Atoi(pageToken)
+	size := int(pageSize)
+	if size <= 0 {
+		size = fakePageSize
+	}
+	end := min(offset+size, n)
+	if end < n {
+		return offset, end, strconv.Itoa(end)
+	}
+	return min(offset, n), end, ""
+}
+
+func (f *fakeServer) ListAlertPolicies(ctx context.Context, req *monitoringpb.ListAlertPoliciesRequest) (*monitoringpb.ListAlertPoliciesResponse, error) {
+	if err := f.fail(strings.TrimPrefix(req.GetName(), "projects/")); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var policies []*monitoringpb.AlertPolicy
+	for _, alertPolicy := range f.policies {
+		if strings.HasPrefix(alertPolicy.GetName(), req.GetName()+"/alertPolicies/") {
+			policies = append(policies, alertPolicy)
+		}
+	}
+	offset, end, next := fakePage(len(policies), req.GetPageSize(), req.GetPageToken())
+	return &monitoringpb.ListAlertPoliciesResponse{AlertPolicies: policies[offset:end], NextPageToken: next, TotalSize: int32(len(policies))}, nil
+}
+
+func (f *fakeServer) GetAlertPolicy(ctx context.Context, req *monitoringpb.GetAlertPolicyRequest) (*monitoringpb.AlertPolicy, error) {
+	if err := f.fail(req.GetName()); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, alertPolicy := range f.policies {
+		if alertPolicy.GetName() == req.GetName() {
+			return alertPolicy, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "policy %s not found", req.GetName())
+}
+
+func (f *fakeServer) UpdateAlertPolicy(ctx context.Context, req *monitoringpb.UpdateAlertPolicyRequest) (*monitoringpb.AlertPolicy, error) {
+	name := req.GetAlertPolicy().GetName()
+	if err := f.fail(name); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i := slices.IndexFunc(f.policies, func(alertPolicy *monitoringpb.AlertPolicy) bool { return alertPolicy.GetName() == name })
+	if i < 0 {
+		return nil, status.Errorf(codes.NotFound, "policy %s not found", name)
+	}
+	// Only user labels are updated by appe, the update mask is ignored
+	f.policies[i] = req.GetAlertPolicy()
+	return f.policies[i], nil
+}
+
+func (f *fakeServer) ListTimeSeries(ctx context.Context, req *monitoringpb.ListTimeSeriesRequest) (*monitoringpb.ListTimeSeriesResponse, error) {
+	if err := f.fail(req.GetFilter(), strings.TrimPrefix(req.GetName(), "projects/")); err != nil {
+		return nil, err
+	}
+	n := f.timeSeries(req.GetFilter())
+	// Counting by a reducer returns a single time series with the number of time series as its value
+	if req.GetSecondaryAggregation().GetCrossSeriesReducer() == monitoringpb.Aggregation_REDUCE_COUNT {
+		return &monitoringpb.ListTimeSeriesResponse{TimeSeries: []*monitoringpb.TimeSeries{{Points: []*monitoringpb.Point{{
+			Value: &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_Int64Value{Int64Value: int64(n)}},
+		}}}}}, nil
+	}
+	offset, end, next := fakePage(n, req.GetPageSize(), req.GetPageToken())
+	timeSeries := make([]*monitoringpb.TimeSeries, 0, end-offset)
+	for i := offset; i < end; i++ {
+		timeSeries = append(timeSeries, &monitoringpb.TimeSeries{Metric: &metric.Metric{Labels: map[string]string{"series": strconv.Itoa(i)}}})
+	}
+	return &monitoringpb.ListTimeSeriesResponse{TimeSeries: timeSeries, NextPageToken: next}, nil
+}
+
+func (f *fakeServer) ListMetricDescriptors(ctx context.Context, req *monitoringpb.ListMetricDescriptorsRequest) (*monitoringpb.ListMetricDescriptorsResponse, error) {
+	return &monitoringpb.ListMetricDescriptorsResponse{}, nil
+}
+
+func (f *fakeServer) CreateTimeSeries(ctx context.Context, req *monitoringpb.CreateTimeSeriesRequest) (*emptypb.Empty, error) {
+	return &emptypb.Empty{}, nil
+}
+
+func (f *fakeServer) QueryTimeSeries(ctx context.Context, req *monitoringpb.QueryTimeSeriesRequest) (*monitoringpb.QueryTimeSeriesResponse, error) {
+	if err := f.fail(req.GetQuery(), strings.TrimPrefix(req.GetName(), "projects/")); err != nil {
+		return nil, err
+	}
+	offset, end, next := fakePage(f.timeSeries(req.GetQuery()), req.GetPageSize(), req.GetPageToken())
+	timeSeriesData := make([]*monitoringpb.TimeSeriesData, end-offset)
+	for i := range timeSeriesData {
+		timeSeriesData[i] = &monitoringpb.TimeSeriesData{}
+	}
+	return &monitoringpb.QueryTimeSeriesResponse{TimeSeriesData: timeSeriesData, NextPageToken: next}, nil
+}
+
+// queryRange answers PromQL range queries of the REST API with the configured number of empty time series
+func (f *fakeServer) queryRange(w http.ResponseWriter, r *http.Request) {
+	defer f.track("/v1/projects/*/location/global/prometheus/api/v1/query_range")()
+	project, found := strings.CutPrefix(r.URL.Path, "/v1/projects/")
+	project, _, _ = strings.Cut(project, "/")
+	if !found || !strings.HasSuffix(r.URL.Path, "/prometheus/api/v1/query_range") {
+		http.NotFound(w, r)
+		return
+	}
+	var req struct {
+		Query string `json:"query"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := f.fail(req.Query, project); err != nil {
+		s := status.Convert(err)
+		code := http.StatusInternalServerError
+		switch s.Code() {
+		case codes.InvalidArgument:
+			code = http.StatusBadRequest
+		case codes.PermissionDenied:
+			code = http.StatusForbidden
+		case codes.NotFound:
+			code = http.StatusNotFound
+		case codes.ResourceExhausted:
+			code = http.StatusTooManyRequests
+		case codes.Unavailable:
+			code = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"code": code, "message": s.Message(), "status": s.Code().String()}})
+		return
+	}
+	result := make([]map[string]any, f.timeSeries(req.Query))
+	for i := range result {
+		result[i] = map[string]any{"metric": map[string]string{"series": strconv.Itoa(i)}, "values": [][]any{}}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"status": "success", "data": map[string]any{"resultType": "matrix", "result": result}})
+}
+
+// fakeProjects, fakeFolders and fakeOrganizations are the Resource Manager services of the fake.
+// They are separate types, as the services share methods like GetIamPolicy.
+type fakeProjects struct {
+	resourcemanagerpb.UnimplementedProjectsServer
+	f *fakeServer
+}
+
+type fakeFolders struct {
+	resourcemanagerpb.UnimplementedFoldersServer
+	f *fakeServer
+}
+
+type fakeOrganizations struct {
+	resourcemanagerpb.UnimplementedOrganizationsServer
+	f *fakeServer
+}
+
+// project returns a project by its ID or number
+func (f *fakeServer) project(idOrNumber string) *resourcemanagerpb.Project {
+	for _, p := range f.state.Projects {
+		number := p.Number
+		if number == "" {
+			number = p.Id
+		}
+		if p.Id == idOrNumber || number == idOrNumber {
+			return &resourcemanagerpb.Project{Name: "projects/" + number, ProjectId: p.Id, Parent: p.Parent, DisplayName: p.DisplayName, Labels: p.Labels, State: resourcemanagerpb.Project_ACTIVE}
+		}
+	}
+	return nil
+}
+
+func (s *fakeProjects) GetProject(ctx context.Context, req *resourcemanagerpb.GetProjectRequest) (*resourcemanagerpb.Project, error) {
+	id := strings.TrimPrefix(req.GetName(), "projects/")
+	if err := s.f.fail(id); err != nil {
+		return nil, err
+	}
+	if p := s.f.project(id); p != nil {
+		return p, nil
+	}
+	return nil, status.Errorf(codes.PermissionDenied, "project %s not found or permission denied", id)
+}
+
+func (s *fakeProjects) ListProjects(ctx context.Context, req *resourcemanagerpb.ListProjectsRequest) (*resourcemanagerpb.ListProjectsResponse, error) {
+	if err := s.f.fail(req.GetParent()); err != nil {
+		return nil, err
+	}
+	var projects []*resourcemanagerpb.Project
+	for _, p := range s.f.state.Projects {
+		if p.Parent == req.GetParent() {
+			projects = append(projects, s.f.project(p.Id))
+		}
+	}
+	offset, end, next := fakePage(len(projects), req.GetPageSize(), req.GetPageToken())
+	return &resourcemanagerpb.ListProjectsResponse{Projects: projects[offset:end], NextPageToken: next}, nil
+}
+
+func (s *fakeProjects) SearchProjects(ctx context.Context, req *resourcemanagerpb.SearchProjectsRequest) (*resourcemanagerpb.SearchProjectsResponse, error) {
+	var projects []*resourcemanagerpb.Project
+	for _, p := range s.f.state.Projects {
+		projects = append(projects, s.f.project(p.Id))
+	}
+	offset, end, next := fakePage(len(projects), req.GetPageSize(), req.GetPageToken())
+	return &resourcemanagerpb.SearchProjectsResponse{Projects: projects[offset:end], NextPageToken: next}, nil
+}
+
+func (s *fakeProjects) TestIamPermissions(ctx context.Context, req *iampb.TestIamPermissionsRequest) (*iampb.TestIamPermissionsResponse, error) {
+	id := strings.TrimPrefix(req.GetResource(), "projects/")
+	if err := s.f.fail(id); err != nil {
+		return nil, err
+	}
+	if slices.Contains(s.f.state.MissingPermissions, id) {
+		return &iampb.TestIamPermissionsResponse{}, nil
+	}
+	return &iampb.TestIamPermissionsResponse{Permissions: req.GetPermissions()}, nil
+}
+
+// fakeFolder converts a folder of the state
+func fakeFolder(r fakeResource) *resourcemanagerpb.Folder {
+	return &resourcemanagerpb.Folder{Name: "folders/" + r.Id, Parent: r.Parent, DisplayName: r.DisplayName, State: resourcemanagerpb.Folder_ACTIVE}
+}
+
+func (s *fakeFolders) GetFolder(ctx context.Context, req *resourcemanagerpb.GetFolderRequest) (*resourcemanagerpb.Folder, error) {
+	if err := s.f.fail(req.GetName()); err != nil {
+		return nil, err
+	}
+	for _, r := range s.f.state.Folders {
+		if "folders/"+r.Id == req.GetName() {
+			return fakeFolder(r), nil
+		}
+	}
+	return nil, status.Errorf(codes.PermissionDenied, "folder %s not found or permission denied", req.GetName())
+}
+
+func (s *fakeFolders) ListFolders(ctx context.Context, req *resourcemanagerpb.ListFoldersRequest) (*resourcemanagerpb.ListFoldersResponse, error) {
+	if err := s.f.fail(req.GetParent()); err != nil {
+		return nil, err
+	}
+	var folders []*resourcemanagerpb.Folder
+	for _, r := range s.f.state.Folders {
+		if r.Parent == req.GetParent() {
+			folders = append(folders, fakeFolder(r))
+		}
+	}
+	offset, end, next := fakePage(len(folders), req.GetPageSize(), req.GetPageToken())
+	return &resourcemanagerpb.ListFoldersResponse{Folders: folders[offset:end], NextPageToken: next}, nil
+}
+
+// SearchFolders only supports the queries of resolveFolder, all folders whose display name is quoted in the query are returned
+func (s *fakeFolders) SearchFolders(ctx context.Context, req *resourcemanagerpb.SearchFoldersRequest) (*resourcemanagerpb.SearchFoldersResponse, error) {
+	var folders []*resourcemanagerpb.Folder
+	for _, r := range s.f.state.Folders {
+		if strings.Contains(req.GetQuery(), strconv.Quote(r.DisplayName)) {
+			folders = append(folders, fakeFolder(r))
+		}
+	}
+	offset, end, next := fakePage(len(folders), req.GetPageSize(), req.GetPageToken())
+	return &resourcemanagerpb.SearchFoldersResponse{Folders: folders[offset:end], NextPageToken: next}, nil
+}
+
+// fakeOrganization converts an organization of the state
+func fakeOrganization(r fakeResource) *resourcemanagerpb.Organization {
+	return &resourcemanagerpb.Organization{Name: "organizations/" + r.Id, DisplayName: r.DisplayName, Owner: &resourcemanagerpb.Organization_DirectoryCustomerId{DirectoryCustomerId: "C0fake"}, State: resourcemanagerpb.Organization_ACTIVE}
+}
+
+func (s *fakeOrganizations) GetOrganization(ctx context.Context, req *resourcemanagerpb.GetOrganizationRequest) (*resourcemanagerpb.Organization, error) {
+	if err := s.f.fail(req.GetName()); err != nil {
+		return nil, err
+	}
+	for _, r := range s.f.state.Organizations {
+		if "organizations/"+r.Id == req.GetName() {
+			return fakeOrganization(r), nil
+		}
+	}
+	return nil, status.Errorf(codes.PermissionDenied, "organization %s not found or permission denied", req.GetName())
+}
+
+// SearchOrganizations supports queries by "domain:DOMAIN", all other queries return all organizations
+func (s *fakeOrganizations) SearchOrganizations(ctx context.Context, req *resourcemanagerpb.SearchOrganizationsRequest) (*resourcemanagerpb.SearchOrganizationsResponse, error) {
+	domain, byDomain := strings.CutPrefix(req.GetQuery(), "domain:")
+	var organizations []*resourcemanagerpb.Organization
+	for _, r := range s.f.state.Organizations {
+		if !byDomain || r.Domain == domain {
+			organizations = append(organizations, fakeOrganization(r))
+		}
+	}
+	return &resourcemanagerpb.SearchOrganizationsResponse{Organizations: organizations}, nil
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+)
+
+// policyFilter holds the criteria an alerting policy has to meet in order to be processed when scanning projects
+type policyFilter struct {
+	includeDisabled bool
+	onlyDisabled    bool
+	displayName     *regexp.Regexp
+	labels          map[string]string
+	excludedNames   []string
+	excludedDisplay *regexp.Regexp
+	modifiedSince   time.Time
+}
+
+func (f *policyFilter) matches(alertPolicy *monitoringpb.AlertPolicy) bool {
+	enabled := alertPolicy.GetEnabled()
+	isEnabled := enabled != nil && enabled.GetValue()
+	if !isEnabled && !f.includeDisabled && !f.onlyDisabled {
+		return false
+	}
+	if isEnabled && f.onlyDisabled {
+		return false
+	}
+	if f.displayName != nil && !f.displayName.MatchString(alertPolicy.GetDisplayName()) {
+		return false
+	}
+	if slices.Contains(f.excludedNames, alertPolicy.GetName()) {
+		return false
+	}
+	if f.excludedDisplay != nil && f.excludedDisplay.MatchString(alertPolicy.GetDisplayName()) {
+		return false
+	}
+	if !f.modifiedSince.IsZero() && lastModified(alertPolicy).Before(f.modifiedSince) {
+		return false
+	}
+	userLabels := alertPolicy.GetUserLabels()
+	for key, value := range f.labels {
+		if v, ok := userLabels[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// lastModified returns the time a policy was last modified. Policies that have never been modified only have a creation record.
+func lastModified(alertPolicy *monitoringpb.AlertPolicy) time.Time {
+	record := alertPolicy.GetMutationRecord()
+	if record == nil {
+		record = alertPolicy.GetCreationRecord()
+	}
+	return record.GetMutateTime().AsTime()
+}
+
+// parseLabels converts a list of "key=value" pairs into a map
+func parseLabels(pairs []string) (map[string]string, error) {
+	labels := make(map[string]string, len(pairs))
+	for i := range pairs {
+		key, value, found := strings.Cut(pairs[i], "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("label %q must be in the format key=value", pairs[i])
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// parseTime parses either a date (e.g. "2024-01-01") or a full RFC3339 timestamp (e.g. "2024-01-01T12:00:00Z")
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
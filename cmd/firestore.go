@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	firestore "google.golang.org/api/firestore/v1"
+)
+
+// firestoreBatch is the maximum number of documents that can be written to Firestore at once
+const firestoreBatch = 500
+
+// firestoreCollection is a collection given as projects/PROJECT/databases/DATABASE/documents/COLLECTION
+type firestoreCollection struct {
+	database string
+	path     string
+}
+
+func parseFirestoreCollection(s string) (firestoreCollection, error) {
+	database, collection, found := strings.Cut(s, "/documents/")
+	parts := strings.Split(database, "/")
+	if !found || collection == "" || len(parts) != 4 || parts[0] != "projects" || parts[1] == "" || parts[2] != "databases" || parts[3] == "" {
+		return firestoreCollection{}, fmt.Errorf("collection %q must be in the format projects/PROJECT/databases/DATABASE/documents/COLLECTION", s)
+	}
+	return firestoreCollection{database: database, path: s}, nil
+}
+
+// firestoreSink upserts the latest estimate of every policy into a document of a Firestore collection.
+// The ID of a document is the escaped name of its policy, so that each policy always has the same document.
+type firestoreSink struct {
+	ctx        context.Context
+	service    *firestore.Service
+	collection firestoreCollection
+	runTime    time.Time
+	writes     []*firestore.Write
+}
+
+func newFirestoreSink(ctx context.Context, service *firestore.Service, collection firestoreCollection, runTime time.Time) *firestoreSink {
+	slog.Info("Writing results to Firestore", "collection", collection.path)
+	return &firestoreSink{ctx: ctx, service: service, collection: collection, runTime: runTime}
+}
+
+func (s *firestoreSink) write(p *policy) error {
+	userLabels := map[string]firestore.Value{}
+	for key, value := range p.UserLabels {
+		userLabels[key] = firestore.Value{StringValue: value, ForceSendFields: []string{"StringValue"}}
+	}
+	tags := map[string]firestore.Value{}
+	for key, value := range p.Tags {
+		tags[key] = firestore.Value{StringValue: value, ForceSendFields: []string{"StringValue"}}
+	}
+	s.writes = append(s.writes, &firestore.Write{Update: &firestore.Document{
+		// Document IDs can't contain slashes
+		Name: s.collection.path + "/" + url.QueryEscape(p.Name),
+		Fields: map[string]firestore.Value{
+			"runTime":     {TimestampValue: s.runTime.Format(time.RFC3339Nano)},
+			"projectId":   {StringValue: p.ProjectId, ForceSendFields: []string{"StringValue"}},
+			"name":        {StringValue: p.Name},
+			"displayName": {StringValue: p.DisplayName, ForceSendFields: []string{"StringValue"}},
+			"conditions":  {IntegerValue: int64(p.Conditions), ForceSendFields: []string{"IntegerValue"}},
+			"timeSeries":  {IntegerValue: int64(p.TimeSeries), ForceSendFields: []string{"IntegerValue"}},
+			"price":       {DoubleValue: p.Price, ForceSendFields: []string{"DoubleValue"}},
+			"error":       {StringValue: p.Error, ForceSendFields: []string{"StringValue"}},
+			"userLabels":  {MapValue: &firestore.MapValue{Fields: userLabels}},
+			"tags":        {MapValue: &firestore.MapValue{Fields: tags}},
+		},
+	}})
+	if len(s.writes) >= firestoreBatch {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush writes all buffered documents. The writes of a batch aren't atomic, each of them can fail on its own.
+func (s *firestoreSink) flush() error {
+	if len(s.writes) == 0 {
+		return nil
+	}
+	response, err := s.service.Projects.Databases.Documents.BatchWrite(s.collection.database, &firestore.BatchWriteRequest{Writes: s.writes}).Context(s.ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to write documents to %s: %v", s.collection.path, err)
+	}
+	failed := 0
+	var message string
+	for _, status := range response.Status {
+		if status.Code != 0 {
+			failed++
+			message = status.Message
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to write %d documents to %s: %s", failed, s.collection.path, message)
+	}
+	s.writes = s.writes[:0]
+	return nil
+}
+
+func (s *firestoreSink) close(partial bool) error {
+	return s.flush()
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// githubTableRows is the maximum number of policies listed in the job summary, so that it stays readable for large scans
+const githubTableRows = 100
+
+// githubSink emits a GitHub Actions annotation for every policy above a price threshold or with an error
+// and writes a Markdown table of the most expensive of them to the job summary
+type githubSink struct {
+	warnAbove float64
+	policies  int
+	price     float64
+	above     int
+	expensive topPolicies
+}
+
+func newGitHubSink(warnAbove float64) *githubSink {
+	return &githubSink{warnAbove: warnAbove, expensive: topPolicies{n: githubTableRows}}
+}
+
+func (s *githubSink) write(p *policy) error {
+	s.policies++
+	s.price += p.Price
+	if p.Error != "" {
+		fmt.Printf("::error title=%s::%s\n", escapeGitHubProperty("Failed to estimate "+p.DisplayName), escapeGitHubData(fmt.Sprintf("%s: %s", p.Name, p.Error)))
+	}
+	if p.Price > s.warnAbove {
+		fmt.Printf("::warning title=%s::%s\n", escapeGitHubProperty("Expensive alerting policy "+p.DisplayName), escapeGitHubData(fmt.Sprintf("%s has %d condition(s) and %d time series. It will cost approximately $%.2f per month", p.Name, p.Conditions, p.TimeSeries, p.Price)))
+		s.above++
+		s.expensive.add(p)
+	}
+	for _, condition := range p.HighCardinality {
+		fmt.Printf("::warning title=%s::%s\n", escapeGitHubProperty("High cardinality condition in "+p.DisplayName), escapeGitHubData(fmt.Sprintf("%s: condition %s, check its filter and aggregations", p.Name, condition)))
+	}
+	return nil
+}
+
+func (s *githubSink) close(partial bool) error {
+	path := os.Getenv("GITHUB_STEP_SUMMARY")
+	if path == "" {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString("## Alerting Policy Price Estimate\n\n")
+	if partial {
+		b.WriteString("> [!WARNING]\n> The run was stopped before all policies were processed, the results are incomplete.\n\n")
+	}
+	fmt.Fprintf(&b, "%d policies will cost approximately **$%.2f** per month. %d of them cost more than $%.2f.\n\n", s.policies, s.price, s.above, s.warnAbove)
+	if s.above > 0 {
+		b.WriteString("| Policy | Project | Conditions | Time Series | Price |\n|---|---|---:|---:|---:|\n")
+		for _, p := range s.expensive.sorted() {
+			fmt.Fprintf(&b, "| [%s](%s) | %s | %d | %d | $%.2f |\n", escapeMarkdown(p.DisplayName), policyLink(p), p.ProjectId, p.Conditions, p.TimeSeries, p.Price)
+		}
+		if s.above > githubTableRows {
+			fmt.Fprintf(&b, "\nOnly the %d most expensive policies are listed.\n", githubTableRows)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(b.String())
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// escapeGitHubData escapes the message of a workflow command
+func escapeGitHubData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeGitHubProperty escapes a property of a workflow command, e.g. its title
+func escapeGitHubProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// escapeMarkdown escapes the characters that would break a cell of a Markdown table
+func escapeMarkdown(s string) string {
+	return strings.NewReplacer("|", "\\|", "[", "\\[", "]", "\\]", "\n", " ").Replace(s)
+}
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"gopkg.in/yaml.v3"
+)
+
+// grafanaRules is a file of provisioned Grafana alert rules. Exported rules have the same format, both in YAML and JSON.
+type grafanaRules struct {
+	Groups []struct {
+		Name     string `yaml:"name"`
+		Interval string `yaml:"interval"`
+		Rules    []struct {
+			UID   string `yaml:"uid"`
+			Title string `yaml:"title"`
+			Data  []struct {
+				RefID         string         `yaml:"refId"`
+				DatasourceUID string         `yaml:"datasourceUid"`
+				Model         map[string]any `yaml:"model"`
+			} `yaml:"data"`
+		} `yaml:"rules"`
+	} `yaml:"groups"`
+}
+
+// readGrafanaRules reads provisioned Grafana alert rules and adds a policy to definitions for each of them, as if it was migrated to Cloud Monitoring in projectId.
+// Prometheus queries become PromQL conditions that are evaluated at the interval of their group,
+// Cloud Monitoring queries become MQL or threshold conditions. Server-side expressions (e.g. reduce or threshold) are skipped.
+func readGrafanaRules(path string, projectId string, definitions policyDefinitions) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file grafanaRules
+	// JSON is valid YAML, so both formats can be parsed the same way
+	err = yaml.Unmarshal(b, &file)
+	if err != nil {
+		return err
+	}
+	for _, group := range file.Groups {
+		interval := time.Minute
+		if group.Interval != "" {
+			interval, err = time.ParseDuration(group.Interval)
+			if err != nil {
+				return fmt.Errorf("invalid interval %q of group %s: %v", group.Interval, group.Name, err)
+			}
+		}
+		for _, rule := range group.Rules {
+			var conditions []*monitoringpb.AlertPolicy_Condition
+			for _, query := range rule.Data {
+				if query.DatasourceUID == "__expr__" {
+					continue
+				}
+				condition := grafanaCondition(query.Model, interval)
+				if condition == nil {
+					slog.Warn("Skipped Grafana query that is neither PromQL nor Cloud Monitoring", "rule", rule.Title, "query", query.RefID)
+					continue
+				}
+				condition.DisplayName = fmt.Sprintf("%s (%s)", rule.Title, query.RefID)
+				conditions = append(conditions, condition)
+			}
+			if len(conditions) > 0 {
+				definitions.add(projectId, "grafana", rule.UID, &monitoringpb.AlertPolicy{DisplayName: rule.Title, Conditions: conditions})
+			}
+		}
+	}
+	return nil
+}
+
+// grafanaCondition converts the model of a Grafana query to a condition, or returns nil if the data source isn't supported
+func grafanaCondition(model map[string]any, interval time.Duration) *monitoringpb.AlertPolicy_Condition {
+	if expr, ok := model["expr"].(string); ok && expr != "" {
+		// Cloud Monitoring evaluates PromQL conditions at most every 30 seconds
+		return &monitoringpb.AlertPolicy_Condition{Condition: &monitoringpb.AlertPolicy_Condition_ConditionPrometheusQueryLanguage{
+			ConditionPrometheusQueryLanguage: &monitoringpb.AlertPolicy_Condition_PrometheusQueryLanguageCondition{
+				Query:              expr,
+				EvaluationInterval: durationpb.New(max(interval, 30*time.Second)),
+			},
+		}}
+	}
+	// Older versions of the Cloud Monitoring data source keep both kinds of queries in metricQuery
+	for _, key := range []string{"timeSeriesQuery", "metricQuery"} {
+		if query, ok := model[key].(map[string]any); ok {
+			if mql, ok := query["query"].(string); ok && mql != "" {
+				return &monitoringpb.AlertPolicy_Condition{Condition: &monitoringpb.AlertPolicy_Condition_ConditionMonitoringQueryLanguage{
+					ConditionMonitoringQueryLanguage: &monitoringpb.AlertPolicy_Condition_MonitoringQueryLanguageCondition{Query: mql},
+				}}
+			}
+		}
+	}
+	for _, key := range []string{"timeSeriesList", "metricQuery"} {
+		if list, ok := model[key].(map[string]any); ok {
+			filters, _ := list["filters"].([]any)
+			if len(filters) == 0 {
+				continue
+			}
+			return &monitoringpb.AlertPolicy_Condition{Condition: &monitoringpb.AlertPolicy_Condition_ConditionThreshold{
+				ConditionThreshold: &monitoringpb.AlertPolicy_Condition_MetricThreshold{
+					Filter:       grafanaFilter(filters),
+					Aggregations: grafanaAggregations(list),
+				},
+			}}
+		}
+	}
+	return nil
+}
+
+// grafanaFilter converts the filters of the Cloud Monitoring data source, e.g. ["metric.type", "=", "X", "AND", "resource.label.zone", "=~", "us-.*"], to a filter
+func grafanaFilter(tokens []any) string {
+	var parts []string
+	for i := 0; i < len(tokens); i++ {
+		token := fmt.Sprint(tokens[i])
+		if token == "AND" || token == "OR" {
+			parts = append(parts, token)
+			continue
+		}
+		if i+2 >= len(tokens) {
+			break
+		}
+		key, operator, value := token, fmt.Sprint(tokens[i+1]), fmt.Sprint(tokens[i+2])
+		i += 2
+		switch operator {
+		case "=~":
+			parts = append(parts, fmt.Sprintf("%s = monitoring.regex.full_match(%q)", key, value))
+		case "!=~":
+			parts = append(parts, fmt.Sprintf("NOT %s = monitoring.regex.full_match(%q)", key, value))
+		default:
+			parts = append(parts, fmt.Sprintf("%s %s %q", key, operator, value))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// grafanaAggregations converts the aggregation of the Cloud Monitoring data source
+func grafanaAggregations(list map[string]any) []*monitoringpb.Aggregation {
+	aggregation := &monitoringpb.Aggregation{AlignmentPeriod: durationpb.New(time.Minute)}
+	if period, ok := list["alignmentPeriod"].(string); ok {
+		// Grafana also accepts "grafana-auto" and "cloud-monitoring-auto", which keep the default
+		if d, err := time.ParseDuration(period); err == nil {
+			aggregation.AlignmentPeriod = durationpb.New(d)
+		}
+	}
+	if aligner, ok := list["perSeriesAligner"].(string); ok {
+		aggregation.PerSeriesAligner = monitoringpb.Aggregation_Aligner(monitoringpb.Aggregation_Aligner_value[aligner])
+	}
+	if reducer, ok := list["crossSeriesReducer"].(string); ok {
+		aggregation.CrossSeriesReducer = monitoringpb.Aggregation_Reducer(monitoringpb.Aggregation_Reducer_value[reducer])
+	}
+	if groupBys, ok := list["groupBys"].([]any); ok {
+		for _, field := range groupBys {
+			aggregation.GroupByFields = append(aggregation.GroupByFields, fmt.Sprint(field))
+		}
+	}
+	return []*monitoringpb.Aggregation{aggregation}
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/resourcemanager/apiv3/resourcemanagerpb"
+)
+
+// folderPaths looks up the path of display names from the organization down to the folder of a project, e.g. "example.com > Prod > Payments".
+// The display names of all folders and organizations are cached, so that each of them is only looked up once per run.
+// The projects themselves are cached as well, so that their labels can be used without looking them up again.
+type folderPaths struct {
+	router *clientRouter
+	mu     sync.Mutex
+	// parents maps projects and folders to their parent, names maps folders and organizations to their display name
+	parents  map[string]string
+	names    map[string]string
+	projects map[string]*resourcemanagerpb.Project
+}
+
+func newFolderPaths(router *clientRouter) *folderPaths {
+	return &folderPaths{router: router, parents: map[string]string{}, names: map[string]string{}, projects: map[string]*resourcemanagerpb.Project{}}
+}
+
+// project returns a project, or nil if it can't be looked up
+func (f *folderPaths) project(ctx context.Context, projectId string) *resourcemanagerpb.Project {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, _, err := f.lookup(ctx, f.router.forProject(projectId), "projects/"+projectId)
+	if err != nil {
+		slog.Debug("Failed to look up project", "project", projectId, "error", err, "code", errorCode(err))
+	}
+	return f.projects[projectId]
+}
+
+// path returns the folder path of a project, or an empty string if it can't be looked up
+func (f *folderPaths) path(ctx context.Context, projectId string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var names []string
+	for _, resource := range f.ancestors(ctx, projectId) {
+		if name, ok := f.names[resource]; ok {
+			names = append(names, name)
+		}
+	}
+	slices.Reverse(names)
+	return strings.Join(names, " > ")
+}
+
+// organization returns the display name of the organization of a project, its ID if the name is unknown,
+// or an empty string if the project doesn't belong to an organization or can't be looked up
+func (f *folderPaths) organization(ctx context.Context, projectId string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ancestors := f.ancestors(ctx, projectId)
+	if len(ancestors) == 0 || !strings.HasPrefix(ancestors[len(ancestors)-1], "organizations/") {
+		return ""
+	}
+	organization := ancestors[len(ancestors)-1]
+	if name, ok := f.names[organization]; ok {
+		return name
+	}
+	return strings.TrimPrefix(organization, "organizations/")
+}
+
+// ancestors returns the project and all of its parents up to the organization, or nil if any of them can't be looked up.
+// The caller has to hold f.mu.
+func (f *folderPaths) ancestors(ctx context.Context, projectId string) []string {
+	c := f.router.forProject(projectId)
+	var ancestors []string
+	resource := "projects/" + projectId
+	for {
+		parent, ok := f.parents[resource]
+		if !ok {
+			var name string
+			var err error
+			parent, name, err = f.lookup(ctx, c, resource)
+			if err != nil {
+				slog.Debug("Failed to look up folder path", "project", projectId, "resource", resource, "error", err, "code", errorCode(err))
+				return nil
+			}
+			f.parents[resource] = parent
+			if name != "" {
+				f.names[resource] = name
+			}
+		}
+		ancestors = append(ancestors, resource)
+		if parent == "" {
+			return ancestors
+		}
+		resource = parent
+	}
+}
+
+// lookup returns the parent of a project, folder or organization and the display name of folders and organizations.
+// Projects are cached, so the caller has to hold f.mu.
+func (f *folderPaths) lookup(ctx context.Context, c *clients, resource string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(resource, "projects/"):
+		projectId := strings.TrimPrefix(resource, "projects/")
+		if project, ok := f.projects[projectId]; ok {
+			return project.GetParent(), "", nil
+		}
+		// Projects that can't be looked up are cached as well, so that they aren't looked up for every policy
+		project, err := c.projects.GetProject(ctx, &resourcemanagerpb.GetProjectRequest{Name: resource})
+		f.projects[projectId] = project
+		return project.GetParent(), "", err
+	case strings.HasPrefix(resource, "folders/"):
+		folder, err := c.folders.GetFolder(ctx, &resourcemanagerpb.GetFolderRequest{Name: resource})
+		return folder.GetParent(), folder.GetDisplayName(), err
+	default:
+		organization, err := c.organizations.GetOrganization(ctx, &resourcemanagerpb.GetOrganizationRequest{Name: resource})
+		return "", organization.GetDisplayName(), err
+	}
+}
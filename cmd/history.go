@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+// historyCmd shows how the estimated prices developed over the runs that were written to BigQuery
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the totals over time and the top movers from the results history in BigQuery",
+	Long: `Shows the total estimated price of every run that was written to BigQuery with --bigQueryTable,
+followed by the policies whose price changed the most between the last two runs.`,
+	Example: `./appe history --bigQueryTable PROJECT_ID.appe.history --days 90`,
+	Args:    cobra.NoArgs,
+	Run:     history,
+}
+
+func history(cmd *cobra.Command, args []string) {
+	tableName, err := cmd.Flags().GetString("bigQueryTable")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	days, err := cmd.Flags().GetInt("days")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	top, err := cmd.Flags().GetInt("top")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	credentials, err := cmd.Flags().GetString("credentials")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	table, err := parseBigQueryTable(tableName)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ctx := context.Background()
+	service, err := newBigQueryService(ctx, credentials)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// Filtering on the partitioning column keeps the scanned data to the requested days
+	window := fmt.Sprintf("run_time >= TIMESTAMP_SUB(CURRENT_TIMESTAMP(), INTERVAL %d DAY)", days)
+	totals, err := queryBigQuery(ctx, service, table.project, fmt.Sprintf(
+		"SELECT FORMAT_TIMESTAMP('%%Y-%%m-%%d %%H:%%M', run_time), run_id, COUNT(*), SUM(price), COUNTIF(error != '') FROM `%s` WHERE %s GROUP BY run_time, run_id ORDER BY run_time",
+		table, window), nil)
+	if err != nil {
+		log.Fatalf("Failed to query totals: %v", err)
+	}
+	if len(totals) == 0 {
+		log.Fatalf("No runs were written to %s in the last %d days", table, days)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "Run\tRun ID\tPolicies\tFailed\tPrice\tChange\t\n")
+	previous := 0.0
+	for i, row := range totals {
+		price := parseFloat(row[3])
+		change := ""
+		if i > 0 {
+			change = formatDelta(price - previous)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t$%.2f\t%s\t\n", row[0], row[1], row[2], row[4], price, change)
+		previous = price
+	}
+	w.Flush()
+	if len(totals) < 2 {
+		return
+	}
+
+	// The top movers compare the results of each policy in the last two runs, policies that only exist in one of them count as $0 in the other
+	last, before := totals[len(totals)-1][1], totals[len(totals)-2][1]
+	movers, err := queryBigQuery(ctx, service, table.project, fmt.Sprintf(`WITH
+  cur AS (SELECT policy_name, ANY_VALUE(display_name) AS display_name, ANY_VALUE(project_id) AS project_id, SUM(price) AS price FROM `+"`%[1]s`"+` WHERE %[2]s AND run_id = @last GROUP BY policy_name),
+  prev AS (SELECT policy_name, ANY_VALUE(display_name) AS display_name, ANY_VALUE(project_id) AS project_id, SUM(price) AS price FROM `+"`%[1]s`"+` WHERE %[2]s AND run_id = @before GROUP BY policy_name)
+SELECT COALESCE(cur.display_name, prev.display_name), COALESCE(cur.project_id, prev.project_id), IFNULL(prev.price, 0), IFNULL(cur.price, 0), IFNULL(cur.price, 0) - IFNULL(prev.price, 0) AS delta
+FROM cur FULL OUTER JOIN prev ON cur.policy_name = prev.policy_name
+WHERE ABS(IFNULL(cur.price, 0) - IFNULL(prev.price, 0)) >= 0.01
+ORDER BY ABS(delta) DESC
+LIMIT %[3]d`, table, window, top), map[string]string{"last": last, "before": before})
+	if err != nil {
+		log.Fatalf("Failed to query top movers: %v", err)
+	}
+	fmt.Printf("\nTop movers between the last two runs:\n")
+	if len(movers) == 0 {
+		fmt.Println("No policy changed its price.")
+		return
+	}
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Policy\tProject\tBefore\tNow\tChange\n")
+	for _, row := range movers {
+		fmt.Fprintf(w, "%s\t%s\t$%.2f\t$%.2f\t%s\n", row[0], row[1], parseFloat(row[2]), parseFloat(row[3]), formatDelta(parseFloat(row[4])))
+	}
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.Flags().String("bigQueryTable", "", "The BigQuery table the results were written to in the format PROJECT.DATASET.TABLE.")
+	historyCmd.Flags().Int("days", 30, "The number of days to show the runs of.")
+	historyCmd.Flags().Int("top", 10, "The number of policies to show that changed the most between the last two runs.")
+	historyCmd.Flags().String("credentials", "", "Path to a service account key or workload identity federation configuration. Defaults to the credentials stored by \"appe auth login\" if they exist, otherwise the application default credentials are used.")
+	historyCmd.MarkFlagRequired("bigQueryTable")
+}
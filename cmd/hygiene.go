@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+)
+
+// policyHygiene returns the hygiene findings of a policy: no notification channels, no documentation, no severity,
+// or disabled for longer than disabledFor at now. Policies don't record when they were disabled, so the time of their last change is used.
+func policyHygiene(alertPolicy *monitoringpb.AlertPolicy, now time.Time, disabledFor time.Duration) []string {
+	var findings []string
+	if len(alertPolicy.GetNotificationChannels()) == 0 {
+		findings = append(findings, "no notification channels")
+	}
+	if alertPolicy.GetDocumentation().GetContent() == "" {
+		findings = append(findings, "no documentation")
+	}
+	if alertPolicy.GetSeverity() == monitoringpb.AlertPolicy_SEVERITY_UNSPECIFIED {
+		findings = append(findings, "no severity")
+	}
+	if alertPolicy.GetEnabled() != nil && !alertPolicy.GetEnabled().GetValue() {
+		changed := alertPolicy.GetMutationRecord().GetMutateTime()
+		if changed == nil {
+			changed = alertPolicy.GetCreationRecord().GetMutateTime()
+		}
+		if changed != nil && now.Sub(changed.AsTime()) > disabledFor {
+			findings = append(findings, fmt.Sprintf("disabled for %d days", int(now.Sub(changed.AsTime())/(24*time.Hour))))
+		}
+	}
+	return findings
+}
@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"google.golang.org/api/iterator"
+	logging "google.golang.org/api/logging/v2"
+	monitoring_v1 "google.golang.org/api/monitoring/v1"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// prometheusSamplePrice is the price of ingesting a sample into Managed Service for Prometheus in the first tier of $0.06 per million samples
+	prometheusSamplePrice = 0.06 / 1e6
+	// logBytePrice is the price of ingesting a byte of logs into Cloud Logging at $0.50 per GiB
+	logBytePrice = 0.5 / (1 << 30)
+	// logSampleSize is the number of recent log entries whose average size is used as the size of all entries of a log-based metric
+	logSampleSize = 50
+	// logMetricPrefix is the prefix of the types of user-defined log-based metrics
+	logMetricPrefix = "logging.googleapis.com/user/"
+)
+
+var (
+	// promQLIgnoredPattern matches the parts of a PromQL query that can't contain metric names: strings, label matchers, ranges and label lists of modifiers
+	promQLIgnoredPattern = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|\{[^}]*\}|\[[^\]]*\]|\b(?:by|without|on|ignoring|group_left|group_right)\s*\([^)]*\)`)
+	// promQLIdentifierPattern matches identifiers, which are metric names unless they are part of a number or followed by an opening parenthesis
+	promQLIdentifierPattern = regexp.MustCompile(`[A-Za-z_:][A-Za-z0-9_:]*`)
+	// promQLNamePattern matches metric names given as a label matcher, e.g. {__name__="up"}
+	promQLNamePattern = regexp.MustCompile(`__name__\s*=\s*"([^"]+)"`)
+	// promQLKeywords are identifiers that are not metric names
+	promQLKeywords = []string{"and", "or", "unless", "bool", "offset", "by", "without", "on", "ignoring", "group_left", "group_right", "inf", "nan", "Inf", "NaN"}
+)
+
+// promQLMetricNames returns the names of the metrics that a PromQL query selects.
+// The query isn't fully parsed, instead all identifiers that can't be anything else are assumed to be metric names.
+func promQLMetricNames(query string) []string {
+	var names []string
+	for _, match := range promQLNamePattern.FindAllStringSubmatch(query, -1) {
+		names = append(names, match[1])
+	}
+	stripped := promQLIgnoredPattern.ReplaceAllString(query, " ")
+	for _, match := range promQLIdentifierPattern.FindAllStringIndex(stripped, -1) {
+		identifier := stripped[match[0]:match[1]]
+		// Identifiers that follow a digit or dot are part of a number, e.g. the exponent of 1e3
+		if match[0] > 0 && strings.ContainsAny(stripped[match[0]-1:match[0]], "0123456789.") {
+			continue
+		}
+		// Functions and aggregations are followed by their arguments
+		if strings.HasPrefix(strings.TrimSpace(stripped[match[1]:]), "(") || slices.Contains(promQLKeywords, identifier) {
+			continue
+		}
+		names = append(names, identifier)
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// prometheusIngestion estimates the monthly price of ingesting the samples of all metrics that the PromQL conditions of a policy select.
+// The samples of each metric are counted over the last hour of the window. Metrics that are selected by multiple policies are counted for each of them.
+func (e *estimator) prometheusIngestion(ctx context.Context, name string, conditions []*monitoringpb.AlertPolicy_Condition) (float64, error) {
+	var metrics []string
+	for _, condition := range conditions {
+		if pql := condition.GetConditionPrometheusQueryLanguage(); pql != nil {
+			metrics = append(metrics, promQLMetricNames(pql.GetQuery())...)
+		}
+	}
+	slices.Sort(metrics)
+	price := 0.0
+	for _, metric := range slices.Compact(metrics) {
+		samples, err := e.cache.count(cacheKey(name, "gmp", metric, e.end.AsTime().Sub(e.start.AsTime()).String()), func() (count int, err error) {
+			err = e.limiter.run(ctx, func() (err error) {
+				err = e.timeSeriesRate.Wait(ctx)
+				if err != nil {
+					return err
+				}
+				count, err = countPromQLSamples(ctx, e.monitoring_v1Service, name, metric, e.end)
+				return err
+			})
+			return count, err
+		})
+		if err != nil {
+			return price, fmt.Errorf("failed to count samples of %s: %v", metric, err)
+		}
+		// 24 (hours) * 30 (days) = 720 hours per month
+		price += float64(samples) * 720 * prometheusSamplePrice
+	}
+	return price, nil
+}
+
+// countPromQLSamples returns the number of samples of a metric that were ingested in the hour before end
+func countPromQLSamples(ctx context.Context, monitoring_v1Service *monitoring_v1.Service, name string, metric string, end *timestamppb.Timestamp) (int, error) {
+	call := monitoring_v1Service.Projects.Location.Prometheus.Api.V1.Query(name, "global", &monitoring_v1.QueryInstantRequest{
+		Query: fmt.Sprintf(`sum(count_over_time({__name__=%q}[1h]))`, metric),
+		Time:  end.AsTime().Format(time.RFC3339),
+	})
+	if quotaProject := contextQuotaProject(ctx); quotaProject != "" {
+		call.Header().Set(quotaProjectHeader, quotaProject)
+	}
+	resp, err := call.Context(ctx).Do()
+	if err != nil {
+		return 0, err
+	}
+	j, err := resp.MarshalJSON()
+	if err != nil {
+		return 0, err
+	}
+	var instant struct {
+		Data struct {
+			Result []struct {
+				Value []any `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	err = json.Unmarshal(j, &instant)
+	if err != nil {
+		return 0, err
+	}
+	// A metric without samples has no result, otherwise the value is a pair of the time and the number as a string
+	if len(instant.Data.Result) == 0 || len(instant.Data.Result[0].Value) < 2 {
+		return 0, nil
+	}
+	samples, err := strconv.ParseFloat(fmt.Sprint(instant.Data.Result[0].Value[1]), 64)
+	return int(samples), err
+}
+
+// logIngestion estimates the monthly price of ingesting the log entries that the log-based metrics of the threshold and absence conditions of a policy count.
+// The number of entries is taken from the time series of each metric in the window, their size from the average size of its most recent entries.
+// Log entries that are counted by multiple metrics or policies are counted for each of them.
+func (e *estimator) logIngestion(ctx context.Context, name string, conditions []*monitoringpb.AlertPolicy_Condition) (float64, error) {
+	var metrics []string
+	for _, condition := range conditions {
+		filter := condition.GetConditionThreshold().GetFilter()
+		if condition.GetConditionAbsent() != nil {
+			filter = condition.GetConditionAbsent().GetFilter()
+		}
+		if metricType := filterValue(filter, "metric.type"); strings.HasPrefix(metricType, logMetricPrefix) {
+			metrics = append(metrics, strings.TrimPrefix(metricType, logMetricPrefix))
+		}
+	}
+	slices.Sort(metrics)
+	window := e.end.AsTime().Sub(e.start.AsTime())
+	price := 0.0
+	for _, metric := range slices.Compact(metrics) {
+		entries, err := e.cache.count(cacheKey(name, "logentries", metric, window.String()), func() (count int, err error) {
+			err = e.limiter.run(ctx, func() (err error) {
+				count, err = countLogEntries(ctx, e.metricClient, name, metric, e.start, e.end)
+				return err
+			})
+			return count, err
+		})
+		if err != nil {
+			return price, fmt.Errorf("failed to count log entries of %s: %v", metric, err)
+		}
+		if entries == 0 {
+			continue
+		}
+		size, err := e.cache.count(cacheKey(name, "logsize", metric), func() (size int, err error) {
+			err = e.limiter.run(ctx, func() (err error) {
+				size, err = averageLogEntrySize(ctx, e.loggingService, name, metric)
+				return err
+			})
+			return size, err
+		})
+		if err != nil {
+			return price, fmt.Errorf("failed to sample log entries of %s: %v", metric, err)
+		}
+		// The entries of the window are scaled to 30 days
+		price += float64(entries) * float64(30*24*time.Hour) / float64(window) * float64(size) * logBytePrice
+	}
+	return price, nil
+}
+
+// countLogEntries returns the number of log entries that a log-based metric counted between start and end.
+// Counter metrics have one point per entry, distribution metrics have one value in their distribution per entry.
+func countLogEntries(ctx context.Context, metricClient *monitoring.MetricClient, name string, metric string, start *timestamppb.Timestamp, end *timestamppb.Timestamp) (int, error) {
+	it := metricClient.ListTimeSeries(ctx, &monitoringpb.ListTimeSeriesRequest{
+		Name:     name,
+		Filter:   fmt.Sprintf("metric.type = %q", logMetricPrefix+metric),
+		Interval: &monitoringpb.TimeInterval{StartTime: start, EndTime: end},
+		Aggregation: &monitoringpb.Aggregation{
+			AlignmentPeriod:    durationpb.New(end.AsTime().Sub(start.AsTime())),
+			PerSeriesAligner:   monitoringpb.Aggregation_ALIGN_DELTA,
+			CrossSeriesReducer: monitoringpb.Aggregation_REDUCE_SUM,
+		},
+	})
+	count := 0
+	for {
+		timeSeries, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		for _, point := range timeSeries.GetPoints() {
+			if distribution := point.GetValue().GetDistributionValue(); distribution != nil {
+				count += int(distribution.GetCount())
+			} else {
+				count += int(point.GetValue().GetInt64Value())
+			}
+		}
+	}
+}
+
+// averageLogEntrySize returns the average size in bytes of the most recent log entries that match the filter of a log-based metric
+func averageLogEntrySize(ctx context.Context, loggingService *logging.Service, name string, metric string) (int, error) {
+	logMetric, err := loggingService.Projects.Metrics.Get(name + "/metrics/" + metric).Context(ctx).Do()
+	if err != nil {
+		return 0, err
+	}
+	response, err := loggingService.Entries.List(&logging.ListLogEntriesRequest{
+		ResourceNames: []string{name},
+		Filter:        logMetric.Filter,
+		OrderBy:       "timestamp desc",
+		PageSize:      logSampleSize,
+	}).Context(ctx).Do()
+	if err != nil {
+		return 0, err
+	}
+	if len(response.Entries) == 0 {
+		return 0, nil
+	}
+	total := 0
+	for _, entry := range response.Entries {
+		b, err := entry.MarshalJSON()
+		if err != nil {
+			return 0, err
+		}
+		total += len(b)
+	}
+	return total / len(response.Entries), nil
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+// readTargets reads newline-separated project IDs or policy names from path or from stdin if path is "-".
+// Empty lines and lines starting with "#" are ignored.
+func readTargets(path string) (projects []string, policies []string, err error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.Contains(line, "/alertPolicies/") {
+			policies = append(policies, line)
+		} else {
+			projects = append(projects, line)
+		}
+	}
+	return projects, policies, scanner.Err()
+}
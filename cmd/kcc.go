@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// kccResource is a Kubernetes resource manifest, of which only MonitoringAlertPolicy resources of Config Connector are read
+type kccResource struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name string `yaml:"name"`
+	} `yaml:"metadata"`
+	Spec  map[string]any `yaml:"spec"`
+	Items []kccResource  `yaml:"items"`
+}
+
+// readConfigConnectorManifests reads the MonitoringAlertPolicy resources of Config Connector from a file with one or more YAML documents,
+// including lists of resources, and adds them to definitions as if they were created in projectId.
+func readConfigConnectorManifests(path string, projectId string, definitions policyDefinitions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	decoder := yaml.NewDecoder(f)
+	for {
+		var resource kccResource
+		err = decoder.Decode(&resource)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, r := range append([]kccResource{resource}, resource.Items...) {
+			if r.Kind != "MonitoringAlertPolicy" {
+				continue
+			}
+			policy, err := policyFromFields(r.Spec)
+			if err != nil {
+				return fmt.Errorf("invalid MonitoringAlertPolicy %s: %v", r.Metadata.Name, err)
+			}
+			// The resource ID is the name of the policy in Cloud Monitoring, but it is generated by the API unless it is given
+			id := r.Metadata.Name
+			if resourceId, ok := r.Spec["resourceID"].(string); ok && resourceId != "" {
+				id = resourceId
+			}
+			definitions.add(projectId, "kcc", id, policy)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/googleapis/gax-go/v2/apierror"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+)
+
+// projectLimit caps the number of projects that are processed in a single run. A limit of 0 means no limit.
+type projectLimit struct {
+	max   int64
+	count atomic.Int64
+	once  sync.Once
+}
+
+// take reports whether another project may be processed and logs a message the first time the limit is hit
+func (l *projectLimit) take() bool {
+	if l.max <= 0 || l.count.Add(1) <= l.max {
+		return true
+	}
+	l.once.Do(func() {
+		slog.Warn("Reached the project limit, all further projects will be skipped. Use --maxProjects to raise the limit.", "limit", l.max)
+	})
+	return false
+}
+
+// reached reports whether the limit has been hit, so that project discovery can be stopped early
+func (l *projectLimit) reached() bool {
+	return l.max > 0 && l.count.Load() >= l.max
+}
+
+// exceeded reports whether any project was skipped because of the limit
+func (l *projectLimit) exceeded() bool {
+	return l.max > 0 && l.count.Load() > l.max
+}
+
+// adaptiveLimiter limits the number of concurrent time series queries. The limit is halved whenever a query runs into
+// quota limits (RESOURCE_EXHAUSTED) and is slowly raised again up to max while queries succeed.
+type adaptiveLimiter struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	max       int
+	limit     int
+	inFlight  int
+	successes int
+	// retries counts the calls that were retried because the quota was exhausted
+	retries atomic.Int64
+}
+
+func newAdaptiveLimiter(max int) *adaptiveLimiter {
+	l := &adaptiveLimiter{
+		max:   max,
+		limit: max,
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// run executes f once a slot is available and retries it with an exponential backoff while it fails because of exhausted quota
+func (l *adaptiveLimiter) run(ctx context.Context, f func() error) error {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		l.acquire()
+		err := f()
+		l.release(err)
+		if !isResourceExhausted(err) || attempt == 5 {
+			return err
+		}
+		l.retries.Add(1)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (l *adaptiveLimiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.inFlight >= l.limit {
+		l.cond.Wait()
+	}
+	l.inFlight++
+}
+
+func (l *adaptiveLimiter) release(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+	if isResourceExhausted(err) {
+		l.successes = 0
+		if l.limit > 1 {
+			l.limit /= 2
+			slog.Warn("Quota exhausted, reducing concurrent queries", "limit", l.limit)
+		}
+	} else if err == nil {
+		l.successes++
+		if l.successes >= l.limit && l.limit < l.max {
+			l.limit++
+			l.successes = 0
+		}
+	}
+	l.cond.Broadcast()
+}
+
+// isResourceExhausted reports whether err was returned because a quota was exhausted, either via gRPC or REST
+func isResourceExhausted(err error) bool {
+	apiErr, ok := apierror.FromError(err)
+	if !ok {
+		return false
+	}
+	return apiErr.GRPCStatus().Code() == codes.ResourceExhausted || apiErr.HTTPCode() == http.StatusTooManyRequests
+}
+
+// newRateLimiter returns a limiter that allows qps requests per second or an unlimited one if qps is 0
+func newRateLimiter(qps float64) *rate.Limiter {
+	if qps <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	return rate.NewLimiter(rate.Limit(qps), max(int(qps), 1))
+}
+
+// rateLimitOption returns a client option that makes every gRPC call of the client wait for the limiter first.
+// This also covers the calls to fetch further pages, which happen within the iterators.
+func rateLimitOption(limiter *rate.Limiter) option.ClientOption {
+	return option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := limiter.Wait(ctx)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}))
+}
+
+// grpcOptions returns the client options to tune the gRPC connections of a client.
+// A pool size of 0 keeps the default of the client library and a keepalive of 0 disables keepalive pings.
+func grpcOptions(poolSize int, keepaliveTime time.Duration, keepaliveTimeout time.Duration) []option.ClientOption {
+	var opts []option.ClientOption
+	if poolSize > 0 {
+		opts = append(opts, option.WithGRPCConnectionPool(poolSize))
+	}
+	if keepaliveTime > 0 {
+		opts = append(opts, option.WithGRPCDialOption(grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                keepaliveTime,
+			Timeout:             keepaliveTimeout,
+			PermitWithoutStream: true,
+		})))
+	}
+	return opts
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"strconv"
+
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/grpc/status"
+)
+
+// textLogger is the default logger of slog, which writes in the format of the log package
+var textLogger = slog.Default()
+
+// levelTrace is the level of messages about every single query, which are only printed with -vv
+const levelTrace = slog.LevelDebug - 4
+
+// setupLogging configures the default logger to write to w in the given format and verbosity.
+// "text" keeps the default human-readable format of the log package with structured attributes appended,
+// "json" writes one JSON object per line so that the logs can be parsed when appe runs unattended,
+// "cloud" does the same with the field names of Cloud Logging, so that it picks up the severity and message of each entry.
+// Each level of verbosity additionally prints the messages of the next lower level, starting from info.
+func setupLogging(format string, verbosity int, w io.Writer) error {
+	level := slog.LevelInfo - slog.Level(4*min(verbosity, 2))
+	switch format {
+	case "text":
+		// The logger is set explicitly, as a previous call may have replaced it and redirected the log package to it
+		log.SetOutput(w)
+		log.SetFlags(log.LstdFlags)
+		slog.SetDefault(textLogger)
+		slog.SetLogLoggerLevel(level)
+		return nil
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})))
+		return nil
+	case "cloud":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level, ReplaceAttr: cloudLoggingAttr})))
+		return nil
+	default:
+		return fmt.Errorf("invalid log format %q, must be \"text\", \"json\" or \"cloud\"", format)
+	}
+}
+
+// cloudLoggingAttr renames the level and message of a log entry to the fields Cloud Logging expects
+func cloudLoggingAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.LevelKey:
+		level := a.Value.Any().(slog.Level)
+		switch {
+		case level >= slog.LevelError:
+			return slog.String("severity", "ERROR")
+		case level >= slog.LevelWarn:
+			return slog.String("severity", "WARNING")
+		case level >= slog.LevelInfo:
+			return slog.String("severity", "INFO")
+		default:
+			return slog.String("severity", "DEBUG")
+		}
+	case slog.MessageKey:
+		return slog.Attr{Key: "message", Value: a.Value}
+	}
+	return a
+}
+
+// errorCode returns the status code of an API error, e.g. "PermissionDenied" for gRPC or "403" for REST calls
+func errorCode(err error) string {
+	if apiErr, ok := apierror.FromError(err); ok {
+		if apiErr.GRPCStatus() != nil {
+			return apiErr.GRPCStatus().Code().String()
+		}
+		return strconv.Itoa(apiErr.HTTPCode())
+	}
+	return status.Code(err).String()
+}
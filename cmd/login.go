@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// authCmd groups the commands to manage the credentials of appe
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Manage the credentials appe uses",
+}
+
+// loginCmd logs in a user with their Google account without gcloud
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Log in with your Google account in the browser",
+	Long: `Logs in with your Google account in the browser and stores the credentials for all following runs of appe.
+This is an alternative to "gcloud auth application-default login" for users without gcloud.
+It requires an OAuth client of the type "Desktop app", which you can create under "APIs & Services" > "Credentials" in the Google Cloud console.`,
+	Example: `./appe auth login --clientSecretFile client_secret.json`,
+	Args:    cobra.NoArgs,
+	Run:     login,
+}
+
+// defaultCredentialsPath returns the path that "appe auth login" stores the credentials of the user in, e.g. ~/.config/appe/credentials.json on Linux
+func defaultCredentialsPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "appe", "credentials.json")
+}
+
+// login runs the OAuth authorization code flow with a redirect to a local server and stores the resulting refresh token
+func login(cmd *cobra.Command, args []string) {
+	clientSecretFile, err := cmd.Flags().GetString("clientSecretFile")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	scopes, err := cmd.Flags().GetStringSlice("scopes")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	out, err := cmd.Flags().GetString("out")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if out == "" {
+		log.Fatalln("Failed to determine your user config directory, please set --out")
+	}
+
+	b, err := os.ReadFile(clientSecretFile)
+	if err != nil {
+		log.Fatalf("Failed to read client secret file: %v", err)
+	}
+	config, err := google.ConfigFromJSON(b, scopes...)
+	if err != nil {
+		log.Fatalf("Failed to parse client secret file: %v", err)
+	}
+
+	// Google redirects to any port on the loopback address for desktop clients
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatalf("Failed to start local server: %v", err)
+	}
+	config.RedirectURL = fmt.Sprintf("http://%s/", listener.Addr())
+	state := make([]byte, 16)
+	_, err = rand.Read(state)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	codes := make(chan string, 1)
+	errs := make(chan error, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("state") != hex.EncodeToString(state) {
+			http.Error(w, "Invalid state", http.StatusBadRequest)
+			return
+		}
+		if query.Get("error") != "" {
+			fmt.Fprintln(w, "Login failed, you can close this window.")
+			errs <- errors.New(query.Get("error"))
+			return
+		}
+		fmt.Fprintln(w, "Login successful, you can close this window.")
+		codes <- query.Get("code")
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	fmt.Printf("Open the following link in your browser to log in:\n\n%s\n\n", config.AuthCodeURL(hex.EncodeToString(state), oauth2.AccessTypeOffline, oauth2.ApprovalForce, oauth2.S256ChallengeOption(verifier)))
+	var code string
+	select {
+	case code = <-codes:
+	case err = <-errs:
+		log.Fatalf("Failed to log in: %v", err)
+	}
+	token, err := config.Exchange(context.Background(), code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		log.Fatalf("Failed to exchange authorization code: %v", err)
+	}
+	if token.RefreshToken == "" {
+		log.Fatalln("Failed to log in: no refresh token was returned")
+	}
+
+	// The credentials are stored in the same format as the application default credentials of gcloud
+	credentials, err := json.MarshalIndent(map[string]string{
+		"type":          "authorized_user",
+		"client_id":     config.ClientID,
+		"client_secret": config.ClientSecret,
+		"refresh_token": token.RefreshToken,
+	}, "", "  ")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	err = os.MkdirAll(filepath.Dir(out), 0700)
+	if err != nil {
+		log.Fatalf("Failed to create credentials directory: %v", err)
+	}
+	err = os.WriteFile(out, credentials, 0600)
+	if err != nil {
+		log.Fatalf("Failed to store credentials: %v", err)
+	}
+	fmt.Printf("Credentials stored in %s\n", out)
+}
+
+func init() {
+	rootCmd.AddCommand(authCmd)
+	authCmd.AddCommand(loginCmd)
+	loginCmd.Flags().String("clientSecretFile", "", "Path to the client secret JSON file of an OAuth client of the type \"Desktop app\".")
+	loginCmd.Flags().StringSlice("scopes", readOnlyScopes, "The OAuth scopes to request. Add \"https://www.googleapis.com/auth/cloud-platform\" to use --discovery asset. Separated by \",\".")
+	loginCmd.Flags().String("out", defaultCredentialsPath(), "Path to store the credentials in. appe uses the default path automatically if --credentials is not set.")
+	loginCmd.MarkFlagRequired("clientSecretFile")
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+// lookerStudioSink writes a denormalized CSV file for Looker Studio, with one row per policy that holds everything a report needs
+// to filter and group by without joins: the run date, the project and its folder path, the labels and the components of the price
+type lookerStudioSink struct {
+	ctx     context.Context
+	file    *os.File
+	writer  *csv.Writer
+	upload  func(*os.File) error
+	paths   *folderPaths
+	tags    string
+	runTime time.Time
+}
+
+func newLookerStudioSink(ctx context.Context, path string, storageService *storage.Service, paths *folderPaths, tags map[string]string, runTime time.Time) (*lookerStudioSink, error) {
+	f, upload, err := createOutput(path, storageService)
+	if err != nil {
+		return nil, err
+	}
+	s := &lookerStudioSink{ctx: ctx, file: f, writer: csv.NewWriter(f), upload: upload, paths: paths, tags: joinLabels(tags), runTime: runTime}
+	// Column names are in snake case, so that Looker Studio can use them as field IDs
+	header := []string{"run_date", "run_time", "project_id", "folder_path", "policy_name", "policy_display_name", "policy_link", "labels", "conditions", "time_series", "condition_fee", "time_series_cost", "total_price", "error"}
+	if len(tags) > 0 {
+		header = append(header, "tags")
+	}
+	err = s.writer.Write(header)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.writer.Flush()
+	return s, s.writer.Error()
+}
+
+func (s *lookerStudioSink) write(p *policy) error {
+	conditionFee := 1.5 * float64(p.Conditions)
+	record := []string{
+		s.runTime.Format(time.DateOnly),
+		s.runTime.Format(time.RFC3339),
+		p.ProjectId,
+		s.paths.path(s.ctx, p.ProjectId),
+		p.Name,
+		p.DisplayName,
+		policyLink(p),
+		joinLabels(p.UserLabels),
+		strconv.Itoa(p.Conditions),
+		strconv.Itoa(p.TimeSeries),
+		strconv.FormatFloat(conditionFee, 'f', 2, 64),
+		strconv.FormatFloat(p.Price-conditionFee, 'f', 6, 64),
+		strconv.FormatFloat(p.Price, 'f', 2, 64),
+		p.Error,
+	}
+	if s.tags != "" {
+		record = append(record, s.tags)
+	}
+	err := s.writer.Write(record)
+	if err != nil {
+		return err
+	}
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+func (s *lookerStudioSink) close(partial bool) error {
+	return finishOutput(s.file, s.upload)
+}
+
+// joinLabels writes labels as "key=value" pairs separated by ";", which REGEXP_EXTRACT can pick single labels from
+func joinLabels(labels map[string]string) string {
+	var pairs []string
+	for _, key := range slices.Sorted(maps.Keys(labels)) {
+		pairs = append(pairs, key+"="+labels[key])
+	}
+	return strings.Join(pairs, ";")
+}
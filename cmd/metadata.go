@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// runMetadata describes how results were produced, so that result files are self-describing when they are looked at later
+type runMetadata struct {
+	version string
+	started time.Time
+	flags   []string
+	start   time.Time
+	end     time.Time
+	pricing string
+}
+
+// newRunMetadata collects the metadata of a run that counts time series between start and end
+func newRunMetadata(flags *pflag.FlagSet, version string, start time.Time, end time.Time) *runMetadata {
+	m := &runMetadata{
+		version: version,
+		started: time.Now(),
+		start:   start,
+		end:     end,
+		pricing: pricingVersion,
+		flags:   redactedFlags(flags),
+	}
+	return m
+}
+
+// redactedFlags returns the flags that were set as --NAME=VALUE, without the secrets in their values
+func redactedFlags(flags *pflag.FlagSet) []string {
+	var redacted []string
+	flags.Visit(func(flag *pflag.Flag) {
+		value := flag.Value.String()
+		// Webhook URLs contain a secret token
+		if flag.Name == "slackWebhook" || flag.Name == "chatWebhook" || flag.Name == "webhookSecret" {
+			value = "REDACTED"
+		}
+		// The proxy and webhook URLs may contain a user name and password
+		if flag.Name == "proxy" || flag.Name == "webhook" {
+			if u, err := url.Parse(value); err == nil {
+				value = u.Redacted()
+			}
+		}
+		redacted = append(redacted, fmt.Sprintf("--%s=%s", flag.Name, value))
+	})
+	return redacted
+}
+
+// fields returns the metadata as ordered pairs of keys and values
+func (m *runMetadata) fields() [][2]string {
+	return [][2]string{
+		{"tool", "appe " + m.version},
+		{"timestamp", m.started.Format(time.RFC3339)},
+		{"flags", strings.Join(m.flags, " ")},
+		{"window", m.start.Format(time.RFC3339) + "/" + m.end.Format(time.RFC3339)},
+		{"pricing", m.pricing},
+	}
+}
+
+// log writes the metadata as a single log record, which becomes one JSON object with --logFormat json
+func (m *runMetadata) log(level slog.Level) {
+	var attrs []any
+	for _, field := range m.fields() {
+		attrs = append(attrs, field[0], field[1])
+	}
+	slog.Log(context.Background(), level, "Run metadata", attrs...)
+}
@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"github.com/spf13/cobra"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// metricsUsageCmd counts the active time series of every metric type in projects, independent of their alerting policies
+var metricsUsageCmd = &cobra.Command{
+	Use:   "metrics-usage",
+	Short: "Show the number of active time series per metric type and what alerting on all of them would cost",
+	Long: `Lists the metric types of each project and counts their time series that had data in the window, independent of any
+alerting policy. As a condition is charged per time series it matches, this shows which metrics would be expensive to alert on
+before anyone writes the policy. The alerting price is that of a single condition on all time series of the metric without any
+aggregation, executed every 30 seconds.`,
+	Example: `./appe metrics-usage -p PROJECT_ID
+./appe metrics-usage -p PROJECT_ID -p OTHER_PROJECT_ID --metricPrefix custom.googleapis.com/ --metricPrefix workload.googleapis.com/ -d 24h`,
+	Args: cobra.NoArgs,
+	Run:  metricsUsage,
+}
+
+// metricUsage is the number of active time series of a metric type in a project
+type metricUsage struct {
+	projectId  string
+	metricType string
+	timeSeries int
+}
+
+func metricsUsage(cmd *cobra.Command, args []string) {
+	projects, err := cmd.Flags().GetStringSlice("project")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	duration, err := cmd.Flags().GetDuration("duration")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	prefixes, err := cmd.Flags().GetStringSlice("metricPrefix")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	threads, err := cmd.Flags().GetInt("threads")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	qps, err := cmd.Flags().GetFloat64("qps")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	csvOut, err := cmd.Flags().GetString("csvOut")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	credentials, err := cmd.Flags().GetString("credentials")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if strings.HasPrefix(csvOut, "gs://") {
+		log.Fatalln("csvOut must be a local path")
+	}
+
+	ctx := context.Background()
+	metricClient, err := newMetricClient(ctx, credentials, qps)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer metricClient.Close()
+	end := time.Now()
+	interval := &monitoringpb.TimeInterval{StartTime: timestamppb.New(end.Add(-duration)), EndTime: timestamppb.New(end)}
+
+	// The metric types of all projects are counted by the same threads, so that a project with many metrics doesn't hold up the others
+	work := make(chan metricUsage, max(threads, 1))
+	go func() {
+		defer close(work)
+		for _, projectId := range projects {
+			metricTypes, err := listMetricTypes(ctx, metricClient, projectId, prefixes)
+			if err != nil {
+				slog.Warn("Failed to list metric descriptors", "project", projectId, "error", err, "code", errorCode(err))
+			}
+			slog.Debug("Listed metric descriptors", "project", projectId, "metrics", len(metricTypes))
+			for _, metricType := range metricTypes {
+				work <- metricUsage{projectId: projectId, metricType: metricType}
+			}
+		}
+	}()
+	var mu sync.Mutex
+	var usages []metricUsage
+	var wg sync.WaitGroup
+	for range max(threads, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for usage := range work {
+				var err error
+				usage.timeSeries, err = countTimeSeries(ctx, metricClient, &monitoringpb.ListTimeSeriesRequest{
+					Name:     "projects/" + usage.projectId,
+					Filter:   fmt.Sprintf("metric.type = %q", usage.metricType),
+					Interval: interval,
+					View:     monitoringpb.ListTimeSeriesRequest_HEADERS,
+				}, "list")
+				if err != nil {
+					slog.Warn("Failed to count time series", "project", usage.projectId, "metric", usage.metricType, "error", err, "code", errorCode(err))
+					continue
+				}
+				// Metric types without data in the window can't match any time series of a condition
+				if usage.timeSeries == 0 {
+					continue
+				}
+				mu.Lock()
+				usages = append(usages, usage)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	slices.SortFunc(usages, func(a, b metricUsage) int {
+		return cmp.Or(strings.Compare(a.projectId, b.projectId), cmp.Compare(b.timeSeries, a.timeSeries), strings.Compare(a.metricType, b.metricType))
+	})
+
+	if csvOut != "" {
+		records := [][]string{{"Project ID", "Metric Type", "Time Series", "Alerting Price"}}
+		for _, usage := range usages {
+			records = append(records, []string{usage.projectId, usage.metricType, strconv.Itoa(usage.timeSeries), strconv.FormatFloat(0.03024*float64(usage.timeSeries), 'f', 2, 64)})
+		}
+		err = writeCSVOutput(csvOut, nil, records)
+		if err != nil {
+			log.Fatalf("Failed to write %s: %v", csvOut, err)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Project\tMetric Type\tTime Series\tAlerting Price\t\n")
+	for i, usage := range usages {
+		fmt.Fprintf(w, "%s\t%s\t%d\t$%.2f\t\n", usage.projectId, usage.metricType, usage.timeSeries, 0.03024*float64(usage.timeSeries))
+		if i == len(usages)-1 || usages[i+1].projectId != usage.projectId {
+			fmt.Fprintf(w, "\t\t\t\t\n")
+		}
+	}
+	w.Flush()
+}
+
+// listMetricTypes returns the types of all metrics of a project, or only of those that start with one of the prefixes
+func listMetricTypes(ctx context.Context, metricClient *monitoring.MetricClient, projectId string, prefixes []string) ([]string, error) {
+	var filters []string
+	for _, prefix := range prefixes {
+		filters = append(filters, fmt.Sprintf("metric.type = starts_with(%q)", prefix))
+	}
+	it := metricClient.ListMetricDescriptors(ctx, &monitoringpb.ListMetricDescriptorsRequest{
+		Name:   "projects/" + projectId,
+		Filter: strings.Join(filters, " OR "),
+	})
+	var metricTypes []string
+	for {
+		descriptor, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return metricTypes, nil
+		}
+		if err != nil {
+			return metricTypes, err
+		}
+		metricTypes = append(metricTypes, descriptor.GetType())
+	}
+}
+
+// newMetricClient creates a Cloud Monitoring client for the commands that only read metrics, using the credentials file at path
+// or the credentials stored by "appe auth login" if it is empty and they exist, otherwise the application default credentials.
+// Its calls are limited to qps per second, or unlimited if qps is 0.
+func newMetricClient(ctx context.Context, path string, qps float64) (*monitoring.MetricClient, error) {
+	if path == "" {
+		if _, err := os.Stat(defaultCredentialsPath()); err == nil {
+			path = defaultCredentialsPath()
+		}
+	}
+	options := []option.ClientOption{rateLimitOption(newRateLimiter(qps))}
+	if path != "" {
+		creds, err := loadCredentials(ctx, path, readOnlyScopes, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load credentials %s: %v", path, err)
+		}
+		options = append(options, option.WithAuthCredentials(creds))
+	}
+	client, err := monitoring.NewMetricClient(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metric client: %v", err)
+	}
+	return client, nil
+}
+
+func init() {
+	rootCmd.AddCommand(metricsUsageCmd)
+	metricsUsageCmd.Flags().StringSliceP("project", "p", []string{}, "The IDs of the projects to count the time series of. Can be given multiple times.")
+	metricsUsageCmd.Flags().DurationP("duration", "d", 12*time.Hour, "The delta from now to go back in time to count the active time series in.")
+	metricsUsageCmd.Flags().StringSlice("metricPrefix", []string{}, "Only count the metric types that start with one of the prefixes, e.g. custom.googleapis.com/. By default, all metric types of a project are counted, which takes one query for each of them.")
+	metricsUsageCmd.Flags().Int("threads", 8, "The number of metric types to count the time series of at the same time.")
+	metricsUsageCmd.Flags().Float64("qps", 50, "The maximum number of requests per second to Cloud Monitoring. 0 means unlimited.")
+	metricsUsageCmd.Flags().String("csvOut", "", "Path to a CSV file to write the usage to instead of a table on stdout.")
+	metricsUsageCmd.Flags().String("credentials", "", "Path to a service account key or workload identity federation configuration. Defaults to the credentials stored by \"appe auth login\" if they exist, otherwise the application default credentials are used.")
+	metricsUsageCmd.MarkFlagRequired("project")
+}
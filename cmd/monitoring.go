@@ -3,30 +3,68 @@ package cmd
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/iam/apiv1/iampb"
 	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
 	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	metricsscope "cloud.google.com/go/monitoring/metricsscope/apiv1"
+	"cloud.google.com/go/monitoring/metricsscope/apiv1/metricsscopepb"
 	resourcemanager "cloud.google.com/go/resourcemanager/apiv3"
 	"cloud.google.com/go/resourcemanager/apiv3/resourcemanagerpb"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
 	"google.golang.org/api/iterator"
+	logging "google.golang.org/api/logging/v2"
 	monitoring_v1 "google.golang.org/api/monitoring/v1"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type policy struct {
-	TimeSeries  int
-	Conditions  int
-	ProjectId   string
-	Name        string
-	DisplayName string
-	Error       string
-	Price       float64
+	TimeSeries int
+	Conditions int
+	ProjectId  string
+	// ProjectName is the display name of the project, if it was looked up
+	ProjectName string
+	// FolderPath is the path of display names from the organization down to the folder of the project, if it was looked up
+	FolderPath string
+	// Organization is the display name of the organization of the project, if multiple organizations were scanned
+	Organization string
+	Name         string
+	DisplayName  string
+	Error        string
+	Price        float64
+	UserLabels   map[string]string
+	// IngestionPrice is the monthly price of ingesting the data that the policy alerts on, if it was estimated
+	IngestionPrice float64
+	// Metrics are the metric types and PromQL metric names that the conditions of the policy reference
+	Metrics []string
+	// Queries are the normalized queries of the conditions of the policy, to find overlapping policies
+	Queries []string
+	// SuggestedTimeSeries and PotentialSaving are the time series and the monthly saving with the suggested aggregations, if they were estimated
+	SuggestedTimeSeries int
+	PotentialSaving     float64
+	// SimulatedPrice is the price at the simulated evaluation intervals, if any were simulated
+	SimulatedPrice float64
+	// HighCardinality describes the conditions that match more time series than the high cardinality threshold, which usually means that their filter is misconfigured
+	HighCardinality []string
+	// Hygiene are the hygiene findings of the policy, e.g. missing notification channels, if they were checked
+	Hygiene []string
+	// AssumedSeries describes the conditions that failed and are priced with the number of time series given with --assumeSeries
+	AssumedSeries []string
+	// Tags are the key=value pairs given with --tag, which are the same for all policies of a run
+	Tags map[string]string
 }
 
 type pqlResponse struct {
@@ -37,22 +75,29 @@ type pqlResponse struct {
 	} `json:"data"`
 }
 
-func listProjects(ctx context.Context, projectsClient *resourcemanager.ProjectsClient, foldersClient *resourcemanager.FoldersClient, parent string, projects chan string, recursive bool, excludedFolders []string) {
-	if slices.Contains(excludedFolders, parent[strings.Index(parent, "/")+1:]) {
+func listProjects(ctx context.Context, projectsClient *resourcemanager.ProjectsClient, foldersClient *resourcemanager.FoldersClient, parent string, projects chan string, recursive bool, excludedFolders []string, includeDeleteRequested bool, limit *projectLimit) {
+	if slices.Contains(excludedFolders, parent[strings.Index(parent, "/")+1:]) || limit.reached() {
 		return
 	}
+	ctx, span := tracer.Start(ctx, "listProjects", trace.WithAttributes(attribute.String("parent", parent)))
+	defer span.End()
 	itProjects := projectsClient.ListProjects(ctx, &resourcemanagerpb.ListProjectsRequest{
-		Parent: parent,
+		Parent:      parent,
+		ShowDeleted: includeDeleteRequested,
 	})
-	for {
+	for !limit.reached() {
 		project, err := itProjects.Next()
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			log.Printf("Failed to list projects under %s: %v\n", parent, err)
+			slog.Error("Failed to list projects", "parent", parent, "error", err, "code", errorCode(err))
 			break
 		}
+		// Projects pending deletion can't be queried meaningfully, so we skip them unless explicitly requested
+		if project.GetState() == resourcemanagerpb.Project_DELETE_REQUESTED && !includeDeleteRequested {
+			continue
+		}
 		projects <- project.ProjectId
 	}
 	if recursive {
@@ -65,10 +110,37 @@ func listProjects(ctx context.Context, projectsClient *resourcemanager.ProjectsC
 				break
 			}
 			if err != nil {
-				log.Printf("Failed to list folders under %s: %v\n", parent, err)
+				slog.Error("Failed to list folders", "parent", parent, "error", err, "code", errorCode(err))
 				break
 			}
-			listProjects(ctx, projectsClient, foldersClient, folder.Name, projects, recursive, excludedFolders)
+			listProjects(ctx, projectsClient, foldersClient, folder.Name, projects, recursive, excludedFolders, includeDeleteRequested, limit)
+		}
+	}
+}
+
+// expandMetricsScope puts the project and all projects in its metrics scope on the projects channel.
+// Projects that have already been seen are skipped, as they are likely to be part of multiple metrics scopes or the scan itself.
+func expandMetricsScope(ctx context.Context, metricsScopesClient *metricsscope.MetricsScopesClient, projectsClient *resourcemanager.ProjectsClient, projectId string, projects chan string, seen *sync.Map) {
+	if _, loaded := seen.LoadOrStore(projectId, true); !loaded {
+		projects <- projectId
+	}
+	scope, err := metricsScopesClient.GetMetricsScope(ctx, &metricsscopepb.GetMetricsScopeRequest{
+		Name: "locations/global/metricsScopes/" + projectId,
+	})
+	if err != nil {
+		slog.Error("Failed to get metrics scope", "project", projectId, "error", err, "code", errorCode(err))
+		return
+	}
+	for _, monitoredProject := range scope.GetMonitoredProjects() {
+		// Monitored projects are referenced by their number, so we need to resolve their IDs
+		name := monitoredProject.GetName()
+		id, err := resolveProject(ctx, projectsClient, name[strings.LastIndex(name, "/")+1:])
+		if err != nil {
+			slog.Warn("Failed to resolve project in metrics scope", "project", name, "scope", projectId, "error", err, "code", errorCode(err))
+			continue
+		}
+		if _, loaded := seen.LoadOrStore(id, true); !loaded {
+			projects <- id
 		}
 	}
 }
@@ -79,58 +151,184 @@ func getProjectId(alertPolicy *monitoringpb.AlertPolicy) string {
 	return s1[:strings.Index(s1, "/")]
 }
 
-func verifyProjectPermissions(ctx context.Context, projectsClient *resourcemanager.ProjectsClient, projectId string, projectsTested chan string, testPermissions bool) {
+// verifyProjectPermissions puts the project on the projectsTested channel if the caller has all permissions needed to process it.
+// Results are cached in checked, so that projects that are encountered multiple times are only tested once.
+func verifyProjectPermissions(ctx context.Context, projectsClient *resourcemanager.ProjectsClient, projectId string, projectsTested chan string, testPermissions bool, checked *sync.Map, skipped *atomic.Int64) {
 	if testPermissions {
+		if ok, found := checked.Load(projectId); found {
+			if ok.(bool) {
+				projectsTested <- projectId
+			}
+			return
+		}
 		permissions := []string{"monitoring.timeSeries.list", "monitoring.alertPolicies.get", "monitoring.alertPolicies.list"}
 		resp, err := projectsClient.TestIamPermissions(ctx, &iampb.TestIamPermissionsRequest{
 			Resource:    "projects/" + projectId,
 			Permissions: permissions,
 		})
 		if err != nil {
-			log.Printf("Failed to test IAM permissions on project %s: %v\n", projectId, err)
+			slog.Error("Failed to test IAM permissions", "project", projectId, "error", err, "code", errorCode(err))
+			skipped.Add(1)
 			return
 		}
 		for i := range permissions {
 			if !slices.Contains(resp.GetPermissions(), permissions[i]) {
-				log.Printf("No permission %s on %s. Skipping\n", permissions[i], projectId)
+				slog.Warn("Missing permission, skipping project", "project", projectId, "permission", permissions[i])
+				skipped.Add(1)
+				checked.Store(projectId, false)
 				return
 			}
 		}
+		checked.Store(projectId, true)
 	}
 	projectsTested <- projectId
 }
 
-func listAlertPolicies(ctx context.Context, projectId string, includeDisabled bool, alertingPolicyClient *monitoring.AlertPolicyClient, policiesIn chan *monitoringpb.AlertPolicy) {
+// isServiceDisabled reports whether err was returned because the Monitoring API is not enabled in the target project.
+func isServiceDisabled(err error) bool {
+	apiErr, ok := apierror.FromError(err)
+	return ok && apiErr.Reason() == "SERVICE_DISABLED"
+}
+
+// listAlertPolicies puts all policies of a project that match the filter on the policies channel and returns their number.
+// An error is only returned if the policies could not be listed completely.
+func listAlertPolicies(ctx context.Context, projectId string, filter *policyFilter, alertingPolicyClient *monitoring.AlertPolicyClient, policiesIn chan *monitoringpb.AlertPolicy, disabledProjects *atomic.Int64, policySampler *sampler) (int, error) {
+	ctx, span := tracer.Start(ctx, "listAlertPolicies", trace.WithAttributes(attribute.String("project", projectId)))
+	defer span.End()
 	alertPoliciesIt := alertingPolicyClient.ListAlertPolicies(ctx, &monitoringpb.ListAlertPoliciesRequest{
 		Name: "projects/" + projectId,
 	})
+	var matched []*monitoringpb.AlertPolicy
+	n := 0
 	for {
 		alertPolicy, err := alertPoliciesIt.Next()
 		if err == iterator.Done {
 			break
 		}
-		if err != nil {
-			log.Printf("Failed to list policies in %s: %v\n", projectId, err)
+		if isServiceDisabled(err) {
+			disabledProjects.Add(1)
 			break
 		}
-		enabled := alertPolicy.GetEnabled()
-		if (enabled != nil && enabled.GetValue()) || includeDisabled {
+		if err != nil {
+			slog.Error("Failed to list policies", "project", projectId, "error", err, "code", errorCode(err))
+			span.RecordError(err)
+			return n, err
+		}
+		if !filter.matches(alertPolicy) {
+			continue
+		}
+		// When sampling, we need to know all policies of the project before we can pick some of them
+		if policySampler != nil {
+			matched = append(matched, alertPolicy)
+		} else {
 			policiesIn <- alertPolicy
+			n++
 		}
 	}
+	if policySampler != nil {
+		for _, alertPolicy := range policySampler.sample(projectId, matched) {
+			policiesIn <- alertPolicy
+			n++
+		}
+	}
+	return n, nil
+}
+
+// estimator holds the clients and settings needed to estimate the price of alerting policies
+type estimator struct {
+	queryClient          *monitoring.QueryClient
+	metricClient         *monitoring.MetricClient
+	monitoring_v1Service *monitoring_v1.Service
+	loggingService       *logging.Service
+	limiter              *adaptiveLimiter
+	cache                *countCache
+	countStrategy        string
+	timeSeriesRate       *rate.Limiter
+	conditionThreads     int
+	// noQuery estimates the time series of threshold and absence conditions from descriptors with the given label cardinalities instead of querying them
+	noQuery       bool
+	cardinalities map[string]int
+	// ingestion estimates the price of ingesting the metrics that PromQL conditions select and the logs that log-based metrics count
+	ingestion bool
+	// suggest counts the time series of threshold conditions without cross-series reducers with an aggregation that keeps suggestGroupBy
+	suggest        bool
+	suggestGroupBy []string
+	// simulatedIntervals are the hypothetical evaluation intervals per kind of condition to calculate the simulated price with
+	simulatedIntervals map[string]time.Duration
+	// highCardinality is the number of time series above which a condition is reported as high cardinality, 0 disables it
+	highCardinality int
+	// hygiene checks the policies for hygiene findings, disabledFor is how long a policy may be disabled before it is one
+	hygiene     bool
+	disabledFor time.Duration
+	// errors collects the errors of failed conditions
+	errors *errorSummary
+	// explain prints how the price of each policy was calculated
+	explain bool
+	// start and end are the window used to count the time series of threshold, absence and PromQL conditions
+	start *timestamppb.Timestamp
+	end   *timestamppb.Timestamp
+	// fixedWindow is set if the window was given with --end, so that counts cached for other windows of the same length aren't used
+	fixedWindow bool
+	// assumeSeries is the number of time series that failed conditions are priced with, 0 disables it
+	assumeSeries int
+	// promqlStep overrides the evaluation interval as the step of the range queries of PromQL conditions, if set
+	promqlStep time.Duration
+	// slidingWindow moves the window to end when a policy is processed, so that a watch that runs for days doesn't count a stale window
+	slidingWindow bool
+}
+
+// window describes the window that time series are counted in by its length, or by its start and end if it was fixed with --end
+func (e *estimator) window() string {
+	if e.fixedWindow {
+		return e.start.AsTime().Format(time.RFC3339) + "/" + e.end.AsTime().Format(time.RFC3339)
+	}
+	return e.end.AsTime().Sub(e.start.AsTime()).String()
 }
 
-func processAlertPolicy(
-	ctx context.Context,
-	queryClient *monitoring.QueryClient,
-	metricClient *monitoring.MetricClient,
-	monitoring_v1Service *monitoring_v1.Service,
-	alertPolicy *monitoringpb.AlertPolicy,
-	start *timestamppb.Timestamp,
-	end *timestamppb.Timestamp,
-	policiesOut chan *policy) {
+// settings returns a hash of all settings that change the estimate of a policy, so that stored results are only reused with the same settings.
+// As the window usually ends at the time of the run, only its length is included unless it was fixed with --end. Results based on
+// older prices are never reused.
+func (e *estimator) settings() string {
+	parts := []string{e.window(), e.countStrategy, pricingVersion, fmt.Sprint(e.highCardinality)}
+	// Failed conditions are priced differently with an assumed number of time series
+	if e.assumeSeries > 0 {
+		parts = append(parts, "assumeSeries", strconv.Itoa(e.assumeSeries))
+	}
+	// A larger step may miss time series that only existed between two steps
+	if e.promqlStep > 0 {
+		parts = append(parts, "promqlStep", e.promqlStep.String())
+	}
+	if e.noQuery {
+		// Maps are printed sorted by key
+		parts = append(parts, "noQuery", fmt.Sprint(e.cardinalities))
+	}
+	// Results without an ingestion price can't be reused once it is estimated
+	if e.ingestion {
+		parts = append(parts, "ingestion")
+	}
+	if e.suggest {
+		parts = append(parts, "suggest", strings.Join(e.suggestGroupBy, ","))
+	}
+	if len(e.simulatedIntervals) > 0 {
+		parts = append(parts, "simulate", fmt.Sprint(e.simulatedIntervals))
+	}
+	if e.hygiene {
+		parts = append(parts, "hygiene", e.disabledFor.String())
+	}
+	return cacheKey(parts...)
+}
+
+// processAlertPolicy estimates the price of a policy. Up to conditionThreads of its conditions are processed in parallel.
+func (e *estimator) processAlertPolicy(ctx context.Context, alertPolicy *monitoringpb.AlertPolicy) *policy {
+	if e.slidingWindow {
+		moved := *e
+		moved.end = timestamppb.Now()
+		moved.start = timestamppb.New(moved.end.AsTime().Add(-e.end.AsTime().Sub(e.start.AsTime())))
+		e = &moved
+	}
 	projectId := getProjectId(alertPolicy)
-	name := "projects/" + projectId
+	ctx, span := tracer.Start(ctx, "processAlertPolicy", trace.WithAttributes(attribute.String("policy", alertPolicy.GetName())))
+	defer span.End()
 	conditions := alertPolicy.GetConditions()
 	policyOut := &policy{
 		ProjectId:   projectId,
@@ -138,102 +336,317 @@ func processAlertPolicy(
 		DisplayName: alertPolicy.GetDisplayName(),
 		Conditions:  len(conditions),
 		Price:       1.5 * float64(len(conditions)),
+		UserLabels:  alertPolicy.GetUserLabels(),
+		Metrics:     conditionMetrics(conditions),
+		Queries:     conditionQueries(conditions),
+	}
+	if len(e.simulatedIntervals) > 0 {
+		policyOut.SimulatedPrice = policyOut.Price
+	}
+	if e.hygiene {
+		policyOut.Hygiene = policyHygiene(alertPolicy, e.end.AsTime(), e.disabledFor)
 	}
+	// Each condition writes its result to its own index, so that they are combined in the order of the conditions
+	// and the same policy always results in the same price and error, regardless of which condition finished first
+	results := make([]conditionResult, len(conditions))
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, max(e.conditionThreads, 1))
+	explanations := make([]string, len(conditions))
 	for i := range conditions {
-		mql := conditions[i].GetConditionMonitoringQueryLanguage()
-		pql := conditions[i].GetConditionPrometheusQueryLanguage()
-		threshold := conditions[i].GetConditionThreshold()
-		absent := conditions[i].GetConditionAbsent()
-		if mql != nil {
-			tsIt := queryClient.QueryTimeSeries(ctx, &monitoringpb.QueryTimeSeriesRequest{
-				Name:  name,
-				Query: mql.GetQuery(),
-			})
-			for {
-				_, err := tsIt.Next()
-				if err == iterator.Done {
-					break
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			started := time.Now()
+			conditionCtx, span := tracer.Start(ctx, "processCondition", trace.WithAttributes(attribute.String("condition", conditions[i].GetName())))
+			price, timeSeries, err := e.processCondition(conditionCtx, "projects/"+projectId, conditions[i])
+			span.SetAttributes(attribute.Int("timeSeries", timeSeries))
+			endSpan(span, err)
+			<-slots
+			// Instead of only their base price, failed conditions can be priced with an assumed number of time series, so that they don't skew the totals downward
+			assumed := false
+			if err != nil && ctx.Err() == nil && e.assumeSeries > timeSeries {
+				if kind, period := conditionKind(conditions[i]); kind != "other" && period > 0 {
+					price, timeSeries, assumed = 0.9072/float64(period)*float64(e.assumeSeries), e.assumeSeries, true
 				}
-				if err != nil {
-					policyOut.Error = err.Error()
-					break
+			}
+			slog.Log(ctx, levelTrace, "Processed condition", "project", projectId, "policy", policyOut.Name, "condition", conditions[i].GetName(), "timeSeries", timeSeries, "price", price, "duration", time.Since(started))
+			// The suggested aggregation is queried without blocking the other conditions, its result is combined with the others below
+			var suggested int
+			if e.suggest && err == nil {
+				var suggestErr error
+				suggested, suggestErr = e.suggestedTimeSeries(conditionCtx, "projects/"+projectId, conditions[i], timeSeries)
+				if suggestErr != nil {
+					slog.Log(ctx, levelTrace, "Failed to count time series with the suggested aggregation", "project", projectId, "policy", policyOut.Name, "condition", conditions[i].GetName(), "error", suggestErr, "code", errorCode(suggestErr))
 				}
-				// 60 (seconds) * 60 (minutes) * 24 (hours) * 30 (days) / 30 (execution period) * 0.35 (price) / 1000000 (per 1M) = 0.03024 (price per time series)
-				policyOut.Price += 0.03024
-				policyOut.TimeSeries++
 			}
-		}
-		if pql != nil {
-			seconds := pql.GetEvaluationInterval().GetSeconds()
-			resp, err := monitoring_v1Service.Projects.Location.Prometheus.Api.V1.QueryRange(name, "global", &monitoring_v1.QueryRangeRequest{
-				Query: pql.GetQuery(),
-				Start: start.AsTime().Format(time.RFC3339),
-				End:   end.AsTime().Format(time.RFC3339),
-				Step:  fmt.Sprintf("%ds", seconds),
-			}).Do()
-			if err != nil {
-				policyOut.Error = err.Error()
-				continue
+			if e.explain {
+				explanations[i] = explainCondition(conditions[i], timeSeries, price, err)
+				if assumed {
+					explanations[i] += " (time series assumed)"
+				}
 			}
-			j, err := resp.MarshalJSON()
 			if err != nil {
-				policyOut.Error = err.Error()
-				continue
+				slog.Log(ctx, levelTrace, "Failed to estimate condition", "project", projectId, "policy", policyOut.Name, "condition", conditions[i].GetName(), "error", err, "code", errorCode(err))
+				e.errors.conditionFailed(err)
 			}
-			pqlResp := &pqlResponse{}
-			err = json.Unmarshal(j, pqlResp)
-			if err != nil {
+			results[i] = conditionResult{price: price, timeSeries: timeSeries, suggested: suggested, assumed: assumed, err: err}
+		}()
+	}
+	wg.Wait()
+	for i, result := range results {
+		policyOut.Price += result.price
+		policyOut.TimeSeries += result.timeSeries
+		if result.assumed {
+			policyOut.AssumedSeries = append(policyOut.AssumedSeries, fmt.Sprintf("%s (%d time series assumed)", conditions[i].GetDisplayName(), result.timeSeries))
+		}
+		if e.highCardinality > 0 && result.timeSeries > e.highCardinality && !result.assumed {
+			policyOut.HighCardinality = append(policyOut.HighCardinality, fmt.Sprintf("%s (%d time series)", conditions[i].GetDisplayName(), result.timeSeries))
+		}
+		if len(e.simulatedIntervals) > 0 {
+			policyOut.SimulatedPrice += simulatedPrice(conditions[i], result.timeSeries, result.price, e.simulatedIntervals)
+		}
+		if e.suggest && result.err == nil {
+			policyOut.SuggestedTimeSeries += result.suggested
+			policyOut.PotentialSaving += 0.03024 * float64(result.timeSeries-result.suggested)
+		}
+		if result.err != nil {
+			policyOut.Error = result.err.Error()
+		}
+	}
+	if e.ingestion {
+		prometheusPrice, err := e.prometheusIngestion(ctx, "projects/"+projectId, conditions)
+		logPrice, logErr := e.logIngestion(ctx, "projects/"+projectId, conditions)
+		policyOut.IngestionPrice = prometheusPrice + logPrice
+		err = errors.Join(err, logErr)
+		if err != nil {
+			slog.Log(ctx, levelTrace, "Failed to estimate ingestion", "project", projectId, "policy", policyOut.Name, "error", err, "code", errorCode(err))
+			if policyOut.Error == "" {
 				policyOut.Error = err.Error()
-				continue
-			}
-			// 60 (seconds) * 60 (minutes) * 24 (hours) * 30 (days) = 2592000
-			// 2592000 * 0.35 (price) / 1000000 (per 1M) =
-			// 0.9072 / execution period * time series = price for all time series with this condition
-			policyOut.Price += 0.9072 / float64(seconds) * float64(len(pqlResp.Data.Result))
-			policyOut.TimeSeries += len(pqlResp.Data.Result)
-		}
-		if threshold != nil || absent != nil {
-			tsReq := &monitoringpb.ListTimeSeriesRequest{
-				Name: name,
-				View: monitoringpb.ListTimeSeriesRequest_HEADERS,
-				Interval: &monitoringpb.TimeInterval{
-					EndTime:   end,
-					StartTime: start,
-				},
-			}
-			aggregations := []*monitoringpb.Aggregation{}
-			if threshold != nil {
-				tsReq.Filter = threshold.GetFilter()
-				aggregations = threshold.GetAggregations()
-			}
-			if absent != nil {
-				tsReq.Filter = absent.GetFilter()
-				aggregations = absent.GetAggregations()
 			}
-			if len(aggregations) > 0 {
-				tsReq.Aggregation = aggregations[0]
-			}
-			if len(aggregations) > 1 {
-				tsReq.SecondaryAggregation = aggregations[1]
-			}
-			if tsReq.Aggregation == nil || tsReq.Aggregation.GetCrossSeriesReducer().String() == "REDUCE_COUNT_FALSE" || tsReq.SecondaryAggregation.GetCrossSeriesReducer().String() == "REDUCE_COUNT_FALSE" {
-				tsReq.View = monitoringpb.ListTimeSeriesRequest_FULL
-			}
-			tsIt := metricClient.ListTimeSeries(ctx, tsReq)
-			for {
-				_, err := tsIt.Next()
-				if err == iterator.Done {
-					break
-				}
+		}
+	}
+	if e.explain {
+		printExplanation(policyOut, explanations)
+	}
+	return policyOut
+}
+
+// conditionResult is the estimate of a single condition of a policy
+type conditionResult struct {
+	price      float64
+	timeSeries int
+	// suggested is the number of time series with the suggested aggregation, if it was counted
+	suggested int
+	// assumed is set if the condition failed and was priced with --assumeSeries
+	assumed bool
+	err     error
+}
+
+// processCondition executes the query of a condition and returns the price and number of its time series, excluding the condition's base price.
+// If an error occurs, the time series counted until then are returned along with it.
+func (e *estimator) processCondition(ctx context.Context, name string, condition *monitoringpb.AlertPolicy_Condition) (float64, int, error) {
+	window := e.window()
+	mql := condition.GetConditionMonitoringQueryLanguage()
+	pql := condition.GetConditionPrometheusQueryLanguage()
+	threshold := condition.GetConditionThreshold()
+	absent := condition.GetConditionAbsent()
+	if e.noQuery && (mql != nil || pql != nil) {
+		return 0, 0, fmt.Errorf("MQL and PromQL conditions can't be estimated with --noQuery")
+	}
+	if mql != nil {
+		count, err := e.cache.count(cacheKey(name, "mql", mql.GetQuery(), window), func() (count int, err error) {
+			err = e.limiter.run(ctx, func() (err error) {
+				count, err = countMQLTimeSeries(ctx, e.queryClient, name, mql.GetQuery())
+				return err
+			})
+			return count, err
+		})
+		// 60 (seconds) * 60 (minutes) * 24 (hours) * 30 (days) / 30 (execution period) * 0.35 (price) / 1000000 (per 1M) = 0.03024 (price per time series)
+		return 0.03024 * float64(count), count, err
+	}
+	if pql != nil {
+		seconds := pql.GetEvaluationInterval().GetSeconds()
+		// The step only affects how many points are returned for each time series, while the price depends on the evaluation interval
+		step := time.Duration(seconds) * time.Second
+		if e.promqlStep > 0 {
+			step = e.promqlStep
+		}
+		count, err := e.cache.count(cacheKey(name, "pql", pql.GetQuery(), strconv.FormatInt(seconds, 10), step.String(), window), func() (count int, err error) {
+			err = e.limiter.run(ctx, func() (err error) {
+				// The PromQL API is a REST API, so we need to wait for the rate limiter ourselves
+				err = e.timeSeriesRate.Wait(ctx)
 				if err != nil {
-					policyOut.Error = err.Error()
-					break
+					return err
 				}
-				// 60 (seconds) * 60 (minutes) * 24 (hours) * 30 (days) / 30 (execution period) * 0.35 (price) / 1000000 (per 1M) = 0.03024 (price per time series)
-				policyOut.Price += 0.03024
-				policyOut.TimeSeries++
-			}
+				count, err = countPromQLTimeSeries(ctx, e.monitoring_v1Service, name, pql.GetQuery(), step, e.start, e.end)
+				return err
+			})
+			return count, err
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+		// 60 (seconds) * 60 (minutes) * 24 (hours) * 30 (days) = 2592000
+		// 2592000 * 0.35 (price) / 1000000 (per 1M) =
+		// 0.9072 / execution period * time series = price for all time series with this condition
+		return 0.9072 / float64(seconds) * float64(count), count, nil
+	}
+	if threshold != nil || absent != nil {
+		tsReq := &monitoringpb.ListTimeSeriesRequest{
+			Name: name,
+			View: monitoringpb.ListTimeSeriesRequest_HEADERS,
+			Interval: &monitoringpb.TimeInterval{
+				EndTime:   e.end,
+				StartTime: e.start,
+			},
+		}
+		aggregations := []*monitoringpb.Aggregation{}
+		if threshold != nil {
+			tsReq.Filter = threshold.GetFilter()
+			aggregations = threshold.GetAggregations()
+		}
+		if absent != nil {
+			tsReq.Filter = absent.GetFilter()
+			aggregations = absent.GetAggregations()
+		}
+		if e.noQuery {
+			count, err := e.estimateTimeSeries(ctx, name, tsReq.Filter, aggregations)
+			return 0.03024 * float64(count), count, err
+		}
+		if len(aggregations) > 0 {
+			tsReq.Aggregation = aggregations[0]
+		}
+		if len(aggregations) > 1 {
+			tsReq.SecondaryAggregation = aggregations[1]
+		}
+		if tsReq.Aggregation == nil || tsReq.Aggregation.GetCrossSeriesReducer().String() == "REDUCE_COUNT_FALSE" || tsReq.SecondaryAggregation.GetCrossSeriesReducer().String() == "REDUCE_COUNT_FALSE" {
+			tsReq.View = monitoringpb.ListTimeSeriesRequest_FULL
+		}
+		count, err := e.cache.count(timeSeriesCacheKey(tsReq, e.countStrategy, window), func() (count int, err error) {
+			err = e.limiter.run(ctx, func() (err error) {
+				count, err = countTimeSeries(ctx, e.metricClient, tsReq, e.countStrategy)
+				return err
+			})
+			return count, err
+		})
+		// 60 (seconds) * 60 (minutes) * 24 (hours) * 30 (days) / 30 (execution period) * 0.35 (price) / 1000000 (per 1M) = 0.03024 (price per time series)
+		return 0.03024 * float64(count), count, err
+	}
+	return 0, 0, nil
+}
+
+// timeSeriesCacheKey returns the cache key of a ListTimeSeries request, which doesn't include the exact interval but only its length
+func timeSeriesCacheKey(tsReq *monitoringpb.ListTimeSeriesRequest, strategy string, window string) string {
+	req := proto.Clone(tsReq).(*monitoringpb.ListTimeSeriesRequest)
+	req.Interval = nil
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		// The request can always be marshalled, but if it can't, we make sure that it never hits the cache
+		return cacheKey(tsReq.GetName(), "ts", time.Now().String())
+	}
+	return cacheKey(tsReq.GetName(), "ts", strategy, string(b), window)
+}
+
+// countMQLTimeSeries executes an MQL query and returns the number of time series it returned.
+// If an error occurs, the number of time series counted until then is returned along with it.
+func countMQLTimeSeries(ctx context.Context, queryClient *monitoring.QueryClient, name string, query string) (int, error) {
+	tsIt := queryClient.QueryTimeSeries(ctx, &monitoringpb.QueryTimeSeriesRequest{
+		Name:  name,
+		Query: query,
+	})
+	count := 0
+	for {
+		_, err := tsIt.Next()
+		if err == iterator.Done {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+// countPromQLTimeSeries executes a PromQL range query with the given step and returns the number of time series it returned
+func countPromQLTimeSeries(ctx context.Context, monitoring_v1Service *monitoring_v1.Service, name string, query string, step time.Duration, start *timestamppb.Timestamp, end *timestamppb.Timestamp) (int, error) {
+	call := monitoring_v1Service.Projects.Location.Prometheus.Api.V1.QueryRange(name, "global", &monitoring_v1.QueryRangeRequest{
+		Query: query,
+		Start: start.AsTime().Format(time.RFC3339),
+		End:   end.AsTime().Format(time.RFC3339),
+		Step:  fmt.Sprintf("%ds", int64(step.Seconds())),
+	})
+	if quotaProject := contextQuotaProject(ctx); quotaProject != "" {
+		call.Header().Set(quotaProjectHeader, quotaProject)
+	}
+	resp, err := call.Context(ctx).Do()
+	if err != nil {
+		return 0, err
+	}
+	j, err := resp.MarshalJSON()
+	if err != nil {
+		return 0, err
+	}
+	pqlResp := &pqlResponse{}
+	err = json.Unmarshal(j, pqlResp)
+	if err != nil {
+		return 0, err
+	}
+	return len(pqlResp.Data.Result), nil
+}
+
+// maxPageSize is the largest page size supported by ListTimeSeries
+const maxPageSize = 100000
+
+// countTimeSeries returns the number of time series matching the request using the given strategy:
+//   - "list" reads the time series with the largest possible page size and counts the size of each page
+//   - "reduce" adds a REDUCE_COUNT secondary aggregation, so that the API only returns the number of time series at each point in time.
+//     The highest of these numbers is returned, which may be lower than the number of distinct time series if they change a lot over time.
+//     This is only possible if the condition aggregates its time series and doesn't use a secondary aggregation itself.
+//     Otherwise, the "list" strategy is used.
+//
+// If an error occurs, the number of time series counted until then is returned along with it.
+func countTimeSeries(ctx context.Context, metricClient *monitoring.MetricClient, tsReq *monitoringpb.ListTimeSeriesRequest, strategy string) (int, error) {
+	if strategy == "reduce" && tsReq.GetAggregation().GetAlignmentPeriod() != nil && tsReq.GetSecondaryAggregation() == nil {
+		return countTimeSeriesByReducer(ctx, metricClient, tsReq)
+	}
+	pager := iterator.NewPager(metricClient.ListTimeSeries(ctx, tsReq), maxPageSize, "")
+	count := 0
+	for {
+		var page []*monitoringpb.TimeSeries
+		nextPageToken, err := pager.NextPage(&page)
+		count += len(page)
+		if err != nil {
+			return count, err
+		}
+		if nextPageToken == "" {
+			return count, nil
+		}
+	}
+}
+
+// countTimeSeriesByReducer counts the time series matching the request by letting the API reduce them to a single time series,
+// which has the number of time series at each point in time as its values. It returns the highest of these values.
+func countTimeSeriesByReducer(ctx context.Context, metricClient *monitoring.MetricClient, tsReq *monitoringpb.ListTimeSeriesRequest) (int, error) {
+	req := proto.Clone(tsReq).(*monitoringpb.ListTimeSeriesRequest)
+	req.View = monitoringpb.ListTimeSeriesRequest_FULL
+	req.SecondaryAggregation = &monitoringpb.Aggregation{
+		AlignmentPeriod:    req.GetAggregation().GetAlignmentPeriod(),
+		PerSeriesAligner:   monitoringpb.Aggregation_ALIGN_NEXT_OLDER,
+		CrossSeriesReducer: monitoringpb.Aggregation_REDUCE_COUNT,
+	}
+	tsIt := metricClient.ListTimeSeries(ctx, req)
+	count := int64(0)
+	for {
+		ts, err := tsIt.Next()
+		if err == iterator.Done {
+			return int(count), nil
+		}
+		if err != nil {
+			return int(count), err
+		}
+		for _, point := range ts.GetPoints() {
+			count = max(count, point.GetValue().GetInt64Value())
 		}
 	}
-	policiesOut <- policyOut
 }
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// topPolicies keeps the most expensive of all policies it is given, without holding on to all of them
+type topPolicies struct {
+	n        int
+	policies []*policy
+}
+
+// add keeps p if it is among the n most expensive policies seen so far
+func (t *topPolicies) add(p *policy) {
+	t.policies = append(t.policies, p)
+	if len(t.policies) > 2*t.n {
+		t.policies = t.sorted()
+	}
+}
+
+// sorted returns the n most expensive policies, the most expensive first
+func (t *topPolicies) sorted() []*policy {
+	slices.SortFunc(t.policies, func(a, b *policy) int {
+		if a.Price > b.Price {
+			return -1
+		}
+		if a.Price < b.Price {
+			return 1
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	return t.policies[:min(len(t.policies), t.n)]
+}
+
+// webhookClient returns the HTTP client to call webhooks with, which uses the proxy like the clients of the APIs
+func webhookClient(p *proxy) *http.Client {
+	return &http.Client{Transport: p.transport(), Timeout: 30 * time.Second}
+}
+
+// policyResult is the estimate of a policy as it is sent to other systems in JSON
+type policyResult struct {
+	RunTime     time.Time `json:"runTime"`
+	ProjectId   string    `json:"projectId"`
+	ProjectName string    `json:"projectName,omitempty"`
+	FolderPath  string    `json:"folderPath,omitempty"`
+	// Organization is only set if multiple organizations were scanned
+	Organization string            `json:"organization,omitempty"`
+	Name         string            `json:"name"`
+	DisplayName  string            `json:"displayName"`
+	Conditions   int               `json:"conditions"`
+	TimeSeries   int               `json:"timeSeries"`
+	Price        float64           `json:"price"`
+	Error        string            `json:"error,omitempty"`
+	UserLabels   map[string]string `json:"userLabels,omitempty"`
+	// IngestionPrice is only set if it was estimated
+	IngestionPrice float64 `json:"ingestionPrice,omitempty"`
+	// PotentialSaving is only set if aggregations were suggested
+	PotentialSaving float64 `json:"potentialSaving,omitempty"`
+	// SimulatedPrice is only set if evaluation intervals were simulated
+	SimulatedPrice float64 `json:"simulatedPrice,omitempty"`
+	// HighCardinality is only set if conditions match more time series than --highCardinality
+	HighCardinality []string `json:"highCardinality,omitempty"`
+	// Hygiene is only set if the hygiene of the policy was checked and there were findings
+	Hygiene []string `json:"hygiene,omitempty"`
+	// AssumedSeries is only set if failed conditions were priced with --assumeSeries
+	AssumedSeries []string `json:"assumedSeries,omitempty"`
+	// Tags are only set if the run was tagged with --tag
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+func newPolicyResult(p *policy, runTime time.Time) policyResult {
+	return policyResult{
+		RunTime:         runTime,
+		ProjectId:       p.ProjectId,
+		ProjectName:     p.ProjectName,
+		FolderPath:      p.FolderPath,
+		Organization:    p.Organization,
+		Name:            p.Name,
+		DisplayName:     p.DisplayName,
+		Conditions:      p.Conditions,
+		TimeSeries:      p.TimeSeries,
+		Price:           p.Price,
+		Error:           p.Error,
+		UserLabels:      p.UserLabels,
+		IngestionPrice:  p.IngestionPrice,
+		PotentialSaving: p.PotentialSaving,
+		SimulatedPrice:  p.SimulatedPrice,
+		HighCardinality: p.HighCardinality,
+		Hygiene:         p.Hygiene,
+		AssumedSeries:   p.AssumedSeries,
+		Tags:            p.Tags,
+	}
+}
+
+// postJSON posts a JSON body with additional headers to a webhook with client and fails if it doesn't respond with a 2xx status
+func postJSON(client *http.Client, url string, body []byte, header http.Header) error {
+	request, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", response.Status)
+	}
+	return nil
+}
+
+// formatDelta formats the difference of a price to the baseline, e.g. "+$1.50"
+func formatDelta(delta float64) string {
+	if delta < 0 {
+		return fmt.Sprintf("-$%.2f", -delta)
+	}
+	return fmt.Sprintf("+$%.2f", delta)
+}
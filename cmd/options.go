@@ -0,0 +1,767 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// runOptions are the flags of a run, parsed and validated before anything is scanned
+type runOptions struct {
+	projects                []string
+	folders                 []string
+	organizations           []string
+	csvOut                  string
+	threads                 int64
+	projectThreads          int64
+	permissionThreads       int64
+	listThreads             int64
+	queryThreads            int64
+	conditionThreads        int64
+	recursive               bool
+	testPermissions         bool
+	includeDisabled         bool
+	policyFilterExpr        string
+	excludedPolicies        []string
+	excludePolicyFilterExpr string
+	policyLabels            []string
+	modifiedSince           string
+	samplePolicies          int
+	onlyDisabled            bool
+	maxProjects             int64
+	includeDeleteRequested  bool
+	summary                 bool
+	quotaProject            string
+	quotaPerProject         bool
+	credentials             string
+	// targetCredentials are the credentials files by the targets they are used for
+	targetCredentials map[string]string
+	fake              string
+	// fakeServer answers the requests of the run if --fake was given
+	fakeServer              *fakeServer
+	recordDir               string
+	replayDir               string
+	scopes                  []string
+	duration                time.Duration
+	windowStart             string
+	windowEnd               string
+	allOrganizations        bool
+	expandMetricsScopes     bool
+	discovery               string
+	noQuery                 bool
+	cardinalities           map[string]int
+	probeWindow             time.Duration
+	promqlStep              time.Duration
+	excludedFolders         []string
+	countStrategy           string
+	deadline                time.Duration
+	otlpEndpoint            string
+	otlpInsecure            bool
+	metricsAddr             string
+	metricsProject          string
+	pprofAddr               string
+	qpsPolicies             float64
+	qpsTimeSeries           float64
+	monitoringEndpoint      string
+	resourcemanagerEndpoint string
+	// apiProxy is the proxy of --proxy, or nil if none was given
+	apiProxy                *proxy
+	grpcPoolSize            int
+	grpcKeepalive           time.Duration
+	grpcKeepaliveTimeout    time.Duration
+	csvMetadata             bool
+	githubActions           bool
+	warnAbove               float64
+	billingAccount          string
+	lookerStudioOut         string
+	bigQueryTableName       string
+	slackWebhook            string
+	chatWebhook             string
+	baselineFile            string
+	runBaseline             *baseline
+	baselineOut             string
+	runMaxIncrease          *maxIncrease
+	checkCatalog            bool
+	explain                 bool
+	dryRun                  bool
+	strict                  bool
+	showProgress            bool
+	logFormat               string
+	verbosity               int
+	logFilePath             string
+	cacheDir                string
+	cacheTTL                time.Duration
+	stateFile               string
+	resume                  bool
+	resultsDb               string
+	cacheOnlyChanged        bool
+	policies                []string
+	projectsFile            string
+	terraformStates         []string
+	grafanaRuleFiles        []string
+	kccManifests            []string
+	datadogMonitors         []string
+	pulumiPreviews          []string
+	pubsubSubscription      string
+	pubsubTopic             string
+	firestoreCollectionPath string
+	webhook                 string
+	webhookSecret           string
+	webhookBatch            int
+	regoPolicies            []string
+	regoQuery               string
+	sarifOut                string
+	annotate                string
+	costMetricsProject      string
+	recommendationsOut      string
+	ingestion               bool
+	suggestAggregations     bool
+	suggestGroupBy          []string
+	hygiene                 bool
+	hygieneDisabledDays     int
+	projectNames            bool
+	includeFolderPaths      bool
+	snapshotBucket          string
+	tags                    map[string]string
+	chargebackKey           string
+	chargebackOut           string
+	highCardinality         int
+	// highCardinalityColumn adds the warnings to the CSV file, which is only done on request as they are enabled by default
+	highCardinalityColumn   bool
+	assumeSeries            int
+	simulatedIntervals      map[string]time.Duration
+	metricIngestionOut      string
+	unusedMetricsOut        string
+	unusedMetricsDashboards bool
+	overlapsOut             string
+	overlapsAcrossProjects  bool
+	targetProject           string
+}
+
+// parseRunOptions reads the flags of a run. Targets given in files or on stdin are read as well,
+// and the number of threads of each stage defaults to --threads.
+func parseRunOptions(flags *pflag.FlagSet) (*runOptions, error) {
+	o := &runOptions{}
+	var err error
+	o.projects, err = flags.GetStringSlice("project")
+	if err != nil {
+		return nil, err
+	}
+	o.folders, err = flags.GetStringSlice("folder")
+	if err != nil {
+		return nil, err
+	}
+	o.organizations, err = flags.GetStringSlice("organization")
+	if err != nil {
+		return nil, err
+	}
+	o.csvOut, err = flags.GetString("csvOut")
+	if err != nil {
+		return nil, err
+	}
+	o.threads, err = flags.GetInt64("threads")
+	if err != nil {
+		return nil, err
+	}
+	o.projectThreads, err = flags.GetInt64("projectThreads")
+	if err != nil {
+		return nil, err
+	}
+	o.permissionThreads, err = flags.GetInt64("permissionThreads")
+	if err != nil {
+		return nil, err
+	}
+	o.listThreads, err = flags.GetInt64("listThreads")
+	if err != nil {
+		return nil, err
+	}
+	o.queryThreads, err = flags.GetInt64("queryThreads")
+	if err != nil {
+		return nil, err
+	}
+	o.conditionThreads, err = flags.GetInt64("conditionThreads")
+	if err != nil {
+		return nil, err
+	}
+	o.recursive, err = flags.GetBool("recursive")
+	if err != nil {
+		return nil, err
+	}
+	o.testPermissions, err = flags.GetBool("testPermissions")
+	if err != nil {
+		return nil, err
+	}
+	o.includeDisabled, err = flags.GetBool("includeDisabled")
+	if err != nil {
+		return nil, err
+	}
+	o.policyFilterExpr, err = flags.GetString("policyFilter")
+	if err != nil {
+		return nil, err
+	}
+	o.excludedPolicies, err = flags.GetStringSlice("excludePolicy")
+	if err != nil {
+		return nil, err
+	}
+	o.excludePolicyFilterExpr, err = flags.GetString("excludePolicyFilter")
+	if err != nil {
+		return nil, err
+	}
+	o.policyLabels, err = flags.GetStringSlice("policyLabel")
+	if err != nil {
+		return nil, err
+	}
+	o.modifiedSince, err = flags.GetString("modifiedSince")
+	if err != nil {
+		return nil, err
+	}
+	o.samplePolicies, err = flags.GetInt("samplePolicies")
+	if err != nil {
+		return nil, err
+	}
+	o.onlyDisabled, err = flags.GetBool("onlyDisabled")
+	if err != nil {
+		return nil, err
+	}
+	o.maxProjects, err = flags.GetInt64("maxProjects")
+	if err != nil {
+		return nil, err
+	}
+	o.includeDeleteRequested, err = flags.GetBool("includeDeleteRequested")
+	if err != nil {
+		return nil, err
+	}
+	o.summary, err = flags.GetBool("summary")
+	if err != nil {
+		return nil, err
+	}
+	o.quotaProject, err = flags.GetString("quotaProject")
+	if err != nil {
+		return nil, err
+	}
+	o.quotaPerProject, err = flags.GetBool("quotaPerProject")
+	if err != nil {
+		return nil, err
+	}
+	o.credentials, err = flags.GetString("credentials")
+	if err != nil {
+		return nil, err
+	}
+	targetCredentialPairs, err := flags.GetStringSlice("targetCredentials")
+	if err != nil {
+		return nil, err
+	}
+	o.targetCredentials, err = parseTargetCredentials(targetCredentialPairs)
+	if err != nil {
+		return nil, err
+	}
+	o.fake, err = flags.GetString("fake")
+	if err != nil {
+		return nil, err
+	}
+	if o.fake != "" {
+		state, err := readFakeState(o.fake)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fake data: %v", err)
+		}
+		o.fakeServer, err = newFakeServer(state)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fake data: %v", err)
+		}
+	}
+	o.recordDir, err = flags.GetString("record")
+	if err != nil {
+		return nil, err
+	}
+	o.replayDir, err = flags.GetString("replay")
+	if err != nil {
+		return nil, err
+	}
+	// Credentials stored by "appe auth login" are used instead of the application default credentials
+	if o.credentials == "" && o.fake == "" && o.replayDir == "" {
+		if _, err := os.Stat(defaultCredentialsPath()); err == nil {
+			o.credentials = defaultCredentialsPath()
+		}
+	}
+	o.scopes, err = flags.GetStringSlice("scopes")
+	if err != nil {
+		return nil, err
+	}
+	// Unless the scopes were given, every input and output adds the scopes it needs
+	if !flags.Changed("scopes") {
+		o.scopes = append(o.scopes, requiredScopes(flags)...)
+	}
+	o.duration, err = flags.GetDuration("duration")
+	if err != nil {
+		return nil, err
+	}
+	o.windowStart, err = flags.GetString("start")
+	if err != nil {
+		return nil, err
+	}
+	o.windowEnd, err = flags.GetString("end")
+	if err != nil {
+		return nil, err
+	}
+	o.allOrganizations, err = flags.GetBool("allOrganizations")
+	if err != nil {
+		return nil, err
+	}
+	o.expandMetricsScopes, err = flags.GetBool("expandMetricsScopes")
+	if err != nil {
+		return nil, err
+	}
+	o.discovery, err = flags.GetString("discovery")
+	if err != nil {
+		return nil, err
+	}
+	if o.discovery != "api" && o.discovery != "asset" {
+		return nil, fmt.Errorf("invalid discovery method %q, must be either \"api\" or \"asset\"", o.discovery)
+	}
+	o.noQuery, err = flags.GetBool("noQuery")
+	if err != nil {
+		return nil, err
+	}
+	labelCardinality, err := flags.GetStringSlice("labelCardinality")
+	if err != nil {
+		return nil, err
+	}
+	o.cardinalities, err = parseCardinalities(labelCardinality)
+	if err != nil {
+		return nil, err
+	}
+	o.probeWindow, err = flags.GetDuration("probeWindow")
+	if err != nil {
+		return nil, err
+	}
+	o.promqlStep, err = flags.GetDuration("promqlStep")
+	if err != nil {
+		return nil, err
+	}
+	if o.promqlStep != 0 && o.promqlStep < time.Second {
+		return nil, fmt.Errorf("promqlStep must be at least 1s")
+	}
+	o.excludedFolders, err = flags.GetStringSlice("excludeFolder")
+	if err != nil {
+		return nil, err
+	}
+	o.countStrategy, err = flags.GetString("countStrategy")
+	if err != nil {
+		return nil, err
+	}
+	if o.countStrategy != "list" && o.countStrategy != "reduce" {
+		return nil, fmt.Errorf("invalid count strategy %q, must be either \"list\" or \"reduce\"", o.countStrategy)
+	}
+	o.deadline, err = flags.GetDuration("deadline")
+	if err != nil {
+		return nil, err
+	}
+	o.otlpEndpoint, err = flags.GetString("otlpEndpoint")
+	if err != nil {
+		return nil, err
+	}
+	o.otlpInsecure, err = flags.GetBool("otlpInsecure")
+	if err != nil {
+		return nil, err
+	}
+	o.metricsAddr, err = flags.GetString("metricsAddr")
+	if err != nil {
+		return nil, err
+	}
+	o.metricsProject, err = flags.GetString("metricsProject")
+	if err != nil {
+		return nil, err
+	}
+	o.pprofAddr, err = flags.GetString("pprof")
+	if err != nil {
+		return nil, err
+	}
+	o.qpsPolicies, err = flags.GetFloat64("qpsPolicies")
+	if err != nil {
+		return nil, err
+	}
+	o.qpsTimeSeries, err = flags.GetFloat64("qpsTimeSeries")
+	if err != nil {
+		return nil, err
+	}
+	o.monitoringEndpoint, err = flags.GetString("monitoringEndpoint")
+	if err != nil {
+		return nil, err
+	}
+	o.resourcemanagerEndpoint, err = flags.GetString("resourcemanagerEndpoint")
+	if err != nil {
+		return nil, err
+	}
+	proxyURL, err := flags.GetString("proxy")
+	if err != nil {
+		return nil, err
+	}
+	noProxy, err := flags.GetStringSlice("noProxy")
+	if err != nil {
+		return nil, err
+	}
+	// The proxy is passed to every client instead of setting it in the environment, so that it only applies to this run
+	if proxyURL != "" {
+		o.apiProxy, err = newProxy(proxyURL, noProxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %v", err)
+		}
+	}
+	o.grpcPoolSize, err = flags.GetInt("grpcPoolSize")
+	if err != nil {
+		return nil, err
+	}
+	o.grpcKeepalive, err = flags.GetDuration("grpcKeepalive")
+	if err != nil {
+		return nil, err
+	}
+	o.grpcKeepaliveTimeout, err = flags.GetDuration("grpcKeepaliveTimeout")
+	if err != nil {
+		return nil, err
+	}
+	o.csvMetadata, err = flags.GetBool("csvMetadata")
+	if err != nil {
+		return nil, err
+	}
+	o.githubActions, err = flags.GetBool("githubActions")
+	if err != nil {
+		return nil, err
+	}
+	o.warnAbove, err = flags.GetFloat64("warnAbove")
+	if err != nil {
+		return nil, err
+	}
+	o.billingAccount, err = flags.GetString("billingAccount")
+	if err != nil {
+		return nil, err
+	}
+	o.lookerStudioOut, err = flags.GetString("lookerStudioOut")
+	if err != nil {
+		return nil, err
+	}
+	o.bigQueryTableName, err = flags.GetString("bigQueryTable")
+	if err != nil {
+		return nil, err
+	}
+	o.slackWebhook, err = flags.GetString("slackWebhook")
+	if err != nil {
+		return nil, err
+	}
+	o.chatWebhook, err = flags.GetString("chatWebhook")
+	if err != nil {
+		return nil, err
+	}
+	o.baselineFile, err = flags.GetString("baseline")
+	if err != nil {
+		return nil, err
+	}
+	if o.baselineFile != "" {
+		o.runBaseline, err = readBaseline(o.baselineFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read baseline: %v", err)
+		}
+	}
+	maxIncreaseValue, err := flags.GetString("maxIncrease")
+	if err != nil {
+		return nil, err
+	}
+	if maxIncreaseValue != "" {
+		if o.runBaseline == nil {
+			return nil, fmt.Errorf("--maxIncrease requires a --baseline to compare to")
+		}
+		max, err := parseMaxIncrease(maxIncreaseValue)
+		if err != nil {
+			return nil, err
+		}
+		o.runMaxIncrease = &max
+	}
+	o.baselineOut, err = flags.GetString("baselineOut")
+	if err != nil {
+		return nil, err
+	}
+	o.checkCatalog, err = flags.GetBool("checkPricing")
+	if err != nil {
+		return nil, err
+	}
+	o.explain, err = flags.GetBool("explain")
+	if err != nil {
+		return nil, err
+	}
+	o.dryRun, err = flags.GetBool("dryRun")
+	if err != nil {
+		return nil, err
+	}
+	o.strict, err = flags.GetBool("strict")
+	if err != nil {
+		return nil, err
+	}
+	o.showProgress, err = flags.GetBool("progress")
+	if err != nil {
+		return nil, err
+	}
+	o.logFormat, err = flags.GetString("logFormat")
+	if err != nil {
+		return nil, err
+	}
+	o.verbosity, err = flags.GetCount("verbose")
+	if err != nil {
+		return nil, err
+	}
+	o.logFilePath, err = flags.GetString("logFile")
+	if err != nil {
+		return nil, err
+	}
+	o.cacheDir, err = flags.GetString("cacheDir")
+	if err != nil {
+		return nil, err
+	}
+	o.cacheTTL, err = flags.GetDuration("cacheTTL")
+	if err != nil {
+		return nil, err
+	}
+	o.stateFile, err = flags.GetString("stateFile")
+	if err != nil {
+		return nil, err
+	}
+	o.resume, err = flags.GetBool("resume")
+	if err != nil {
+		return nil, err
+	}
+	o.resultsDb, err = flags.GetString("resultsDb")
+	if err != nil {
+		return nil, err
+	}
+	o.cacheOnlyChanged, err = flags.GetBool("cacheOnlyChanged")
+	if err != nil {
+		return nil, err
+	}
+	if o.cacheOnlyChanged && o.resultsDb == "" {
+		return nil, fmt.Errorf("--cacheOnlyChanged requires --resultsDb")
+	}
+	o.policies, err = flags.GetStringSlice("policy")
+	if err != nil {
+		return nil, err
+	}
+	o.projectsFile, err = flags.GetString("projectsFile")
+	if err != nil {
+		return nil, err
+	}
+	o.terraformStates, err = flags.GetStringSlice("terraformState")
+	if err != nil {
+		return nil, err
+	}
+	o.grafanaRuleFiles, err = flags.GetStringSlice("grafanaRules")
+	if err != nil {
+		return nil, err
+	}
+	o.kccManifests, err = flags.GetStringSlice("kccManifests")
+	if err != nil {
+		return nil, err
+	}
+	o.datadogMonitors, err = flags.GetStringSlice("datadogMonitors")
+	if err != nil {
+		return nil, err
+	}
+	o.pulumiPreviews, err = flags.GetStringSlice("pulumiPreview")
+	if err != nil {
+		return nil, err
+	}
+	o.pubsubSubscription, err = flags.GetString("pubsubSubscription")
+	if err != nil {
+		return nil, err
+	}
+	o.pubsubTopic, err = flags.GetString("pubsubTopic")
+	if err != nil {
+		return nil, err
+	}
+	o.firestoreCollectionPath, err = flags.GetString("firestoreCollection")
+	if err != nil {
+		return nil, err
+	}
+	o.webhook, err = flags.GetString("webhook")
+	if err != nil {
+		return nil, err
+	}
+	o.webhookSecret, err = flags.GetString("webhookSecret")
+	if err != nil {
+		return nil, err
+	}
+	o.webhookBatch, err = flags.GetInt("webhookBatch")
+	if err != nil {
+		return nil, err
+	}
+	o.regoPolicies, err = flags.GetStringSlice("regoPolicy")
+	if err != nil {
+		return nil, err
+	}
+	o.regoQuery, err = flags.GetString("regoQuery")
+	if err != nil {
+		return nil, err
+	}
+	o.sarifOut, err = flags.GetString("sarifOut")
+	if err != nil {
+		return nil, err
+	}
+	o.annotate, err = flags.GetString("annotate")
+	if err != nil {
+		return nil, err
+	}
+	if o.annotate != "" && o.annotate != "preview" && o.annotate != "apply" {
+		return nil, fmt.Errorf("--annotate must be \"preview\" or \"apply\", not %q", o.annotate)
+	}
+	o.costMetricsProject, err = flags.GetString("costMetricsProject")
+	if err != nil {
+		return nil, err
+	}
+	o.recommendationsOut, err = flags.GetString("recommendationsOut")
+	if err != nil {
+		return nil, err
+	}
+	o.ingestion, err = flags.GetBool("ingestion")
+	if err != nil {
+		return nil, err
+	}
+	o.suggestAggregations, err = flags.GetBool("suggestAggregations")
+	if err != nil {
+		return nil, err
+	}
+	o.suggestGroupBy, err = flags.GetStringSlice("suggestGroupBy")
+	if err != nil {
+		return nil, err
+	}
+	o.hygiene, err = flags.GetBool("hygiene")
+	if err != nil {
+		return nil, err
+	}
+	o.hygieneDisabledDays, err = flags.GetInt("hygieneDisabledDays")
+	if err != nil {
+		return nil, err
+	}
+	o.projectNames, err = flags.GetBool("projectNames")
+	if err != nil {
+		return nil, err
+	}
+	o.includeFolderPaths, err = flags.GetBool("folderPaths")
+	if err != nil {
+		return nil, err
+	}
+	o.snapshotBucket, err = flags.GetString("snapshotBucket")
+	if err != nil {
+		return nil, err
+	}
+	tagPairs, err := flags.GetStringSlice("tag")
+	if err != nil {
+		return nil, err
+	}
+	if len(tagPairs) > 0 {
+		o.tags, err = parseLabels(tagPairs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --tag: %v", err)
+		}
+	}
+	o.chargebackKey, err = flags.GetString("chargebackKey")
+	if err != nil {
+		return nil, err
+	}
+	o.chargebackOut, err = flags.GetString("chargebackOut")
+	if err != nil {
+		return nil, err
+	}
+	if o.chargebackOut != "" && o.chargebackKey == "" {
+		return nil, fmt.Errorf("--chargebackOut requires --chargebackKey")
+	}
+	o.highCardinality, err = flags.GetInt("highCardinality")
+	if err != nil {
+		return nil, err
+	}
+	o.highCardinalityColumn = flags.Changed("highCardinality") && o.highCardinality > 0
+	o.assumeSeries, err = flags.GetInt("assumeSeries")
+	if err != nil {
+		return nil, err
+	}
+	if o.assumeSeries < 0 {
+		return nil, fmt.Errorf("assumeSeries must not be negative")
+	}
+	simulateInterval, err := flags.GetStringSlice("simulateInterval")
+	if err != nil {
+		return nil, err
+	}
+	o.simulatedIntervals, err = parseSimulatedIntervals(simulateInterval)
+	if err != nil {
+		return nil, err
+	}
+	o.metricIngestionOut, err = flags.GetString("metricIngestionOut")
+	if err != nil {
+		return nil, err
+	}
+	o.unusedMetricsOut, err = flags.GetString("unusedMetricsOut")
+	if err != nil {
+		return nil, err
+	}
+	o.unusedMetricsDashboards, err = flags.GetBool("unusedMetricsDashboards")
+	if err != nil {
+		return nil, err
+	}
+	o.overlapsOut, err = flags.GetString("overlapsOut")
+	if err != nil {
+		return nil, err
+	}
+	o.overlapsAcrossProjects, err = flags.GetBool("overlapsAcrossProjects")
+	if err != nil {
+		return nil, err
+	}
+	o.targetProject, err = flags.GetString("targetProject")
+	if err != nil {
+		return nil, err
+	}
+	if len(o.grafanaRuleFiles)+len(o.kccManifests)+len(o.datadogMonitors) > 0 && o.targetProject == "" {
+		return nil, fmt.Errorf("--grafanaRules, --kccManifests and --datadogMonitors require --targetProject")
+	}
+
+	// With --policy -, the policy names are read from stdin, e.g. from the output of gcloud
+	if slices.Contains(o.policies, "-") {
+		if len(o.policies) > 1 {
+			return nil, fmt.Errorf("--policy - can't be combined with other policy names")
+		}
+		var other []string
+		other, o.policies, err = readTargets("-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read policy names from stdin: %v", err)
+		}
+		if len(other) > 0 {
+			return nil, fmt.Errorf("%q is not a policy name in the format \"projects/PROJECT_ID/alertPolicies/POLICY_ID\"", other[0])
+		}
+		if len(o.policies) == 0 {
+			return nil, fmt.Errorf("no policy names were given on stdin")
+		}
+	}
+
+	// If a file with targets was given, we read the projects and policies from it
+	if o.projectsFile != "" {
+		o.projects, o.policies, err = readTargets(o.projectsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read targets from %s: %v", o.projectsFile, err)
+		}
+		if len(o.projects) > 0 && len(o.policies) > 0 {
+			return nil, fmt.Errorf("%s must either contain only project IDs or only policy names", o.projectsFile)
+		}
+	}
+
+	// Each stage of the pipeline can use its own number of threads, which default to the value of --threads
+	if o.projectThreads <= 0 {
+		o.projectThreads = o.threads
+	}
+	if o.permissionThreads <= 0 {
+		o.permissionThreads = o.projectThreads
+	}
+	if o.listThreads <= 0 {
+		o.listThreads = o.threads
+	}
+	if o.queryThreads <= 0 {
+		o.queryThreads = o.threads
+	}
+	return o, nil
+}
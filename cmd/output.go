@@ -0,0 +1,460 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// sink receives the result of each policy as soon as it has been processed. Sinks write or aggregate results as they
+// come in instead of collecting them, so that the memory used by a run doesn't grow with the number of policies.
+// close is told whether the run stopped early, so that partial results can be marked as such.
+type sink interface {
+	write(p *policy) error
+	close(partial bool) error
+}
+
+// csvColumn is an optional column of the CSV file, which is added after the default columns if the feature it belongs to is used
+type csvColumn struct {
+	name  string
+	value func(p *policy) string
+}
+
+// csvSink streams each result as a line to a CSV file
+type csvSink struct {
+	file    *os.File
+	writer  *csv.Writer
+	columns []csvColumn
+	// upload is set for a GCS object, which is written to a temporary file first and uploaded on close
+	upload func(*os.File) error
+}
+
+// newCSVSink creates the CSV file at path, or a temporary file if path is a GCS object given as gs://BUCKET/OBJECT.
+// If metadata is given, it is written as comment lines starting with "#" before the header.
+func newCSVSink(path string, metadata *runMetadata, storageService *storage.Service, columns []csvColumn) (*csvSink, error) {
+	f, upload, err := createOutput(path, storageService)
+	if err != nil {
+		return nil, err
+	}
+	if metadata != nil {
+		for _, field := range metadata.fields() {
+			_, err = fmt.Fprintf(f, "# %s: %s\n", field[0], field[1])
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+	}
+	s := &csvSink{
+		file:    f,
+		writer:  csv.NewWriter(f),
+		upload:  upload,
+		columns: columns,
+	}
+	header := []string{"ProjectId", "Policy Name", "Link", "DisplayName", "Conditions", "Time Series", "Price", "Error"}
+	for _, column := range columns {
+		header = append(header, column.name)
+	}
+	err = s.writer.Write(header)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.writer.Flush()
+	return s, s.writer.Error()
+}
+
+func (s *csvSink) write(p *policy) error {
+	record := []string{p.ProjectId, p.Name, policyLink(p), p.DisplayName, strconv.Itoa(p.Conditions), strconv.Itoa(p.TimeSeries), strconv.FormatFloat(p.Price, 'f', 2, 64), p.Error}
+	for _, column := range s.columns {
+		record = append(record, column.value(p))
+	}
+	err := s.writer.Write(record)
+	if err != nil {
+		return err
+	}
+	// We flush after every record, so that the file is always up to date while the scan is running
+	s.writer.Flush()
+	return s.writer.Error()
+}
+
+func (s *csvSink) close(partial bool) error {
+	// A partial file ends with a record that only has an error, so that it isn't mistaken for a complete scan
+	if partial {
+		record := make([]string, 8+len(s.columns))
+		record[7] = "Partial results: the run was stopped before all policies were processed"
+		err := s.writer.Write(record)
+		if err != nil {
+			s.file.Close()
+			return err
+		}
+		s.writer.Flush()
+		if err = s.writer.Error(); err != nil {
+			s.file.Close()
+			return err
+		}
+	}
+	return finishOutput(s.file, s.upload)
+}
+
+// createOutput creates the file at path. If path is a GCS object given as gs://BUCKET/OBJECT, a temporary file is created instead
+// along with a function that uploads it, which finishOutput calls.
+func createOutput(path string, storageService *storage.Service) (*os.File, func(*os.File) error, error) {
+	bucket, object, found := strings.Cut(strings.TrimPrefix(path, "gs://"), "/")
+	if !strings.HasPrefix(path, "gs://") {
+		f, err := os.Create(path)
+		return f, nil, err
+	}
+	if !found || object == "" {
+		return nil, nil, fmt.Errorf("%q must be in the format gs://BUCKET/OBJECT", path)
+	}
+	f, err := os.CreateTemp("", "appe-*.csv")
+	upload := func(f *os.File) error {
+		_, err := f.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		_, err = storageService.Objects.Insert(bucket, &storage.Object{Name: object, ContentType: "text/csv"}).Media(f).Do()
+		return err
+	}
+	return f, upload, err
+}
+
+// finishOutput uploads a file created by createOutput if needed and closes it
+func finishOutput(f *os.File, upload func(*os.File) error) error {
+	if upload != nil {
+		defer os.Remove(f.Name())
+		err := upload(f)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to upload results: %v", err)
+		}
+	}
+	return f.Close()
+}
+
+// policyLink returns the link to a policy in the Cloud Console
+func policyLink(p *policy) string {
+	return fmt.Sprintf("https://console.cloud.google.com/monitoring/alerting/policies/%s?project=%s", p.Name[strings.LastIndex(p.Name, "/")+1:], p.ProjectId)
+}
+
+// textSink logs a human-readable line for each result
+type textSink struct {
+	onlyDisabled bool
+}
+
+func (s *textSink) write(p *policy) error {
+	name := p.Name
+	if p.ProjectName != "" {
+		name += " in " + p.ProjectName
+	}
+	if s.onlyDisabled {
+		log.Printf("Disabled Alerting Policy %s (%s) has %d condition(s) and %d time series. Re-enabling it would cost approximately $%f\n", p.DisplayName, name, p.Conditions, p.TimeSeries, p.Price)
+	} else {
+		log.Printf("Alerting Policy %s (%s) has %d condition(s) and %d time series. It will cost approximately $%f\n", p.DisplayName, name, p.Conditions, p.TimeSeries, p.Price)
+	}
+	if p.IngestionPrice > 0 {
+		log.Printf("Ingesting the data that %s (%s) alerts on will cost approximately $%f\n", p.DisplayName, p.Name, p.IngestionPrice)
+	}
+	if p.PotentialSaving > 0 {
+		log.Printf("Aggregating the time series of %s (%s) to %d would save approximately $%f\n", p.DisplayName, p.Name, p.SuggestedTimeSeries, p.PotentialSaving)
+	}
+	for _, condition := range p.HighCardinality {
+		log.Printf("Warning: High cardinality condition %s in %s (%s), check its filter and aggregations\n", condition, p.DisplayName, p.Name)
+	}
+	for _, condition := range p.AssumedSeries {
+		log.Printf("Warning: Condition %s in %s (%s) failed and is priced with assumed time series\n", condition, p.DisplayName, p.Name)
+	}
+	if len(p.Hygiene) > 0 {
+		log.Printf("Hygiene of %s (%s), which costs approximately $%f: %s\n", p.DisplayName, p.Name, p.Price, strings.Join(p.Hygiene, ", "))
+	}
+	if p.SimulatedPrice > 0 {
+		log.Printf("At the simulated evaluation intervals, %s (%s) would cost approximately $%f (%+f)\n", p.DisplayName, p.Name, p.SimulatedPrice, p.SimulatedPrice-p.Price)
+	}
+	return nil
+}
+
+func (s *textSink) close(partial bool) error {
+	return nil
+}
+
+// summarySink only sums up the results and logs the totals once all results have been received
+type summarySink struct {
+	onlyDisabled bool
+	policies     int
+	conditions   int
+	timeSeries   int
+	price        float64
+	// ingestionPrice may count the same data multiple times, if several policies alert on it
+	ingestionPrice  float64
+	potentialSaving float64
+	simulatedPrice  float64
+	// highCardinality counts the conditions and policies with high cardinality
+	highCardinality         int
+	highCardinalityPolicies int
+	// assumed counts the conditions and policies that are priced with assumed time series
+	assumed         int
+	assumedPolicies int
+	// hygiene counts the policies with hygiene findings and their price
+	hygiene      int
+	hygienePrice float64
+	// organizations are the subtotals per organization, if multiple organizations were scanned
+	organizations map[string]*subtotal
+}
+
+func (s *summarySink) write(p *policy) error {
+	s.policies++
+	s.conditions += p.Conditions
+	s.timeSeries += p.TimeSeries
+	s.price += p.Price
+	if p.Organization != "" {
+		if s.organizations == nil {
+			s.organizations = map[string]*subtotal{}
+		}
+		if s.organizations[p.Organization] == nil {
+			s.organizations[p.Organization] = &subtotal{}
+		}
+		s.organizations[p.Organization].add(p)
+	}
+	s.ingestionPrice += p.IngestionPrice
+	s.potentialSaving += p.PotentialSaving
+	s.simulatedPrice += p.SimulatedPrice
+	if len(p.Hygiene) > 0 {
+		s.hygiene++
+		s.hygienePrice += p.Price
+	}
+	if len(p.HighCardinality) > 0 {
+		s.highCardinality += len(p.HighCardinality)
+		s.highCardinalityPolicies++
+	}
+	if len(p.AssumedSeries) > 0 {
+		s.assumed += len(p.AssumedSeries)
+		s.assumedPolicies++
+	}
+	return nil
+}
+
+func (s *summarySink) close(partial bool) error {
+	if partial {
+		log.Println("The following summary is partial, as the run was stopped before all policies were processed")
+	}
+	if s.onlyDisabled {
+		log.Printf("Summary: You have %d disabled policies with a combined total of %d conditions and %d time series. Re-enabling them would cost approximately $%f\n", s.policies, s.conditions, s.timeSeries, s.price)
+	} else {
+		log.Printf("Summary: You have %d policies with a combined total of %d conditions and %d time series. It will cost approximately $%f\n", s.policies, s.conditions, s.timeSeries, s.price)
+	}
+	for _, organization := range slices.Sorted(maps.Keys(s.organizations)) {
+		t := s.organizations[organization]
+		log.Printf("  Organization %s: %d policies with %d conditions and %d time series, approximately $%f\n", organization, t.policies, t.conditions, t.timeSeries, t.price)
+	}
+	if s.ingestionPrice > 0 {
+		log.Printf("Ingesting the data the policies alert on will cost approximately $%f, data that multiple policies alert on is counted for each of them\n", s.ingestionPrice)
+	}
+	if s.potentialSaving > 0 {
+		log.Printf("Aggregating the time series of threshold conditions as suggested would save approximately $%f\n", s.potentialSaving)
+	}
+	if s.highCardinality > 0 {
+		log.Printf("Warning: %d condition(s) of %d policies have a high cardinality, which usually means that their filter is misconfigured\n", s.highCardinality, s.highCardinalityPolicies)
+	}
+	if s.assumed > 0 {
+		log.Printf("Warning: %d failed condition(s) of %d policies are priced with assumed instead of counted time series\n", s.assumed, s.assumedPolicies)
+	}
+	if s.hygiene > 0 {
+		log.Printf("%d policies with hygiene findings will cost approximately $%f\n", s.hygiene, s.hygienePrice)
+	}
+	if s.simulatedPrice > 0 {
+		log.Printf("At the simulated evaluation intervals, the policies would cost approximately $%f (%+f)\n", s.simulatedPrice, s.simulatedPrice-s.price)
+	}
+	return nil
+}
+
+// multiSink passes every result on to all of its sinks, e.g. to annotate a CI job in addition to the regular output
+type multiSink []sink
+
+func (s multiSink) write(p *policy) error {
+	for _, out := range s {
+		err := out.write(p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s multiSink) close(partial bool) error {
+	var errs []error
+	for _, out := range s {
+		errs = append(errs, out.close(partial))
+	}
+	return errors.Join(errs...)
+}
+
+// newRunSink creates the sinks of a run for its flags, so that every result is passed on to all of them
+func newRunSink(ctx context.Context, o *runOptions, flags *pflag.FlagSet, r *runClients, metadata *runMetadata, paths *folderPaths, customMetrics *customMetricsReport, gate *regoGate, regressions *regressionGate, definitions policyDefinitions) (sink, error) {
+	var out sink
+	if o.csvOut != "" {
+		var csvHeader *runMetadata
+		if o.csvMetadata {
+			csvHeader = metadata
+		}
+		var columns []csvColumn
+		if o.projectNames {
+			columns = append(columns, csvColumn{"Project Name", func(p *policy) string { return p.ProjectName }})
+		}
+		if len(o.organizations) > 1 {
+			columns = append(columns, csvColumn{"Organization", func(p *policy) string { return p.Organization }})
+		}
+		if o.includeFolderPaths {
+			columns = append(columns, csvColumn{"Folder Path", func(p *policy) string { return p.FolderPath }})
+		}
+		if o.ingestion {
+			columns = append(columns, csvColumn{"Ingestion Price", func(p *policy) string { return strconv.FormatFloat(p.IngestionPrice, 'f', 2, 64) }})
+		}
+		if o.suggestAggregations {
+			columns = append(columns,
+				csvColumn{"Suggested Time Series", func(p *policy) string { return strconv.Itoa(p.SuggestedTimeSeries) }},
+				csvColumn{"Potential Saving", func(p *policy) string { return strconv.FormatFloat(p.PotentialSaving, 'f', 2, 64) }},
+			)
+		}
+		if o.hygiene {
+			columns = append(columns, csvColumn{"Hygiene", func(p *policy) string { return strings.Join(p.Hygiene, "; ") }})
+		}
+		if o.highCardinalityColumn {
+			columns = append(columns, csvColumn{"High Cardinality", func(p *policy) string { return strings.Join(p.HighCardinality, "; ") }})
+		}
+		if o.assumeSeries > 0 {
+			columns = append(columns, csvColumn{"Assumed Series", func(p *policy) string { return strings.Join(p.AssumedSeries, "; ") }})
+		}
+		if len(o.simulatedIntervals) > 0 {
+			columns = append(columns,
+				csvColumn{"Simulated Price", func(p *policy) string { return strconv.FormatFloat(p.SimulatedPrice, 'f', 2, 64) }},
+				csvColumn{"Simulated Delta", func(p *policy) string { return strconv.FormatFloat(p.SimulatedPrice-p.Price, 'f', 2, 64) }},
+			)
+		}
+		// Every tag gets a column, so that the results of differently tagged runs can be concatenated and filtered
+		for _, key := range slices.Sorted(maps.Keys(o.tags)) {
+			columns = append(columns, csvColumn{"Tag " + key, func(p *policy) string { return p.Tags[key] }})
+		}
+		var err error
+		out, err = newCSVSink(o.csvOut, csvHeader, r.router.defaults.storage, columns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create CSV file: %v", err)
+		}
+	} else if o.summary {
+		out = &summarySink{onlyDisabled: o.onlyDisabled}
+	} else {
+		out = &textSink{onlyDisabled: o.onlyDisabled}
+	}
+	// In GitHub Actions, the results are additionally shown as annotations and in the job summary
+	if o.githubActions {
+		out = multiSink{out, newGitHubSink(o.warnAbove)}
+	}
+	// Optimization findings are written in the format of the Recommender API, to be triaged along with its recommendations
+	if o.recommendationsOut != "" {
+		out = multiSink{out, newRecommendationsSink(o.recommendationsOut, r.router.defaults.storage, o.warnAbove, metadata.started)}
+	}
+	if customMetrics != nil {
+		out = multiSink{out, customMetrics}
+	}
+	// Policies that query the same time series are reported, as templated rollouts often create near-duplicates
+	if o.overlapsOut != "" {
+		out = multiSink{out, newOverlapSink(o.overlapsOut, r.router.defaults.storage, o.overlapsAcrossProjects)}
+	}
+	// Expensive policies and failed estimates are written as findings for code scanning tools
+	if o.sarifOut != "" {
+		out = multiSink{out, newSarifSink(o.sarifOut, r.router.defaults.storage, metadata.version, o.warnAbove)}
+	}
+	// The results are appended to a history table in BigQuery, so that prices can be compared across runs
+	if o.bigQueryTableName != "" {
+		table, err := parseBigQueryTable(o.bigQueryTableName)
+		if err != nil {
+			return nil, err
+		}
+		bigQueryOut, err := newBigQuerySink(ctx, r.router.defaults.bigquery, table, metadata.started)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set up BigQuery table %s: %v", table, err)
+		}
+		out = multiSink{out, bigQueryOut}
+	}
+	// A denormalized table for Looker Studio is written in addition to the regular output
+	if o.lookerStudioOut != "" {
+		lookerOut, err := newLookerStudioSink(ctx, o.lookerStudioOut, r.router.defaults.storage, paths, o.tags, metadata.started)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Looker Studio file: %v", err)
+		}
+		out = multiSink{out, lookerOut}
+	}
+	// The results are stored in GCS and compared to the latest earlier snapshot of the same targets
+	if o.snapshotBucket != "" {
+		snapshotOut, err := newSnapshotSink(ctx, r.router.defaults.storage, o.snapshotBucket, snapshotScope(flags), o.tags, metadata.started)
+		if err != nil {
+			return nil, err
+		}
+		out = multiSink{out, snapshotOut}
+	}
+	// The estimates are summed up by a label, e.g. to charge them back to the teams that own the policies
+	if o.chargebackKey != "" {
+		chargeback, err := newChargebackSink(ctx, paths, o.chargebackKey, o.chargebackOut, r.router.defaults.storage)
+		if err != nil {
+			return nil, err
+		}
+		out = multiSink{out, chargeback}
+	}
+	// The estimate of the projects in each budget for Cloud Monitoring is compared to its amount once the run completes
+	if o.billingAccount != "" {
+		out = multiSink{out, newBudgetSink(ctx, r.router.defaults.budgets, r.router, o.billingAccount)}
+	}
+	// A summary of the run is posted to Slack once it completes, e.g. for scheduled scans
+	if o.slackWebhook != "" {
+		out = multiSink{out, newSlackSink(o.slackWebhook, webhookClient(o.apiProxy), o.runBaseline)}
+	}
+	if o.chatWebhook != "" {
+		out = multiSink{out, newChatSink(o.chatWebhook, webhookClient(o.apiProxy), o.runBaseline)}
+	}
+	// The latest estimate of every policy is kept in a Firestore document, e.g. for internal dashboards
+	if o.firestoreCollectionPath != "" {
+		collection, err := parseFirestoreCollection(o.firestoreCollectionPath)
+		if err != nil {
+			return nil, err
+		}
+		out = multiSink{out, newFirestoreSink(ctx, r.router.defaults.firestore, collection, metadata.started)}
+	}
+	// The results are posted to any other system that accepts JSON
+	if o.webhook != "" {
+		out = multiSink{out, newWebhookSink(o.webhook, webhookClient(o.apiProxy), o.webhookSecret, o.webhookBatch, metadata.started)}
+	}
+	// The results are checked against Rego policies once the run is done
+	if gate != nil {
+		out = multiSink{out, gate}
+	}
+	if regressions != nil {
+		out = multiSink{out, regressions}
+	}
+	if o.baselineOut != "" {
+		out = multiSink{out, newBaselineSink(o.baselineOut, r.router.defaults.storage, snapshotScope(flags), o.tags, metadata.started)}
+	}
+	// The estimates are written back to the policies as labels, or only previewed
+	if o.annotate != "" {
+		out = multiSink{out, newAnnotateSink(ctx, r.router, o.annotate == "apply", metadata.started, definitions)}
+	}
+	// The price of every policy is written as a custom metric, e.g. to alert on the growth of the price of alerting
+	if o.costMetricsProject != "" {
+		out = multiSink{out, newCostMetricsSink(ctx, r.metrics, o.costMetricsProject)}
+	}
+	// Every estimate is published as soon as it is available, e.g. for the changes estimated in watch mode
+	if o.pubsubTopic != "" {
+		out = multiSink{out, newPubSubSink(ctx, r.router.defaults.pubsub, o.pubsubTopic, metadata.started)}
+	}
+	return out, nil
+}
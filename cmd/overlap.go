@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var (
+	// filterClauseSeparator separates the clauses of a filter that all have to match
+	filterClauseSeparator = regexp.MustCompile(`\s+AND\s+`)
+	// filterOperatorSpace matches whitespace around the comparison operators of a filter
+	filterOperatorSpace = regexp.MustCompile(`\s*(!=|=|:|<|>)\s*`)
+	// filterLabelsNotation matches the plural notation of labels in a filter, e.g. resource.labels.zone
+	filterLabelsNotation = regexp.MustCompile(`\b(metric|resource|metadata\.system_labels|metadata\.user_labels)\.labels\.`)
+	// whitespacePattern matches any run of whitespace
+	whitespacePattern = regexp.MustCompile(`\s+`)
+)
+
+// conditionQuery is the normalized query of a condition, which is compared to the queries of other policies to find overlaps.
+// The kind and aggregations have to be equal for two queries to overlap. Threshold and absence conditions have one clause per
+// comparison in their filter, MQL and PromQL conditions a single clause with their whole query.
+type conditionQuery struct {
+	kind         string
+	aggregations string
+	clauses      []string
+}
+
+// String encodes the query, so that it can be stored with the results of a policy
+func (q conditionQuery) String() string {
+	return q.kind + "|" + q.aggregations + "|" + strings.Join(q.clauses, "\n")
+}
+
+// parseConditionQuery decodes a query encoded by String
+func parseConditionQuery(s string) conditionQuery {
+	parts := strings.SplitN(s, "|", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	return conditionQuery{kind: parts[0], aggregations: parts[1], clauses: strings.Split(parts[2], "\n")}
+}
+
+// covers returns true if the query selects all time series that other selects, because it has the same kind and aggregations
+// and all of its clauses are clauses of other as well
+func (q conditionQuery) covers(other conditionQuery) bool {
+	if q.kind != other.kind || q.aggregations != other.aggregations {
+		return false
+	}
+	for _, clause := range q.clauses {
+		if !slices.Contains(other.clauses, clause) {
+			return false
+		}
+	}
+	return true
+}
+
+// group returns the key of the queries that q may overlap with. Filters can only overlap if they select the same metric type.
+func (q conditionQuery) group() string {
+	for _, clause := range q.clauses {
+		if strings.HasPrefix(clause, "metric.type=") {
+			return q.kind + "|" + q.aggregations + "|" + clause
+		}
+	}
+	return q.String()
+}
+
+// conditionQueries returns the normalized queries of the conditions of a policy.
+// Conditions on logs or with a filter that isn't a plain conjunction are only compared as a whole.
+func conditionQueries(conditions []*monitoringpb.AlertPolicy_Condition) []string {
+	var queries []string
+	for _, condition := range conditions {
+		var q conditionQuery
+		var aggregations []*monitoringpb.Aggregation
+		filter := ""
+		switch {
+		case condition.GetConditionThreshold() != nil:
+			threshold := condition.GetConditionThreshold()
+			q.kind = "threshold"
+			filter = threshold.GetFilter()
+			aggregations = threshold.GetAggregations()
+			if threshold.GetDenominatorFilter() != "" {
+				// Ratios only overlap with ratios of the same denominator
+				q.kind += " / " + normalizeQuery(threshold.GetDenominatorFilter())
+			}
+		case condition.GetConditionAbsent() != nil:
+			q.kind = "absent"
+			filter = condition.GetConditionAbsent().GetFilter()
+			aggregations = condition.GetConditionAbsent().GetAggregations()
+		case condition.GetConditionMonitoringQueryLanguage() != nil:
+			q.kind = "mql"
+			q.clauses = []string{normalizeQuery(condition.GetConditionMonitoringQueryLanguage().GetQuery())}
+		case condition.GetConditionPrometheusQueryLanguage() != nil:
+			q.kind = "promql"
+			q.clauses = []string{normalizeQuery(condition.GetConditionPrometheusQueryLanguage().GetQuery())}
+		default:
+			continue
+		}
+		if filter != "" {
+			q.clauses = filterClauses(filter)
+			var parts []string
+			for _, aggregation := range aggregations {
+				groupBy := make([]string, len(aggregation.GetGroupByFields()))
+				for i, field := range aggregation.GetGroupByFields() {
+					groupBy[i] = normalizeLabel(field)
+				}
+				slices.Sort(groupBy)
+				parts = append(parts, fmt.Sprintf("%s/%s/%s/%s", aggregation.GetAlignmentPeriod().AsDuration(), aggregation.GetPerSeriesAligner(), aggregation.GetCrossSeriesReducer(), strings.Join(groupBy, ",")))
+			}
+			q.aggregations = strings.Join(parts, ";")
+		}
+		queries = append(queries, q.String())
+	}
+	slices.Sort(queries)
+	return slices.Compact(queries)
+}
+
+// normalizeQuery removes differences in whitespace from a query or filter
+func normalizeQuery(query string) string {
+	return strings.TrimSpace(whitespacePattern.ReplaceAllString(query, " "))
+}
+
+// filterClauses returns the sorted, normalized clauses of a filter
+func filterClauses(filter string) []string {
+	filter = normalizeQuery(filter)
+	if strings.Contains(filter, " OR ") || strings.Contains(filter, "NOT ") || strings.HasPrefix(filter, "-") || strings.Contains(filter, " -") {
+		return []string{filter}
+	}
+	clauses := filterClauseSeparator.Split(filter, -1)
+	for i, clause := range clauses {
+		clause = filterOperatorSpace.ReplaceAllString(clause, "$1")
+		clause = filterLabelsNotation.ReplaceAllString(clause, "$1.label.")
+		clauses[i] = strings.ReplaceAll(clause, "'", `"`)
+	}
+	slices.Sort(clauses)
+	return clauses
+}
+
+// policyOverlap returns how the queries of policy a relate to the queries of policy b:
+// identical if they are the same, superset if a covers all queries of b, subset if b covers all queries of a,
+// partial if only some of them overlap, or an empty string if none of them do
+func policyOverlap(a *policy, b *policy) string {
+	if slices.Equal(a.Queries, b.Queries) {
+		return "identical"
+	}
+	coversAll := func(x *policy, y *policy) bool {
+		for _, qy := range y.Queries {
+			if !slices.ContainsFunc(x.Queries, func(qx string) bool { return parseConditionQuery(qx).covers(parseConditionQuery(qy)) }) {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case coversAll(a, b):
+		return "superset"
+	case coversAll(b, a):
+		return "subset"
+	}
+	for _, qa := range a.Queries {
+		for _, qb := range b.Queries {
+			if parseConditionQuery(qa).covers(parseConditionQuery(qb)) || parseConditionQuery(qb).covers(parseConditionQuery(qa)) {
+				return "partial"
+			}
+		}
+	}
+	return ""
+}
+
+// overlapSink collects the queries of all policies and writes the pairs of policies whose conditions query the same or
+// overlapping time series to a CSV file once the run is done, as duplicated policies multiply the price of alerting
+type overlapSink struct {
+	path           string
+	storage        *storage.Service
+	acrossProjects bool
+	policies       []*policy
+}
+
+func newOverlapSink(path string, storageService *storage.Service, acrossProjects bool) *overlapSink {
+	return &overlapSink{path: path, storage: storageService, acrossProjects: acrossProjects}
+}
+
+func (s *overlapSink) write(p *policy) error {
+	if p.Error == "" && len(p.Queries) > 0 {
+		s.policies = append(s.policies, p)
+	}
+	return nil
+}
+
+func (s *overlapSink) close(partial bool) error {
+	// Only policies with queries in the same group can overlap, so we don't have to compare all pairs of policies
+	groups := map[string][]int{}
+	for i, p := range s.policies {
+		for _, query := range p.Queries {
+			key := parseConditionQuery(query).group()
+			if !s.acrossProjects {
+				key = p.ProjectId + "|" + key
+			}
+			if members := groups[key]; len(members) == 0 || members[len(members)-1] != i {
+				groups[key] = append(members, i)
+			}
+		}
+	}
+	compared := map[[2]int]bool{}
+	var pairs [][2]int
+	for _, members := range groups {
+		for x := range members {
+			for y := x + 1; y < len(members); y++ {
+				pair := [2]int{members[x], members[y]}
+				if !compared[pair] {
+					compared[pair] = true
+					pairs = append(pairs, pair)
+				}
+			}
+		}
+	}
+	slices.SortFunc(pairs, func(a, b [2]int) int {
+		if a[0] != b[0] {
+			return a[0] - b[0]
+		}
+		return a[1] - b[1]
+	})
+
+	records := [][]string{{"Project ID", "Policy", "Display Name", "Other Project ID", "Other Policy", "Other Display Name", "Overlap", "Combined Price"}}
+	combined := 0.0
+	for _, pair := range pairs {
+		a, b := s.policies[pair[0]], s.policies[pair[1]]
+		overlap := policyOverlap(a, b)
+		if overlap == "" {
+			continue
+		}
+		combined += a.Price + b.Price
+		records = append(records, []string{a.ProjectId, a.Name, a.DisplayName, b.ProjectId, b.Name, b.DisplayName, overlap, strconv.FormatFloat(a.Price+b.Price, 'f', 2, 64)})
+	}
+	err := writeCSVOutput(s.path, s.storage, records)
+	if err != nil {
+		return err
+	}
+	log.Printf("Found %d pair(s) of overlapping policies with a combined price of approximately $%f\n", len(records)-1, combined)
+	if partial {
+		log.Println("The overlaps only include the policies that were processed before the run was stopped")
+	}
+	return nil
+}
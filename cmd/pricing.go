@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	cloudbilling "google.golang.org/api/cloudbilling/v1"
+)
+
+const (
+	// pricingVersion identifies the prices the estimates are based on: $1.50 per condition and $0.35 per million time series returned per month
+	pricingVersion = "2026-04"
+	// monitoringService is the Cloud Monitoring service in the Cloud Billing Catalog
+	monitoringService = "services/58CD-E7C3-72CA"
+)
+
+var (
+	// pricingAsOf is the date the built-in prices were last checked against the published pricing
+	pricingAsOf = time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC)
+	// pricingMaxAge is how long the built-in prices are trusted without a warning
+	pricingMaxAge = 365 * 24 * time.Hour
+)
+
+// warnStalePricing warns if the built-in prices are older than pricingMaxAge at now
+func warnStalePricing(now time.Time) {
+	if now.Sub(pricingAsOf) > pricingMaxAge {
+		slog.Warn("The built-in prices may be outdated, check https://cloud.google.com/stackdriver/pricing#pricing-alerting and update appe", "pricingAsOf", pricingAsOf.Format(time.DateOnly))
+	}
+}
+
+// checkPricing compares the built-in prices to the alerting SKUs of Cloud Monitoring in the Cloud Billing Catalog and warns about differences.
+// SKUs are matched by their description, so a SKU that can't be matched is only logged.
+func checkPricing(ctx context.Context, service *cloudbilling.APIService) error {
+	return service.Services.Skus.List(monitoringService).CurrencyCode("USD").Pages(ctx, func(response *cloudbilling.ListSkusResponse) error {
+		for _, sku := range response.Skus {
+			description := strings.ToLower(sku.Description)
+			if !strings.Contains(description, "alert") || len(sku.PricingInfo) == 0 {
+				continue
+			}
+			// The latest pricing is last, the highest tier is the price after any free usage
+			expression := sku.PricingInfo[len(sku.PricingInfo)-1].PricingExpression
+			if expression == nil || len(expression.TieredRates) == 0 {
+				continue
+			}
+			rate := expression.TieredRates[len(expression.TieredRates)-1].UnitPrice
+			price := float64(rate.Units) + float64(rate.Nanos)/1e9
+			var expected float64
+			var catalog float64
+			switch {
+			case strings.Contains(description, "condition"):
+				expected, catalog = 1.5, price
+			case strings.Contains(description, "time series"):
+				// appe uses the price per million time series, the catalog may give it per single time series
+				expected, catalog = 0.35, price
+				if expression.UsageUnit == "count" {
+					catalog = price * 1e6
+				}
+			default:
+				slog.Debug("Found unknown alerting SKU", "sku", sku.Description, "price", fmt.Sprintf("$%g per %s", price, expression.UsageUnitDescription))
+				continue
+			}
+			if math.Abs(catalog-expected) > 0.005 {
+				slog.Warn("The Cloud Billing Catalog has a different price than appe, the estimates may be wrong", "sku", sku.Description, "catalog", catalog, "appe", expected, "pricingAsOf", pricingAsOf.Format(time.DateOnly))
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progress keeps track of how far a run has come. When running in a terminal, it can continuously render
+// a status line to stderr, which is cleared and redrawn around every log message.
+type progress struct {
+	projectsFound  atomic.Int64
+	projectsDone   atomic.Int64
+	policiesQueued atomic.Int64
+	policiesDone   atomic.Int64
+	started        time.Time
+	mu             sync.Mutex
+	out            io.Writer
+	line           string
+	done           chan struct{}
+}
+
+func newProgress() *progress {
+	return &progress{
+		started: time.Now(),
+		out:     os.Stderr,
+		done:    make(chan struct{}),
+	}
+}
+
+// isTerminal reports whether f is connected to a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
+
+// Write clears the status line before writing p and redraws it afterwards, so that log messages don't get mixed up with it
+func (p *progress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.line != "" {
+		fmt.Fprint(p.out, "\r\033[K")
+	}
+	n, err := p.out.Write(b)
+	if p.line != "" {
+		fmt.Fprint(p.out, p.line)
+	}
+	return n, err
+}
+
+// start renders the status line every second until stop is called
+func (p *progress) start() {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-ticker.C:
+				p.render()
+			}
+		}
+	}()
+}
+
+func (p *progress) stop() {
+	close(p.done)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.line != "" {
+		fmt.Fprint(p.out, "\r\033[K")
+		p.line = ""
+	}
+}
+
+func (p *progress) render() {
+	elapsed := time.Since(p.started).Round(time.Second)
+	queued := p.policiesQueued.Load()
+	done := p.policiesDone.Load()
+	eta := "unknown"
+	if done > 0 {
+		// We extrapolate from the average time per policy so far. As long as projects are still being discovered, this will go up.
+		remaining := time.Duration(float64(elapsed) / float64(done) * float64(queued-done))
+		eta = remaining.Round(time.Second).String()
+	}
+	line := fmt.Sprintf("Projects: %d found, %d listed | Policies: %d queued, %d done | Elapsed: %s | ETA: %s",
+		p.projectsFound.Load(), p.projectsDone.Load(), queued, done, elapsed, eta)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.line = line
+	fmt.Fprint(p.out, "\r\033[K"+line)
+}
@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"google.golang.org/api/googleapi"
+	pubsub "google.golang.org/api/pubsub/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// assetFeedMessage is a message that a Cloud Asset Inventory feed publishes when an asset changes
+type assetFeedMessage struct {
+	Asset struct {
+		// Name is the full resource name, e.g. //monitoring.googleapis.com/projects/123/alertPolicies/456
+		Name      string `json:"name"`
+		AssetType string `json:"assetType"`
+	} `json:"asset"`
+	Deleted bool `json:"deleted"`
+}
+
+// watchAckDeadline is how long Pub/Sub waits for a queued policy change to be acknowledged before it delivers it again
+const watchAckDeadline = 600
+
+// policyWatcher pulls the messages of an asset feed and acknowledges them once the estimate of their policy was written,
+// so that changes that weren't estimated when the run stops are delivered again
+type policyWatcher struct {
+	service      *pubsub.Service
+	subscription string
+	mu           sync.Mutex
+	// pending are the ack IDs of the messages of queued policies by the name of the policy, in the order they were queued
+	pending map[string][]string
+}
+
+func newPolicyWatcher(service *pubsub.Service, subscription string) *policyWatcher {
+	return &policyWatcher{service: service, subscription: subscription, pending: map[string][]string{}}
+}
+
+// watch pulls the messages of the subscription until ctx is done and puts every created or changed policy on policiesIn.
+// The policies are fetched with getPolicy, because feeds don't need to include the content of the assets.
+// Messages that don't need an estimate are acknowledged right away, those whose policy can't be fetched are delivered again.
+func (w *policyWatcher) watch(ctx context.Context, getPolicy func(name string) (*monitoringpb.AlertPolicy, error), policiesIn chan *monitoringpb.AlertPolicy, queued func()) error {
+	slog.Info("Watching for policy changes", "subscription", w.subscription)
+	for ctx.Err() == nil {
+		response, err := w.service.Projects.Subscriptions.Pull(w.subscription, &pubsub.PullRequest{MaxMessages: 100}).Context(ctx).Do()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			var apiErr *googleapi.Error
+			if errors.As(err, &apiErr) && apiErr.Code < http.StatusInternalServerError {
+				return err
+			}
+			slog.Warn("Failed to pull policy changes, retrying", "subscription", w.subscription, "error", err)
+			time.Sleep(10 * time.Second)
+			continue
+		}
+		var skipped []string
+		var policies []*monitoringpb.AlertPolicy
+		var policyAckIds []string
+		for _, received := range response.ReceivedMessages {
+			var message assetFeedMessage
+			data, err := base64.StdEncoding.DecodeString(received.Message.Data)
+			if err == nil {
+				err = json.Unmarshal(data, &message)
+			}
+			if err != nil {
+				slog.Warn("Skipped invalid asset feed message", "id", received.Message.MessageId, "error", err)
+				skipped = append(skipped, received.AckId)
+				continue
+			}
+			if message.Asset.AssetType != alertPolicyAssetType || message.Deleted {
+				skipped = append(skipped, received.AckId)
+				continue
+			}
+			name := strings.TrimPrefix(message.Asset.Name, "//monitoring.googleapis.com/")
+			policy, err := getPolicy(name)
+			if status.Code(err) == codes.NotFound {
+				// The policy was deleted after the change was published
+				skipped = append(skipped, received.AckId)
+				continue
+			}
+			if err != nil {
+				slog.Error("Failed to get changed policy, it will be delivered again", "policy", name, "error", err, "code", errorCode(err))
+				continue
+			}
+			policies = append(policies, policy)
+			policyAckIds = append(policyAckIds, received.AckId)
+		}
+		w.acknowledge(ctx, skipped)
+		// The queue may be long, so the messages get more time until they are delivered again
+		if len(policyAckIds) > 0 {
+			_, err = w.service.Projects.Subscriptions.ModifyAckDeadline(w.subscription, &pubsub.ModifyAckDeadlineRequest{AckIds: policyAckIds, AckDeadlineSeconds: watchAckDeadline}).Context(ctx).Do()
+			if err != nil {
+				slog.Warn("Failed to extend the deadline of policy changes, they may be estimated twice", "subscription", w.subscription, "error", err)
+			}
+		}
+		for i, policy := range policies {
+			slog.Debug("Policy changed", "policy", policy.GetName())
+			w.mu.Lock()
+			w.pending[policy.GetName()] = append(w.pending[policy.GetName()], policyAckIds[i])
+			w.mu.Unlock()
+			queued()
+			policiesIn <- policy
+		}
+	}
+	return nil
+}
+
+// written acknowledges the oldest message of a policy once its estimate was written
+func (w *policyWatcher) written(ctx context.Context, name string) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	ackIds := w.pending[name]
+	if len(ackIds) == 0 {
+		w.mu.Unlock()
+		return
+	}
+	if len(ackIds) == 1 {
+		delete(w.pending, name)
+	} else {
+		w.pending[name] = ackIds[1:]
+	}
+	w.mu.Unlock()
+	w.acknowledge(ctx, ackIds[:1])
+}
+
+// acknowledge acknowledges messages, even if ctx is already done, as their policies don't need to be estimated again
+func (w *policyWatcher) acknowledge(ctx context.Context, ackIds []string) {
+	if len(ackIds) == 0 {
+		return
+	}
+	_, err := w.service.Projects.Subscriptions.Acknowledge(w.subscription, &pubsub.AcknowledgeRequest{AckIds: ackIds}).Context(context.WithoutCancel(ctx)).Do()
+	if err != nil {
+		slog.Warn("Failed to acknowledge policy changes, they will be estimated again", "subscription", w.subscription, "error", err)
+	}
+}
+
+// pubsubSink publishes every estimate to a topic as soon as it is available, so that other systems can react to new estimates.
+// Failed messages are only logged, so that a long-running watch isn't stopped by them.
+type pubsubSink struct {
+	ctx     context.Context
+	service *pubsub.Service
+	topic   string
+	runTime time.Time
+}
+
+func newPubSubSink(ctx context.Context, service *pubsub.Service, topic string, runTime time.Time) *pubsubSink {
+	return &pubsubSink{ctx: ctx, service: service, topic: topic, runTime: runTime}
+}
+
+func (s *pubsubSink) write(p *policy) error {
+	data, err := json.Marshal(newPolicyResult(p, s.runTime))
+	if err != nil {
+		return err
+	}
+	_, err = s.service.Projects.Topics.Publish(s.topic, &pubsub.PublishRequest{Messages: []*pubsub.PubsubMessage{{
+		Data:       base64.StdEncoding.EncodeToString(data),
+		Attributes: map[string]string{"policy": p.Name},
+	}}}).Context(s.ctx).Do()
+	if err != nil {
+		slog.Error("Failed to publish estimate", "topic", s.topic, "policy", p.Name, "error", err)
+	}
+	return nil
+}
+
+func (s *pubsubSink) close(partial bool) error {
+	return nil
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// pulumiAlertPolicyType is the type token of alerting policies in the Google Cloud provider of Pulumi
+const pulumiAlertPolicyType = "gcp:monitoring/alertPolicy:AlertPolicy"
+
+// pulumiPreview is the output of "pulumi preview --json"
+type pulumiPreview struct {
+	Config map[string]string `json:"config"`
+	Steps  []struct {
+		Op       string `json:"op"`
+		URN      string `json:"urn"`
+		NewState struct {
+			Type   string         `json:"type"`
+			Inputs map[string]any `json:"inputs"`
+		} `json:"newState"`
+	} `json:"steps"`
+}
+
+// readPulumiPreview reads the alerting policies that exist after a Pulumi deployment from its preview and adds them to definitions.
+// Deleted policies are skipped. The project of a policy is taken from its inputs, the gcp:project config or projectId, in that order.
+func readPulumiPreview(path string, projectId string, definitions policyDefinitions) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var preview pulumiPreview
+	err = json.Unmarshal(b, &preview)
+	if err != nil {
+		return err
+	}
+	for _, step := range preview.Steps {
+		if step.NewState.Type != pulumiAlertPolicyType || step.Op == "delete" || step.Op == "delete-replaced" || step.Op == "discard" {
+			continue
+		}
+		// The name of the resource is the last part of its URN, e.g. "cpu" in urn:pulumi:dev::project::gcp:monitoring/alertPolicy:AlertPolicy::cpu
+		name := step.URN[strings.LastIndex(step.URN, "::")+2:]
+		policy, err := policyFromFields(step.NewState.Inputs)
+		if err != nil {
+			return fmt.Errorf("invalid alert policy %s: %v", name, err)
+		}
+		project, _ := step.NewState.Inputs["project"].(string)
+		if project == "" {
+			project = preview.Config["gcp:project"]
+		}
+		if project == "" {
+			project = projectId
+		}
+		if project == "" {
+			return fmt.Errorf("alert policy %s has no project, set --targetProject", name)
+		}
+		definitions.add(project, "pulumi", name, policy)
+	}
+	return nil
+}
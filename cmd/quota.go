@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"cloud.google.com/go/iam/apiv1/iampb"
+	resourcemanager "cloud.google.com/go/resourcemanager/apiv3"
+	"google.golang.org/grpc/metadata"
+)
+
+// quotaProjectHeader is the header that sets the project whose quota is used for a request
+const quotaProjectHeader = "x-goog-user-project"
+
+// quotaAttribution attributes the quota of the requests for a project to the project itself instead of a single global quota project,
+// if the caller has the serviceusage.services.use permission in it.
+type quotaAttribution struct {
+	projectsClient *resourcemanager.ProjectsClient
+	// allowed caches whether the caller may use the quota of a project
+	allowed sync.Map
+}
+
+// newQuotaAttribution returns a quota attribution that checks the permissions of the caller with projectsClient
+func newQuotaAttribution(projectsClient *resourcemanager.ProjectsClient) *quotaAttribution {
+	return &quotaAttribution{projectsClient: projectsClient}
+}
+
+// context returns a context that attributes the quota of all requests made with it to projectId.
+// If the caller can't use the quota of the project or q is nil, ctx is returned unchanged.
+func (q *quotaAttribution) context(ctx context.Context, projectId string) context.Context {
+	if q == nil {
+		return ctx
+	}
+	allowed, found := q.allowed.Load(projectId)
+	if !found {
+		resp, err := q.projectsClient.TestIamPermissions(ctx, &iampb.TestIamPermissionsRequest{
+			Resource:    "projects/" + projectId,
+			Permissions: []string{"serviceusage.services.use"},
+		})
+		if err != nil {
+			slog.Warn("Failed to test quota permission, using the default quota project", "project", projectId, "error", err, "code", errorCode(err))
+		}
+		allowed, _ = q.allowed.LoadOrStore(projectId, err == nil && slices.Contains(resp.GetPermissions(), "serviceusage.services.use"))
+	}
+	if !allowed.(bool) {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, quotaProjectHeader, projectId)
+}
+
+// contextQuotaProject returns the quota project that was set on ctx by quotaAttribution.context, so that it can be passed to REST requests as well
+func contextQuotaProject(ctx context.Context) string {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if values := md.Get(quotaProjectHeader); len(values) > 0 {
+		return values[len(values)-1]
+	}
+	return ""
+}
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+// recommenderId is the ID of the recommender in the names of the recommendations, following the naming of the Recommender API
+const recommenderId = "appe.AlertPolicyCostRecommender"
+
+// recommendation is an optimization finding in the format of a recommendation of the Recommender API,
+// so that it can be triaged in the same workflow as the recommendations of Google Cloud
+type recommendation struct {
+	Name               string                  `json:"name"`
+	Description        string                  `json:"description"`
+	RecommenderSubtype string                  `json:"recommenderSubtype"`
+	LastRefreshTime    time.Time               `json:"lastRefreshTime"`
+	PrimaryImpact      recommendationImpact    `json:"primaryImpact"`
+	Priority           string                  `json:"priority"`
+	Content            recommendationContent   `json:"content"`
+	StateInfo          recommendationStateInfo `json:"stateInfo"`
+	Etag               string                  `json:"etag"`
+	TargetResources    []string                `json:"targetResources"`
+}
+
+type recommendationImpact struct {
+	Category       string `json:"category"`
+	CostProjection struct {
+		Cost struct {
+			CurrencyCode string `json:"currencyCode"`
+			Units        string `json:"units"`
+			Nanos        int32  `json:"nanos"`
+		} `json:"cost"`
+		Duration string `json:"duration"`
+	} `json:"costProjection"`
+}
+
+type recommendationContent struct {
+	Overview map[string]any `json:"overview"`
+}
+
+type recommendationStateInfo struct {
+	State string `json:"state"`
+}
+
+// recommendationsSink writes optimization findings about the policies as recommendations to a JSON file in the format of a
+// ListRecommendations response once the run is done. Policies above warnAbove are recommended for review, as their price could be saved.
+type recommendationsSink struct {
+	path            string
+	storage         *storage.Service
+	warnAbove       float64
+	runTime         time.Time
+	recommendations []recommendation
+}
+
+func newRecommendationsSink(path string, storageService *storage.Service, warnAbove float64, runTime time.Time) *recommendationsSink {
+	return &recommendationsSink{path: path, storage: storageService, warnAbove: warnAbove, runTime: runTime, recommendations: []recommendation{}}
+}
+
+func (s *recommendationsSink) write(p *policy) error {
+	if p.Error == "" && p.Price > s.warnAbove {
+		s.add(p, "REVIEW_EXPENSIVE_POLICY", fmt.Sprintf("Review the alerting policy %s, which costs approximately $%.2f per month.", p.DisplayName, p.Price), p.Price)
+	}
+	if p.Error == "" && p.PotentialSaving > 0 {
+		s.add(p, "AGGREGATE_TIME_SERIES", fmt.Sprintf("Aggregate the time series of the alerting policy %s from %d to %d, which saves approximately $%.2f per month.", p.DisplayName, p.TimeSeries, p.SuggestedTimeSeries, p.PotentialSaving), p.PotentialSaving)
+	}
+	return nil
+}
+
+// add adds a recommendation about p that saves up to saving USD per month
+func (s *recommendationsSink) add(p *policy, subtype string, description string, saving float64) {
+	id := sha256.Sum256([]byte(subtype + "/" + p.Name))
+	etag := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/%f", subtype, p.Name, saving)))
+	r := recommendation{
+		Name:               fmt.Sprintf("projects/%s/locations/global/recommenders/%s/recommendations/%s", p.ProjectId, recommenderId, hex.EncodeToString(id[:16])),
+		Description:        description,
+		RecommenderSubtype: subtype,
+		LastRefreshTime:    s.runTime,
+		Priority:           recommendationPriority(saving),
+		Content: recommendationContent{Overview: map[string]any{
+			"policy":      p.Name,
+			"displayName": p.DisplayName,
+			"conditions":  p.Conditions,
+			"timeSeries":  p.TimeSeries,
+			"price":       p.Price,
+		}},
+		StateInfo:       recommendationStateInfo{State: "ACTIVE"},
+		Etag:            `"` + hex.EncodeToString(etag[:8]) + `"`,
+		TargetResources: []string{"//monitoring.googleapis.com/" + p.Name},
+	}
+	// Like in the Recommender API, savings are a negative cost over the duration of the projection
+	r.PrimaryImpact.Category = "COST"
+	units, nanos := math.Modf(-saving)
+	r.PrimaryImpact.CostProjection.Cost.CurrencyCode = "USD"
+	r.PrimaryImpact.CostProjection.Cost.Units = fmt.Sprint(int64(units))
+	r.PrimaryImpact.CostProjection.Cost.Nanos = int32(math.Round(nanos * 1e9))
+	r.PrimaryImpact.CostProjection.Duration = fmt.Sprintf("%ds", int64(30*24*time.Hour/time.Second))
+	s.recommendations = append(s.recommendations, r)
+}
+
+// recommendationPriority returns the priority of a recommendation by its monthly saving, from P1 (highest) to P4
+func recommendationPriority(saving float64) string {
+	switch {
+	case saving >= 100:
+		return "P1"
+	case saving >= 20:
+		return "P2"
+	case saving >= 5:
+		return "P3"
+	default:
+		return "P4"
+	}
+}
+
+func (s *recommendationsSink) close(partial bool) error {
+	b, err := json.MarshalIndent(map[string]any{"recommendations": s.recommendations}, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, upload, err := createOutput(s.path, s.storage)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return finishOutput(f, upload)
+}
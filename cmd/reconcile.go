@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// reconcileCmd compares the actual alerting costs in the billing export to an estimate of appe
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile",
+	Short: "Compare an estimate to the actual alerting costs in the billing export",
+	Long: `Queries the standard Cloud Billing export in BigQuery for the costs of the alerting SKUs of Cloud Monitoring in a past month
+and compares them per project to an estimate that appe wrote to a CSV file with --csvOut for the same scope.`,
+	Example: `./appe reconcile --billingTable PROJECT_ID.billing.gcp_billing_export_v1_012345_6789AB_CDEF01 --estimate out.csv --month 202605`,
+	Args:    cobra.NoArgs,
+	Run:     reconcile,
+}
+
+func reconcile(cmd *cobra.Command, args []string) {
+	billingTable, err := cmd.Flags().GetString("billingTable")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	estimateFile, err := cmd.Flags().GetString("estimate")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	month, err := cmd.Flags().GetString("month")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	credentials, err := cmd.Flags().GetString("credentials")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	table, err := parseBigQueryTable(billingTable)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	// The invoice month defaults to the last complete month
+	if month == "" {
+		month = time.Now().AddDate(0, -1, 0).Format("200601")
+	}
+	if !regexp.MustCompile(`^\d{6}$`).MatchString(month) {
+		log.Fatalf("Invalid month %q, must be in the format YYYYMM", month)
+	}
+
+	// The estimate is summed up per project, which is part of each policy name
+	estimate, err := readBaseline(estimateFile)
+	if err != nil {
+		log.Fatalf("Failed to read estimate: %v", err)
+	}
+	estimated := map[string]float64{}
+	for name, price := range estimate.prices {
+		estimated[strings.Split(name, "/")[1]] += price
+	}
+
+	ctx := context.Background()
+	service, err := newBigQueryService(ctx, credentials)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	// Credits (e.g. free tiers or discounts) are subtracted, so that the actual costs are what was paid.
+	// Filtering on the partition time keeps the scanned data to the month and the late usage reported after it.
+	rows, err := queryBigQuery(ctx, service, table.project, fmt.Sprintf(`SELECT project.id, currency, SUM(cost) + SUM(IFNULL((SELECT SUM(c.amount) FROM UNNEST(credits) c), 0))
+FROM `+"`%s`"+`
+WHERE invoice.month = @month
+  AND _PARTITIONTIME >= TIMESTAMP(PARSE_DATE('%%Y%%m', @month))
+  AND service.id = '%s'
+  AND LOWER(sku.description) LIKE '%%alert%%'
+GROUP BY 1, 2`, table, strings.TrimPrefix(monitoringService, "services/")), map[string]string{"month": month})
+	if err != nil {
+		log.Fatalf("Failed to query billing export: %v", err)
+	}
+	actual := map[string]float64{}
+	for _, row := range rows {
+		if row[1] != "USD" {
+			log.Printf("Project %s is billed in %s, but the estimate is in USD\n", row[0], row[1])
+		}
+		actual[row[0]] += parseFloat(row[2])
+	}
+
+	// Projects that only appear in one of them are compared to $0
+	all := maps.Clone(actual)
+	maps.Copy(all, estimated)
+	projects := slices.Sorted(maps.Keys(all))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "Project\tActual\tEstimate\tDelta\tDelta %%\t\n")
+	var totalActual, totalEstimated float64
+	for _, project := range projects {
+		fmt.Fprintf(w, "%s\t$%.2f\t$%.2f\t%s\t%s\t\n", project, actual[project], estimated[project], formatDelta(estimated[project]-actual[project]), formatPercent(estimated[project], actual[project]))
+		totalActual += actual[project]
+		totalEstimated += estimated[project]
+	}
+	fmt.Fprintf(w, "Total\t$%.2f\t$%.2f\t%s\t%s\t\n", totalActual, totalEstimated, formatDelta(totalEstimated-totalActual), formatPercent(totalEstimated, totalActual))
+	w.Flush()
+}
+
+// formatPercent formats the difference of an estimate to the actual costs in percent of the actual costs
+func formatPercent(estimated float64, actual float64) string {
+	if actual == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+.0f%%", (estimated-actual)/actual*100)
+}
+
+func init() {
+	rootCmd.AddCommand(reconcileCmd)
+	reconcileCmd.Flags().String("billingTable", "", "The table of the standard Cloud Billing export in the format PROJECT.DATASET.TABLE.")
+	reconcileCmd.Flags().String("estimate", "", "Path to a CSV file that appe wrote with --csvOut for the scope to compare.")
+	reconcileCmd.Flags().String("month", "", "The invoice month to compare in the format YYYYMM. Defaults to the last month.")
+	reconcileCmd.Flags().String("credentials", "", "Path to a service account key or workload identity federation configuration. Defaults to the credentials stored by \"appe auth login\" if they exist, otherwise the application default credentials are used.")
+	reconcileCmd.MarkFlagRequired("billingTable")
+	reconcileCmd.MarkFlagRequired("estimate")
+}
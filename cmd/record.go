@@ -0,0 +1,352 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	monitoring_v1 "google.golang.org/api/monitoring/v1"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// recordingRun is the file of a recording with the time of the run, which a replay uses as its own, so that it sends the same requests
+const recordingRun = "run.json"
+
+// recordingCalls is the file of a recording with one recordedCall per line
+const recordingCalls = "calls.jsonl"
+
+// recordedRun describes the run a recording was made of
+type recordedRun struct {
+	RunTime time.Time `json:"runTime"`
+	// Flags are the flags of the run with their secrets redacted, as the recording may be shared
+	Flags []string `json:"flags"`
+}
+
+// recordedCall is the response to a request of a recording
+type recordedCall struct {
+	// Key identifies the request by its method and a hash of its content
+	Key string `json:"key"`
+	// Response is the serialized protobuf message of a gRPC call or the body of an HTTP response
+	Response []byte `json:"response,omitempty"`
+	// Code and Message are the status of a failed gRPC call
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Status is the status code of an HTTP response
+	Status int `json:"status,omitempty"`
+}
+
+// grpcCallKey returns the key of a gRPC request
+func grpcCallKey(method string, req proto.Message) (string, error) {
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(b)
+	return method + " " + hex.EncodeToString(hash[:]), nil
+}
+
+// httpCallKey returns the key of an HTTP request, which doesn't include the host, so that it's the same for the replay server
+func httpCallKey(method string, uri string, body []byte) string {
+	hash := sha256.Sum256(body)
+	return method + " " + uri + " " + hex.EncodeToString(hash[:])
+}
+
+// apiRecorder writes the responses of all requests to Cloud Monitoring and Resource Manager to a directory, so that the run can be replayed with --replay
+type apiRecorder struct {
+	mu   sync.Mutex
+	file *os.File
+	w    *bufio.Writer
+	// err is the first error writing the recording, after which no further calls are recorded
+	err error
+}
+
+// newAPIRecorder creates the recording in dir
+func newAPIRecorder(dir string, runTime time.Time, flags []string) (*apiRecorder, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(recordedRun{RunTime: runTime, Flags: flags})
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(filepath.Join(dir, recordingRun), b, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Create(filepath.Join(dir, recordingCalls))
+	if err != nil {
+		return nil, err
+	}
+	return &apiRecorder{file: f, w: bufio.NewWriter(f)}, nil
+}
+
+// record appends a call to the recording
+func (r *apiRecorder) record(call recordedCall) {
+	b, err := json.Marshal(call)
+	if err != nil {
+		slog.Warn("Failed to record call", "key", call.Key, "error", err)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	_, r.err = r.w.Write(append(b, '\n'))
+	if r.err != nil {
+		slog.Error("Failed to write recording, it will be incomplete", "error", r.err)
+	}
+}
+
+// close flushes the recording and returns the first error writing it
+func (r *apiRecorder) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	err := r.file.Close()
+	if r.err != nil {
+		return r.err
+	}
+	return err
+}
+
+// grpcOption returns the option that records the responses of a gRPC client
+func (r *apiRecorder) grpcOption() option.ClientOption {
+	return option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		// Canceled calls weren't answered, and a replay wouldn't send them either
+		if ctx.Err() != nil {
+			return err
+		}
+		key, keyErr := grpcCallKey(method, req.(proto.Message))
+		if keyErr != nil {
+			slog.Warn("Failed to record call", "method", method, "error", keyErr)
+			return err
+		}
+		call := recordedCall{Key: key}
+		if err != nil {
+			s := status.Convert(err)
+			call.Code, call.Message = s.Code().String(), s.Message()
+		} else {
+			call.Response, keyErr = proto.Marshal(reply.(proto.Message))
+			if keyErr != nil {
+				slog.Warn("Failed to record call", "method", method, "error", keyErr)
+				return err
+			}
+		}
+		r.record(call)
+		return err
+	}))
+}
+
+// transport returns an HTTP transport that records the responses of next
+func (r *apiRecorder) transport(next http.RoundTripper) http.RoundTripper {
+	return recordingTransport{recorder: r, next: next}
+}
+
+// recordingMonitoringV1 returns a client of the Cloud Monitoring REST API whose responses are recorded
+func recordingMonitoringV1(ctx context.Context, r *apiRecorder, options []option.ClientOption) (*monitoring_v1.Service, error) {
+	client, _, err := htransport.NewClient(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create monitoring v1 client: %v", err)
+	}
+	// The client of --proxy is shared with the other REST services, so only a copy of it records
+	recording := *client
+	recording.Transport = r.transport(client.Transport)
+	return monitoring_v1.NewService(ctx, append(options, option.WithHTTPClient(&recording))...)
+}
+
+// recordingTransport records the responses of the REST clients
+type recordingTransport struct {
+	recorder *apiRecorder
+	next     http.RoundTripper
+}
+
+func (t recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	t.recorder.record(recordedCall{Key: httpCallKey(req.Method, req.URL.RequestURI(), body), Response: b, Status: resp.StatusCode})
+	return resp, nil
+}
+
+// replayServer answers the requests of a run with the responses of a recording. If a request was sent more than once,
+// e.g. because it was retried, the responses are returned in the order they were recorded, and the last one is repeated.
+type replayServer struct {
+	localServer
+
+	mu    sync.Mutex
+	calls map[string][]recordedCall
+	// flags are the flags of the recorded run
+	flags []string
+	// missing counts the requests that weren't in the recording
+	missing int
+}
+
+// readRecording reads the recording in dir
+func readRecording(dir string) (recordedRun, *replayServer, error) {
+	var run recordedRun
+	b, err := os.ReadFile(filepath.Join(dir, recordingRun))
+	if err != nil {
+		return run, nil, err
+	}
+	err = json.Unmarshal(b, &run)
+	if err != nil {
+		return run, nil, fmt.Errorf("invalid %s: %v", recordingRun, err)
+	}
+	f, err := os.Open(filepath.Join(dir, recordingCalls))
+	if err != nil {
+		return run, nil, err
+	}
+	defer f.Close()
+	r := &replayServer{calls: map[string][]recordedCall{}, flags: run.Flags}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var call recordedCall
+		err = json.Unmarshal(scanner.Bytes(), &call)
+		if err != nil {
+			return run, nil, fmt.Errorf("invalid call in line %d of %s: %v", line, recordingCalls, err)
+		}
+		r.calls[call.Key] = append(r.calls[call.Key], call)
+	}
+	return run, r, scanner.Err()
+}
+
+// next returns the next response to the request with key
+func (r *replayServer) next(key string) (recordedCall, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	calls := r.calls[key]
+	if len(calls) == 0 {
+		r.missing++
+		return recordedCall{}, false
+	}
+	if len(calls) > 1 {
+		r.calls[key] = calls[1:]
+	}
+	return calls[0], true
+}
+
+// start starts the replay server on random local ports
+func (r *replayServer) start() error {
+	return r.serve(grpc.NewServer(grpc.UnknownServiceHandler(r.handleGRPC)), http.HandlerFunc(r.handleHTTP))
+}
+
+// stop stops the replay server and warns if requests weren't in the recording
+func (r *replayServer) stop() {
+	r.close()
+	if r.missing > 0 {
+		slog.Warn("Requests weren't in the recording, the replay was probably run with other flags than the recording", "requests", r.missing, "recordedFlags", strings.Join(r.flags, " "))
+	}
+}
+
+// handleGRPC answers any gRPC method, whose request and response types are looked up in the registry of all protobuf types linked into appe
+func (r *replayServer) handleGRPC(srv any, stream grpc.ServerStream) error {
+	method, ok := grpc.MethodFromServerStream(stream)
+	if !ok {
+		return status.Error(codes.Internal, "unknown method")
+	}
+	service, name, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	descriptor, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return status.Errorf(codes.Unimplemented, "unknown service %s", service)
+	}
+	serviceDescriptor, ok := descriptor.(protoreflect.ServiceDescriptor)
+	if !ok || serviceDescriptor.Methods().ByName(protoreflect.Name(name)) == nil {
+		return status.Errorf(codes.Unimplemented, "unknown method %s", method)
+	}
+	methodDescriptor := serviceDescriptor.Methods().ByName(protoreflect.Name(name))
+	input, err := protoregistry.GlobalTypes.FindMessageByName(methodDescriptor.Input().FullName())
+	if err != nil {
+		return status.Errorf(codes.Unimplemented, "unknown request type of %s", method)
+	}
+	output, err := protoregistry.GlobalTypes.FindMessageByName(methodDescriptor.Output().FullName())
+	if err != nil {
+		return status.Errorf(codes.Unimplemented, "unknown response type of %s", method)
+	}
+	req := input.New().Interface()
+	err = stream.RecvMsg(req)
+	if err != nil {
+		return err
+	}
+	key, err := grpcCallKey(method, req)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	call, ok := r.next(key)
+	if !ok {
+		return status.Errorf(codes.NotFound, "no recorded response to %s", method)
+	}
+	if call.Code != "" {
+		var code codes.Code
+		err = code.UnmarshalJSON([]byte(strconv.Quote(call.Code)))
+		if err != nil {
+			return status.Errorf(codes.Internal, "invalid recorded code %q", call.Code)
+		}
+		return status.Error(code, call.Message)
+	}
+	reply := output.New().Interface()
+	err = proto.Unmarshal(call.Response, reply)
+	if err != nil {
+		return status.Errorf(codes.Internal, "invalid recorded response to %s: %v", method, err)
+	}
+	return stream.SendMsg(reply)
+}
+
+// handleHTTP answers the requests of the REST clients
+func (r *replayServer) handleHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	call, ok := r.next(httpCallKey(req.Method, req.URL.RequestURI(), body))
+	if !ok {
+		http.Error(w, "no recorded response", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(call.Status)
+	w.Write(call.Response)
+}
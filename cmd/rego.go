@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// regoInput is the input document that Rego policies are evaluated against
+type regoInput struct {
+	RunTime  time.Time      `json:"runTime"`
+	Partial  bool           `json:"partial"`
+	Total    float64        `json:"total"`
+	Policies []policyResult `json:"policies"`
+}
+
+// regoGate evaluates Rego policies against all results once the run is done, e.g. to fail pipelines that add expensive alerting policies.
+// Every value of the query is a violation. Values are usually messages, objects are reported by their msg field if they have one.
+type regoGate struct {
+	ctx        context.Context
+	prepared   rego.PreparedEvalQuery
+	input      regoInput
+	violations []string
+}
+
+// newRegoGate loads and compiles the Rego policies at paths, so that errors in them fail the run before anything is scanned
+func newRegoGate(ctx context.Context, paths []string, query string, runTime time.Time) (*regoGate, error) {
+	prepared, err := rego.New(
+		rego.Query(query),
+		rego.Load(paths, nil),
+		rego.SetRegoVersion(ast.RegoV1),
+	).PrepareForEval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &regoGate{ctx: ctx, prepared: prepared, input: regoInput{RunTime: runTime, Policies: []policyResult{}}}, nil
+}
+
+func (g *regoGate) write(p *policy) error {
+	g.input.Total += p.Price
+	g.input.Policies = append(g.input.Policies, newPolicyResult(p, g.input.RunTime))
+	return nil
+}
+
+func (g *regoGate) close(partial bool) error {
+	g.input.Partial = partial
+	results, err := g.prepared.Eval(g.ctx, rego.EvalInput(g.input))
+	if err != nil {
+		return fmt.Errorf("failed to evaluate Rego policies: %v", err)
+	}
+	for _, result := range results {
+		for _, expression := range result.Expressions {
+			// Rules like "deny contains msg if ..." evaluate to a set, which is returned as a list
+			values, ok := expression.Value.([]any)
+			if !ok {
+				values = []any{expression.Value}
+			}
+			for _, value := range values {
+				if object, ok := value.(map[string]any); ok && object["msg"] != nil {
+					value = object["msg"]
+				}
+				g.violations = append(g.violations, fmt.Sprint(value))
+			}
+		}
+	}
+	slices.Sort(g.violations)
+	return nil
+}
+
+// print logs all violations
+func (g *regoGate) print() {
+	if g == nil {
+		return
+	}
+	if len(g.violations) == 0 {
+		log.Println("No violations of the Rego policies were found")
+		return
+	}
+	log.Printf("Found %d violation(s) of the Rego policies:\n", len(g.violations))
+	for _, violation := range g.violations {
+		log.Printf("  - %s\n", violation)
+	}
+}
+
+// violated reports whether any policy was violated
+func (g *regoGate) violated() bool {
+	return g != nil && len(g.violations) > 0
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	resourcemanager "cloud.google.com/go/resourcemanager/apiv3"
+	"cloud.google.com/go/resourcemanager/apiv3/resourcemanagerpb"
+	"google.golang.org/api/iterator"
+)
+
+// isNumeric reports whether s only consists of digits, which is the format of folder, organization and project numbers
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveFolder returns the ID of a folder that is given either by its ID or by a path of display names (e.g. "Production/Platform").
+// The first element of the path doesn't have to be at the root of the organization, as long as the whole path is unambiguous.
+func resolveFolder(ctx context.Context, foldersClient *resourcemanager.FoldersClient, folder string) (string, error) {
+	folder = strings.TrimPrefix(folder, "folders/")
+	if isNumeric(folder) {
+		return folder, nil
+	}
+	candidates := []string{""}
+	for _, displayName := range strings.Split(strings.Trim(folder, "/"), "/") {
+		var matches []string
+		for i := range candidates {
+			m, err := searchFolders(ctx, foldersClient, displayName, candidates[i])
+			if err != nil {
+				return "", err
+			}
+			matches = append(matches, m...)
+		}
+		candidates = matches
+	}
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("no folder found with the path %q", folder)
+	case 1:
+		return strings.TrimPrefix(candidates[0], "folders/"), nil
+	default:
+		return "", fmt.Errorf("the path %q is ambiguous and matches %s", folder, strings.Join(candidates, ", "))
+	}
+}
+
+// searchFolders returns the names of all active folders with the exact display name under parent or anywhere if parent is empty
+func searchFolders(ctx context.Context, foldersClient *resourcemanager.FoldersClient, displayName string, parent string) ([]string, error) {
+	query := fmt.Sprintf("displayName=%q AND state=ACTIVE", displayName)
+	if parent != "" {
+		query += " AND parent=" + parent
+	}
+	it := foldersClient.SearchFolders(ctx, &resourcemanagerpb.SearchFoldersRequest{
+		Query: query,
+	})
+	var names []string
+	for {
+		folder, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The search is case-insensitive, so we need to make sure that we only return exact matches
+		if folder.GetDisplayName() == displayName {
+			names = append(names, folder.GetName())
+		}
+	}
+	return names, nil
+}
+
+// resolveOrganization returns the ID of an organization that is given either by its ID or by its domain (e.g. "example.com")
+func resolveOrganization(ctx context.Context, organizationsClient *resourcemanager.OrganizationsClient, organization string) (string, error) {
+	organization = strings.TrimPrefix(organization, "organizations/")
+	if isNumeric(organization) {
+		return organization, nil
+	}
+	it := organizationsClient.SearchOrganizations(ctx, &resourcemanagerpb.SearchOrganizationsRequest{
+		Query: "domain:" + organization,
+	})
+	var names []string
+	for {
+		org, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		names = append(names, org.GetName())
+	}
+	switch len(names) {
+	case 0:
+		return "", fmt.Errorf("no organization found for the domain %q", organization)
+	case 1:
+		return strings.TrimPrefix(names[0], "organizations/"), nil
+	default:
+		return "", fmt.Errorf("the domain %q is ambiguous and matches %s", organization, strings.Join(names, ", "))
+	}
+}
+
+// resolveProject returns the ID of a project that is given either by its ID or by its number.
+// Project IDs can't start with a digit, so anything numeric has to be a project number.
+func resolveProject(ctx context.Context, projectsClient *resourcemanager.ProjectsClient, project string) (string, error) {
+	project = strings.TrimPrefix(project, "projects/")
+	if !isNumeric(project) {
+		return project, nil
+	}
+	p, err := projectsClient.GetProject(ctx, &resourcemanagerpb.GetProjectRequest{
+		Name: "projects/" + project,
+	})
+	if err != nil {
+		return "", err
+	}
+	return p.GetProjectId(), nil
+}
+
+// listOrganizations returns the IDs of all organizations the caller has access to
+func listOrganizations(ctx context.Context, organizationsClient *resourcemanager.OrganizationsClient) ([]string, error) {
+	it := organizationsClient.SearchOrganizations(ctx, &resourcemanagerpb.SearchOrganizationsRequest{})
+	var ids []string
+	for {
+		org, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, strings.TrimPrefix(org.GetName(), "organizations/"))
+	}
+	return ids, nil
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	_ "modernc.org/sqlite"
+)
+
+// resultStore persists the result of each policy in a local SQLite database across runs.
+// Results are keyed by the policy name, the time the policy was last modified and the settings it was estimated with,
+// so that unchanged policies don't need to be estimated again as long as the settings stay the same.
+type resultStore struct {
+	db *sql.DB
+}
+
+func openResultStore(path string) (*resultStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only supports a single writer at a time, so we serialize all access through one connection
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS policies (
+		name TEXT PRIMARY KEY,
+		modified TEXT NOT NULL,
+		settings TEXT NOT NULL,
+		result TEXT NOT NULL,
+		error TEXT NOT NULL,
+		estimated TEXT NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &resultStore{db: db}, nil
+}
+
+// lookup returns the stored result of a policy if it was estimated without errors with the same settings and hasn't been modified since
+func (r *resultStore) lookup(alertPolicy *monitoringpb.AlertPolicy, settings string) (*policy, bool) {
+	var result string
+	err := r.db.QueryRow(`SELECT result FROM policies WHERE name = ? AND modified = ? AND settings = ? AND error = ''`,
+		alertPolicy.GetName(), lastModified(alertPolicy).Format(time.RFC3339Nano), settings).Scan(&result)
+	if err != nil {
+		return nil, false
+	}
+	p := &policy{}
+	if json.Unmarshal([]byte(result), p) != nil {
+		return nil, false
+	}
+	return p, true
+}
+
+// store saves the result of a policy that was estimated with settings, replacing any previous result
+func (r *resultStore) store(alertPolicy *monitoringpb.AlertPolicy, settings string, p *policy) error {
+	result, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(`INSERT OR REPLACE INTO policies (name, modified, settings, result, error, estimated) VALUES (?, ?, ?, ?, ?, ?)`,
+		p.Name, lastModified(alertPolicy).Format(time.RFC3339Nano), settings, string(result), p.Error, time.Now().Format(time.RFC3339))
+	return err
+}
+
+func (r *resultStore) close() error {
+	return r.db.Close()
+}
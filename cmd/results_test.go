@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestResultStore(t *testing.T) {
+	modified := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+	alertPolicy := func(mutated time.Time) *monitoringpb.AlertPolicy {
+		return &monitoringpb.AlertPolicy{
+			Name:           "projects/a/alertPolicies/1",
+			MutationRecord: &monitoringpb.MutationRecord{MutateTime: timestamppb.New(mutated)},
+		}
+	}
+	estimated := &policy{TimeSeries: 10, Conditions: 2, ProjectId: "a", Name: "projects/a/alertPolicies/1", DisplayName: "Latency", Price: 3.3024}
+	tests := []struct {
+		name     string
+		stored   *policy
+		policy   *monitoringpb.AlertPolicy
+		settings string
+		found    bool
+	}{
+		{name: "unchanged", stored: estimated, policy: alertPolicy(modified), settings: "12h", found: true},
+		{name: "modified", stored: estimated, policy: alertPolicy(modified.Add(time.Second)), settings: "12h"},
+		{name: "other settings", stored: estimated, policy: alertPolicy(modified), settings: "24h"},
+		{name: "failed", stored: &policy{Conditions: 2, ProjectId: "a", Name: "projects/a/alertPolicies/1", Error: "rpc error: code = Unavailable", Price: 3}, policy: alertPolicy(modified), settings: "12h"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := openResultStore(filepath.Join(t.TempDir(), "appe.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.close()
+			err = r.store(alertPolicy(modified), "12h", test.stored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, found := r.lookup(test.policy, test.settings)
+			if found != test.found {
+				t.Fatalf("found = %v, want %v", found, test.found)
+			}
+			if found && !reflect.DeepEqual(p, test.stored) {
+				t.Errorf("lookup = %+v, want %+v", p, test.stored)
+			}
+		})
+	}
+}
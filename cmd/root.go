@@ -2,21 +2,25 @@ package cmd
 
 import (
 	"context"
-	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"net/http"
+	_ "net/http/pprof"
 	"os"
-	"strconv"
+	"regexp"
+	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
 	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
-	resourcemanager "cloud.google.com/go/resourcemanager/apiv3"
 	"github.com/spf13/cobra"
-	monitoring_v1 "google.golang.org/api/monitoring/v1"
-	"google.golang.org/api/option"
+	"github.com/spf13/pflag"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -26,7 +30,6 @@ var rootCmd = &cobra.Command{
 	Use:     "appe",
 	Short:   "Alerting Policy Price Estimator",
 	Long:    `Scans for alerting policies in the specified projects, folder or orgs and approximates their cost by executing the queries defined in them against the monitoring API`,
-	Run:     func(cmd *cobra.Command, args []string) {},
 	Example: `To estimate the price for individual policies, you can reference them directly with the --policy flag:
 ./appe --policy projects/PROJECT_ID/alertPolicies/POLICY_ID
 You can also specify multiple policies:
@@ -49,6 +52,35 @@ You can also specify multiple organizations:
 Note that you will need to specify the --recursive or -r flag to also scan subfolders.`,
 }
 
+// loadFlags sets the flags that weren't given on the command line from environment variables or a config file, in that
+// order of precedence. It runs before cobra validates the flag groups, so that their values are validated like flags.
+func loadFlags(cmd *cobra.Command, args []string) error {
+	err := loadEnv(cmd.Flags())
+	if err != nil {
+		return fmt.Errorf("failed to load environment variables: %v", err)
+	}
+	// Cloud Run Jobs collect structured logs from stderr, so they are written in the format of Cloud Logging by default
+	if os.Getenv("CLOUD_RUN_JOB") != "" && !cmd.Flags().Changed("logFormat") {
+		err = cmd.Flags().Set("logFormat", "cloud")
+		if err != nil {
+			return err
+		}
+	}
+	configPath, err := cmd.Flags().GetString("config")
+	if err != nil {
+		return err
+	}
+	if configPath != "" {
+		err = loadConfig(cmd.Flags(), configPath, true)
+	} else {
+		err = loadConfig(cmd.Flags(), defaultConfigPath(), false)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load config file: %v", err)
+	}
+	return nil
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -56,129 +88,482 @@ func Execute() {
 	if err != nil {
 		os.Exit(1)
 	}
+	os.Exit(exitCode)
+}
 
-	// Parse flags
-	projects, err := rootCmd.Flags().GetStringSlice("project")
+// executeMu makes sure that only one estimate uses the flags at a time
+var executeMu sync.Mutex
+
+// Scope is what an estimate started with Estimate scans
+type Scope struct {
+	Projects      []string
+	Folders       []string
+	Organizations []string
+	// Policies are given by their full name, e.g. "projects/PROJECT_ID/alertPolicies/POLICY_ID"
+	Policies  []string
+	Recursive bool
+	// Duration is the time window to count time series in, or the default of --duration if it is 0
+	Duration time.Duration
+}
+
+// Estimate runs an estimate of scope and returns its exit code, or an error if it couldn't be completed, without exiting the process.
+// All other flags are read from APPE_* environment variables and the config file, e.g. for the output. Runs are processed one at a time.
+func Estimate(ctx context.Context, scope Scope) (int, error) {
+	executeMu.Lock()
+	defer executeMu.Unlock()
+	flags := rootCmd.Flags()
+	err := resetFlags(flags)
 	if err != nil {
-		log.Fatalln(err)
+		return 0, fmt.Errorf("failed to reset flags: %v", err)
 	}
-	folders, err := rootCmd.Flags().GetStringSlice("folder")
-	if err != nil {
-		log.Fatalln(err)
+	if slices.Contains(scope.Policies, "-") {
+		return 0, fmt.Errorf("policies must be given by their name")
 	}
-	organizations, err := rootCmd.Flags().GetStringSlice("organization")
-	if err != nil {
-		log.Fatalln(err)
+	// The scope is set like flags on the command line, so that it takes precedence over the environment and the config file
+	for flag, values := range map[string][]string{"project": scope.Projects, "folder": scope.Folders, "organization": scope.Organizations, "policy": scope.Policies} {
+		if len(values) > 0 {
+			err = flags.Set(flag, strings.Join(values, ","))
+			if err != nil {
+				return 0, err
+			}
+		}
 	}
-	csvOut, err := rootCmd.Flags().GetString("csvOut")
-	if err != nil {
-		log.Fatalln(err)
+	if scope.Recursive {
+		err = flags.Set("recursive", "true")
+		if err != nil {
+			return 0, err
+		}
 	}
-	threads, err := rootCmd.Flags().GetInt64("threads")
-	if err != nil {
-		log.Fatalln(err)
+	if scope.Duration > 0 {
+		err = flags.Set("duration", scope.Duration.String())
+		if err != nil {
+			return 0, err
+		}
 	}
-	recursive, err := rootCmd.Flags().GetBool("recursive")
+	err = loadFlags(rootCmd, nil)
 	if err != nil {
-		log.Fatalln(err)
+		return 0, err
 	}
-	testPermissions, err := rootCmd.Flags().GetBool("testPermissions")
+	err = rootCmd.ValidateFlagGroups()
 	if err != nil {
-		log.Fatalln(err)
+		return 0, err
 	}
-	includeDisabled, err := rootCmd.Flags().GetBool("includeDisabled")
-	if err != nil {
-		log.Fatalln(err)
+	if !hasTarget(flags) {
+		return 0, fmt.Errorf("one of projects, folders, organizations or policies is required")
 	}
-	summary, err := rootCmd.Flags().GetBool("summary")
+	o, err := parseRunOptions(flags)
 	if err != nil {
-		log.Fatalln(err)
+		return 0, err
 	}
-	quotaProject, err := rootCmd.Flags().GetString("quotaProject")
-	if err != nil {
-		log.Fatalln(err)
+	return estimate(ctx, o, flags)
+}
+
+// run scans the given projects, folders, organizations or policies and outputs the estimated price of each policy
+func run(cmd *cobra.Command, args []string) {
+	// First-time users are guided through a scan if they don't give any targets.
+	// Without a terminal, the project of the active gcloud configuration is scanned like other GCP CLIs do.
+	if !hasTarget(rootCmd.Flags()) {
+		var err error
+		if !isTerminal(os.Stdin) || !isTerminal(os.Stderr) {
+			project := gcloudProject()
+			if project == "" {
+				log.Fatalf("At least one of the flags in the group [%s] is required, or a project needs to be set in the active gcloud configuration", strings.Join(targetFlags, " "))
+			}
+			log.Printf("No target was given, scanning project %s of the active gcloud configuration\n", project)
+			err = rootCmd.Flags().Set("project", project)
+		} else {
+			err = runWizard(rootCmd.Flags())
+		}
+		if err != nil {
+			log.Fatalf("Failed to set up scan: %v", err)
+		}
 	}
-	duration, err := rootCmd.Flags().GetDuration("duration")
+
+	o, err := parseRunOptions(rootCmd.Flags())
 	if err != nil {
 		log.Fatalln(err)
 	}
-	excludedFolders, err := rootCmd.Flags().GetStringSlice("excludeFolder")
+	exitCode, err = estimate(context.Background(), o, rootCmd.Flags())
 	if err != nil {
 		log.Fatalln(err)
 	}
-	policies, err := rootCmd.Flags().GetStringSlice("policy")
+}
+
+// estimate runs the pipeline of a run with its options and returns its exit code, or an error if it couldn't be completed.
+// It doesn't exit the process, so that it can be used by other callers than the command line as well.
+func estimate(ctx context.Context, o *runOptions, flags *pflag.FlagSet) (code int, err error) {
+	// If a log file was given, all log messages are written to it as well as to stderr
+	var logFile io.Writer = io.Discard
+	if o.logFilePath != "" {
+		f, err := os.OpenFile(o.logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open log file: %v", err)
+		}
+		defer f.Close()
+		logFile = f
+	}
+	err = setupLogging(o.logFormat, o.verbosity, io.MultiWriter(os.Stderr, logFile))
 	if err != nil {
-		log.Fatalln(err)
+		return 0, err
 	}
 
 	// Set up re-usable variables
-	ctx := context.Background()
+	// If a deadline was given, the context is cancelled once it passes, which stops all threads from taking on more work
+	if o.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.deadline)
+		defer cancel()
+	}
+	// Once the run is interrupted with SIGINT or SIGTERM, or the deadline passed, no more work is taken on.
+	// Policies that are already being processed are still finished and output, unless the deadline passed.
+	stopCtx, stopWork := context.WithCancel(ctx)
+	defer stopWork()
+	interrupted, stopNotify := notifyInterrupt(stopWork)
+	defer stopNotify()
+	// Errors of the threads stop the run as well, and are returned once the policies that were already processed have been output
+	var failMu sync.Mutex
+	var failure error
+	fail := func(err error) {
+		failMu.Lock()
+		if failure == nil {
+			failure = err
+		}
+		failMu.Unlock()
+		stopWork()
+	}
+	failed := func() error {
+		failMu.Lock()
+		defer failMu.Unlock()
+		return failure
+	}
+
+	// If an OTLP endpoint was given, the whole run is traced
+	if o.otlpEndpoint != "" {
+		shutdown, err := setupTracing(ctx, o.otlpEndpoint, o.otlpInsecure)
+		if err != nil {
+			return 0, fmt.Errorf("failed to set up tracing: %v", err)
+		}
+		defer shutdown(context.Background())
+	}
+	ctx, span := tracer.Start(ctx, "appe")
+	defer span.End()
 	now := time.Now()
-	end := timestamppb.Now()
-	start := timestamppb.New(now.Add(-duration))
-	projectsIn := make(chan string, threads)
-	projectsTested := make(chan string, threads)
-	policiesIn := make(chan *monitoringpb.AlertPolicy, threads)
-	policiesOut := make(chan *policy, threads)
-	lenP := len(projects)
-	lenF := len(folders)
-	lenO := len(organizations)
-	lenPol := len(policies)
-
-	// Set up API clients
-	alertingPolicyClient, err := monitoring.NewAlertPolicyClient(ctx, option.WithQuotaProject(quotaProject))
-	if err != nil {
-		log.Fatalf("Failed to create alert policy client: %v", err)
+	// A replay runs at the time of the recording, so that it sends the same requests
+	var replay *replayServer
+	if o.replayDir != "" {
+		var recorded recordedRun
+		recorded, replay, err = readRecording(o.replayDir)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read recording: %v", err)
+		}
+		now = recorded.RunTime
+		slog.Info("Replaying the recording instead of using Google Cloud", "dir", o.replayDir, "runTime", now.Format(time.RFC3339))
 	}
-	queryClient, err := monitoring.NewQueryClient(ctx, option.WithQuotaProject(quotaProject))
-	if err != nil {
-		log.Fatalf("Failed to create query client: %v", err)
+	end := timestamppb.New(now)
+	start := timestamppb.New(now.Add(-o.duration))
+	duration := o.duration
+	// --start and --end select a fixed window instead of the duration before now, e.g. the week of a traffic peak
+	if o.windowStart != "" || o.windowEnd != "" {
+		start, end, err = absoluteWindow(o.windowStart, o.windowEnd, o.duration, now)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time window: %v", err)
+		}
+		duration = end.AsTime().Sub(start.AsTime())
 	}
-	metricClient, err := monitoring.NewMetricClient(ctx, option.WithQuotaProject(quotaProject))
-	if err != nil {
-		log.Fatalf("Failed to create metric client: %v", err)
+	probeStart := start
+	if o.probeWindow > 0 && o.probeWindow < duration {
+		probeStart = timestamppb.New(end.AsTime().Add(-o.probeWindow))
 	}
-	projectsClient, err := resourcemanager.NewProjectsClient(ctx, option.WithQuotaProject(quotaProject))
-	if err != nil {
-		log.Fatalf("Failed to create projects client: %v", err)
+	// The metadata of the run is logged at the start and written to the CSV file, so that results can be traced back to how they were produced
+	metadata := newRunMetadata(flags, rootCmd.Version, probeStart.AsTime(), end.AsTime())
+	// The Rego policies are compiled before anything is scanned, so that mistakes in them don't fail the run at its end
+	var gate *regoGate
+	if len(o.regoPolicies) > 0 {
+		gate, err = newRegoGate(ctx, o.regoPolicies, o.regoQuery, metadata.started)
+		if err != nil {
+			return 0, fmt.Errorf("invalid Rego policies: %v", err)
+		}
+	}
+	// The prices are compared to the baseline once the run is done, e.g. to fail deployments that raise the costs of alerting
+	var regressions *regressionGate
+	if o.runMaxIncrease != nil {
+		regressions = newRegressionGate(o.runBaseline, *o.runMaxIncrease)
+	}
+	if o.logFormat != "text" {
+		metadata.log(slog.LevelInfo)
+	} else {
+		metadata.log(slog.LevelDebug)
+	}
+	projectsIn := make(chan string, o.projectThreads)
+	projectsTested := make(chan string, o.listThreads)
+	policiesIn := make(chan *monitoringpb.AlertPolicy, o.queryThreads)
+	policiesOut := make(chan *policy, o.queryThreads)
+	filter := &policyFilter{
+		includeDisabled: o.includeDisabled,
+		onlyDisabled:    o.onlyDisabled,
+		excludedNames:   o.excludedPolicies,
+	}
+	if o.policyFilterExpr != "" {
+		filter.displayName, err = regexp.Compile(o.policyFilterExpr)
+		if err != nil {
+			return 0, fmt.Errorf("invalid policy filter: %v", err)
+		}
+	}
+	if o.excludePolicyFilterExpr != "" {
+		filter.excludedDisplay, err = regexp.Compile(o.excludePolicyFilterExpr)
+		if err != nil {
+			return 0, fmt.Errorf("invalid exclude policy filter: %v", err)
+		}
 	}
-	foldersClient, err := resourcemanager.NewFoldersClient(ctx, option.WithQuotaProject(quotaProject))
+	if o.modifiedSince != "" {
+		filter.modifiedSince, err = parseTime(o.modifiedSince)
+		if err != nil {
+			return 0, fmt.Errorf("invalid modifiedSince date: %v", err)
+		}
+	}
+	filter.labels, err = parseLabels(o.policyLabels)
 	if err != nil {
-		log.Fatalf("Failed to create folders client: %v", err)
+		return 0, fmt.Errorf("invalid policy label: %v", err)
+	}
+	var disabledProjects atomic.Int64
+	// Skipped and failed projects as well as failed policies are counted to report them at the end of the run
+	var skippedProjects, failedProjects atomic.Int64
+	failedPolicies := 0
+	runErrors := newErrorSummary()
+	var dryRunPolicies, dryRunQueries atomic.Int64
+	limit := &projectLimit{max: o.maxProjects}
+	var policySampler *sampler
+	if o.samplePolicies > 0 {
+		policySampler = newSampler(o.samplePolicies)
+	}
+	extrapolatedPrice := 0.0
+
+	// Failed calls are counted for the metrics of the run
+	selfMetrics := newRunMetrics()
+	// A recording captures the responses of all requests to Cloud Monitoring and Resource Manager, so that the run can be replayed with --replay
+	var recorder *apiRecorder
+	if o.recordDir != "" {
+		recorder, err = newAPIRecorder(o.recordDir, now, metadata.flags)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create recording: %v", err)
+		}
+		defer func() {
+			closeErr := recorder.close()
+			// An incomplete recording can't be replayed, so the run fails even if the estimate succeeded
+			if closeErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to write recording: %v", closeErr))
+				return
+			}
+			log.Printf("Recorded the responses of the run in %s\n", o.recordDir)
+		}()
 	}
-	monitoring_v1Service, err := monitoring_v1.NewService(ctx, option.WithQuotaProject(quotaProject))
+	allClients, err := newRunClients(ctx, o, selfMetrics, replay, recorder)
 	if err != nil {
-		log.Fatalf("Failed to create monitoring v1 client: %v", err)
+		return 0, fmt.Errorf("failed to set up clients: %v", err)
+	}
+	defer allClients.close()
+	router := allClients.router
+	var assets *policyAssets
+	if o.discovery == "asset" {
+		assets = newPolicyAssets()
+	}
+
+	// Estimates are only as good as the built-in prices, so users are warned if they may be outdated
+	warnStalePricing(time.Now())
+	if o.checkCatalog {
+		err = checkPricing(ctx, router.defaults.billing)
+		if err != nil {
+			slog.Warn("Failed to check the prices in the Cloud Billing Catalog", "error", err)
+		}
+	}
+
+	// Projects, folders and organizations may be given by their number, the path of their display names or their domain,
+	// so we need to resolve them to their IDs first
+	for i := range o.projects {
+		id, err := resolveProject(ctx, router.forTarget("projects/"+o.projects[i]).projects, o.projects[i])
+		if err != nil {
+			return 0, fmt.Errorf("failed to resolve project %s: %v", o.projects[i], err)
+		}
+		router.resolved("projects/"+o.projects[i], id)
+		o.projects[i] = id
+	}
+	for i := range o.folders {
+		id, err := resolveFolder(ctx, router.forTarget("folders/"+o.folders[i]).folders, o.folders[i])
+		if err != nil {
+			return 0, fmt.Errorf("failed to resolve folder %s: %v", o.folders[i], err)
+		}
+		router.resolved("folders/"+o.folders[i], id)
+		o.folders[i] = id
+	}
+	for i := range o.organizations {
+		id, err := resolveOrganization(ctx, router.forTarget("organizations/"+o.organizations[i]).organizations, o.organizations[i])
+		if err != nil {
+			return 0, fmt.Errorf("failed to resolve organization %s: %v", o.organizations[i], err)
+		}
+		router.resolved("organizations/"+o.organizations[i], id)
+		o.organizations[i] = id
+	}
+	for i := range o.excludedFolders {
+		id, err := resolveFolder(ctx, router.defaults.folders, o.excludedFolders[i])
+		if err != nil {
+			return 0, fmt.Errorf("failed to resolve excluded folder %s: %v", o.excludedFolders[i], err)
+		}
+		o.excludedFolders[i] = id
+	}
+
+	// If all organizations should be scanned, we look up every organization the caller has access to
+	if o.allOrganizations {
+		o.organizations, err = listOrganizations(ctx, router.defaults.organizations)
+		if err != nil {
+			return 0, fmt.Errorf("failed to search organizations: %v", err)
+		}
+		slog.Info("Found organizations to scan", "organizations", len(o.organizations))
+	}
+
+	// The policies managed by Terraform are read from its state files, so that their price can be attributed to their workspaces
+	var workspaces *workspaceReport
+	if len(o.terraformStates) > 0 {
+		workspaces = newWorkspaceReport()
+		for _, path := range o.terraformStates {
+			managed, err := readTerraformState(ctx, router.defaults.storage, path)
+			if err != nil {
+				return 0, fmt.Errorf("failed to read Terraform state %s: %v", path, err)
+			}
+			slog.Info("Read Terraform state", "state", path, "policies", len(managed))
+			workspaces.manage(path, managed)
+			o.policies = append(o.policies, managed...)
+		}
+	}
+
+	// Policies from local definitions don't exist yet, they are estimated as if they were created in the target project
+	definitions := policyDefinitions{}
+	for _, path := range o.grafanaRuleFiles {
+		before := len(definitions)
+		err = readGrafanaRules(path, o.targetProject, definitions)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read Grafana alert rules %s: %v", path, err)
+		}
+		slog.Info("Read Grafana alert rules", "file", path, "policies", len(definitions)-before)
+	}
+	for _, path := range o.kccManifests {
+		before := len(definitions)
+		err = readConfigConnectorManifests(path, o.targetProject, definitions)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read Config Connector manifests %s: %v", path, err)
+		}
+		slog.Info("Read Config Connector manifests", "file", path, "policies", len(definitions)-before)
+	}
+	for _, path := range o.datadogMonitors {
+		before := len(definitions)
+		err = readDatadogMonitors(path, o.targetProject, definitions)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read Datadog monitors %s: %v", path, err)
+		}
+		slog.Info("Read Datadog monitors", "file", path, "policies", len(definitions)-before)
+	}
+	for _, path := range o.pulumiPreviews {
+		before := len(definitions)
+		err = readPulumiPreview(path, o.targetProject, definitions)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read Pulumi preview %s: %v", path, err)
+		}
+		slog.Info("Read Pulumi preview", "file", path, "policies", len(definitions)-before)
+	}
+	o.policies = append(o.policies, definitions.names()...)
+
+	lenP := len(o.projects)
+	lenF := len(o.folders)
+	lenO := len(o.organizations)
+	lenPol := len(o.policies)
+	if lenP+lenF+lenO+lenPol == 0 && o.pubsubSubscription == "" {
+		return 0, fmt.Errorf("no projects, folders, organizations or policies to scan")
+	}
+
+	// If profiling was requested, we expose net/http/pprof on the given address for the duration of the run.
+	// Block and mutex profiling are enabled as well, as they show where threads spend their time waiting.
+	if o.pprofAddr != "" {
+		runtime.SetBlockProfileRate(1)
+		runtime.SetMutexProfileFraction(1)
+		go func() {
+			slog.Info("Serving pprof", "url", "http://"+o.pprofAddr+"/debug/pprof/")
+			err := http.ListenAndServe(o.pprofAddr, nil)
+			if err != nil {
+				slog.Error("Failed to serve pprof", "error", err)
+			}
+		}()
+	}
+
+	// When running in a terminal, we show a status line with the progress of the run
+	runProgress := newProgress()
+	// JSON logs are meant to be parsed, so they are never interrupted by the status line
+	if o.showProgress && o.logFormat == "text" && isTerminal(os.Stderr) {
+		log.SetOutput(io.MultiWriter(runProgress, logFile))
+		runProgress.start()
+		defer runProgress.stop()
+	}
+
+	// If a state file was given, the progress is persisted to it so that the run can be resumed if it is interrupted
+	var state *runState
+	if o.stateFile != "" {
+		state, err = openState(o.stateFile, o.resume)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open state file: %v", err)
+		}
+		defer state.close()
 	}
 
 	// If the application was executed with the --project or -p flag, put all the projects directly in the projects channel.
 	// Once done, we close the projects channel because we know there won't be any more projects coming in.
 	if lenP > 0 {
-		if lenP > int(threads) {
-			threads = int64(lenP)
+		if lenP > int(o.projectThreads) {
+			o.projectThreads = int64(lenP)
+		}
+		if lenP > int(o.listThreads) {
+			o.listThreads = int64(lenP)
 		}
 		go func() {
-			for i := range projects {
-				projectsIn <- projects[i]
+			for i := range o.projects {
+				projectsIn <- o.projects[i]
 			}
 			close(projectsIn)
 		}()
 	}
 
 	// If the application was executed with orgs or folders, we first list the parents under them.
+	// With Cloud Asset Inventory discovery, we instead list all policies under them directly and only put the projects they belong to on the projects channel.
 	// Once done, we close the projects channel because we know there won't be any more projects coming in.
 	if lenF > 0 {
 		go func() {
-			for i := range folders {
-				listProjects(ctx, projectsClient, foldersClient, "folders/"+folders[i], projectsIn, recursive, excludedFolders)
+			for i := range o.folders {
+				c := router.forTarget("folders/" + o.folders[i])
+				found, done := route(router, c, projectsIn, func(projectId string) string { return projectId })
+				if o.discovery == "asset" {
+					err := listAlertPolicyAssets(stopCtx, c.asset, c.projects, "folders/"+o.folders[i], found, assets, filter, o.recursive, o.excludedFolders, limit)
+					if err != nil {
+						failedProjects.Add(1)
+					}
+				} else {
+					listProjects(stopCtx, c.projects, c.folders, "folders/"+o.folders[i], found, o.recursive, o.excludedFolders, o.includeDeleteRequested, limit)
+				}
+				done()
 			}
 			close(projectsIn)
 		}()
 	}
 	if lenO > 0 {
 		go func() {
-			for i := range organizations {
-				listProjects(ctx, projectsClient, foldersClient, "organizations/"+organizations[i], projectsIn, recursive, excludedFolders)
+			for i := range o.organizations {
+				c := router.forTarget("organizations/" + o.organizations[i])
+				found, done := route(router, c, projectsIn, func(projectId string) string { return projectId })
+				if o.discovery == "asset" {
+					err := listAlertPolicyAssets(stopCtx, c.asset, c.projects, "organizations/"+o.organizations[i], found, assets, filter, o.recursive, o.excludedFolders, limit)
+					if err != nil {
+						failedProjects.Add(1)
+					}
+				} else {
+					listProjects(stopCtx, c.projects, c.folders, "organizations/"+o.organizations[i], found, o.recursive, o.excludedFolders, o.includeDeleteRequested, limit)
+				}
+				done()
 			}
 			close(projectsIn)
 		}()
@@ -188,57 +573,272 @@ func Execute() {
 	// We then put them directly on the policiesIn channel, which will be processes by threads that are spawned below.
 	// Finally, we will close the projectsIn channel once done, because the policiesIn channel will be closed automatically.
 	if lenPol > 0 {
-		if lenPol > int(threads) {
-			threads = int64(lenPol)
+		if lenPol > int(o.queryThreads) {
+			o.queryThreads = int64(lenPol)
 		}
 		go func() {
-			for i := range policies {
-				policy, err := alertingPolicyClient.GetAlertPolicy(ctx, &monitoringpb.GetAlertPolicyRequest{
-					Name: policies[i],
-				})
+			for i := range o.policies {
+				if stopCtx.Err() != nil {
+					break
+				}
+				var err error
+				policy, ok := definitions[o.policies[i]]
+				if !ok {
+					c := router.forProject(strings.Split(o.policies[i], "/")[1])
+					policy, err = c.alertPolicy.GetAlertPolicy(c.quota.context(ctx, strings.Split(o.policies[i], "/")[1]), &monitoringpb.GetAlertPolicyRequest{
+						Name: o.policies[i],
+					})
+				}
+				if stopCtx.Err() != nil {
+					break
+				}
 				if err != nil {
-					log.Fatal(err)
+					fail(fmt.Errorf("failed to get policy %s: %v", o.policies[i], err))
+					break
 				}
+				runProgress.policiesQueued.Add(1)
 				policiesIn <- policy
 			}
 			close(projectsIn)
 		}()
 	}
 
+	// In watch mode, the policies that a Cloud Asset Inventory feed reports as changed are estimated until the run is stopped
+	var watcher *policyWatcher
+	if o.pubsubSubscription != "" {
+		watcher = newPolicyWatcher(router.defaults.pubsub, o.pubsubSubscription)
+		go func() {
+			getPolicy := func(name string) (*monitoringpb.AlertPolicy, error) {
+				c := router.forProject(strings.Split(name, "/")[1])
+				return c.alertPolicy.GetAlertPolicy(c.quota.context(ctx, strings.Split(name, "/")[1]), &monitoringpb.GetAlertPolicyRequest{Name: name})
+			}
+			err := watcher.watch(stopCtx, getPolicy, policiesIn, func() { runProgress.policiesQueued.Add(1) })
+			if err != nil {
+				fail(fmt.Errorf("failed to watch policy changes: %v", err))
+			}
+			close(projectsIn)
+		}()
+	}
+
 	// We create a wait group with the number of threads to use for parallel processing of projects
 	// We then spawn the threads that will verify the permissions on the projects and put them in the projectsTested channel
+	// If metrics scopes should be expanded, the projects are first passed through threads that add the projects monitored by each of them.
+	// The channel is closed once all of those threads are done, because there won't be any more projects coming in.
+	projectsToTest := projectsIn
+	if o.expandMetricsScopes {
+		projectsExpanded := make(chan string, o.projectThreads)
+		var seen sync.Map
+		var wg0 sync.WaitGroup
+		wg0.Add(int(o.projectThreads))
+		for i := 0; i < int(o.projectThreads); i++ {
+			go func() {
+				for project := range projectsIn {
+					if stopCtx.Err() != nil {
+						continue
+					}
+					// Projects in the metrics scope of a project are scanned with the same credentials
+					c := router.forProject(project)
+					found, done := route(router, c, projectsExpanded, func(projectId string) string { return projectId })
+					expandMetricsScope(ctx, c.metricsScopes, c.projects, project, found, &seen)
+					done()
+				}
+				wg0.Done()
+			}()
+		}
+		go func() {
+			wg0.Wait()
+			close(projectsExpanded)
+		}()
+		projectsToTest = projectsExpanded
+	}
+
+	// Testing permissions is a single call per project, so these threads can use a higher concurrency than discovery
+	var permissionsChecked sync.Map
 	var wg1 sync.WaitGroup
-	wg1.Add(int(threads))
-	for i := 0; i < int(threads); i++ {
+	wg1.Add(int(o.permissionThreads))
+	for i := 0; i < int(o.permissionThreads); i++ {
 		go func() {
-			for project := range projectsIn {
-				verifyProjectPermissions(ctx, projectsClient, project, projectsTested, testPermissions)
+			for project := range projectsToTest {
+				if stopCtx.Err() != nil || !limit.take() {
+					continue
+				}
+				runProgress.projectsFound.Add(1)
+				verifyProjectPermissions(ctx, router.forProject(project).projects, project, projectsTested, o.testPermissions, &permissionsChecked, &skippedProjects)
 			}
 			wg1.Done()
 		}()
 	}
 
-	// We create a second wait group with the number of threads to use for parallel processing of projects
+	// The ingestion of chargeable metrics is estimated for every project that is listed, including projects without policies
+	var customMetrics *customMetricsReport
+	if o.metricIngestionOut != "" || o.unusedMetricsOut != "" {
+		customMetrics = newCustomMetricsReport(ctx, router, router.defaults.storage, start, end, o.metricIngestionOut, o.unusedMetricsOut, o.unusedMetricsDashboards)
+	}
+
+	// We create a second wait group with the number of threads to use for listing policies
 	// We then create the threads that will look for policies in the tested projects and put them in the policiesIn channel
 	var wg2 sync.WaitGroup
-	wg2.Add(int(threads))
-	for i := 0; i < int(threads); i++ {
+	wg2.Add(int(o.listThreads))
+	for i := 0; i < int(o.listThreads); i++ {
 		go func() {
 			for project := range projectsTested {
+				if stopCtx.Err() != nil {
+					continue
+				}
+				customMetrics.addProject(project)
 				// processAlertingPolicies(ctx, alertingPolicyClient, queryClient, metricClient, httpClient, project, start, end, parallelPolicies, policiesOut)
-				listAlertPolicies(ctx, project, includeDisabled, alertingPolicyClient, policiesIn)
+				// Projects found with Cloud Asset Inventory already come with their policies
+				alertPolicies, found := assets.take(project)
+				// Projects that were completed in a previous run don't need to be listed again, we just output their stored results
+				if state.isCompleted(project) {
+					for _, p := range state.storedPolicies(project) {
+						runProgress.policiesQueued.Add(1)
+						runProgress.policiesDone.Add(1)
+						policiesOut <- p
+					}
+					runProgress.projectsDone.Add(1)
+					continue
+				}
+				c := router.forProject(project)
+				var n int
+				var err error
+				if found {
+					n = queueAlertPolicies(project, alertPolicies, policiesIn, policySampler)
+				} else {
+					n, err = listAlertPolicies(c.quota.context(ctx, project), project, filter, c.alertPolicy, policiesIn, &disabledProjects, policySampler)
+				}
+				if err == nil {
+					err = state.listedPolicies(project, n)
+					if err != nil {
+						slog.Error("Failed to write state file", "error", err)
+					}
+					slog.Debug("Listed policies", "project", project, "policies", n)
+					if o.dryRun {
+						log.Printf("Project %s would be scanned with %d matching policies\n", project, n)
+					}
+				} else {
+					failedProjects.Add(1)
+				}
+				runProgress.policiesQueued.Add(int64(n))
+				runProgress.projectsDone.Add(1)
 			}
 			wg2.Done()
 		}()
 	}
 
+	// The limiter reduces the number of concurrent queries when we run into quota limits and raises it again afterwards.
+	// As each thread may process multiple conditions of a policy at once, it allows for that many queries per thread.
+	limiter := newAdaptiveLimiter(int(o.queryThreads * o.conditionThreads))
+
+	// If requested, the metrics of the run are served for Prometheus or written to Cloud Monitoring
+	selfMetrics.progress = runProgress
+	selfMetrics.limiter = limiter
+	if o.metricsAddr != "" {
+		selfMetrics.serve(o.metricsAddr)
+	}
+	if o.metricsProject != "" {
+		stopExport := selfMetrics.export(allClients.metrics, o.metricsProject)
+		defer stopExport()
+	}
+
+	// If a results database was given, every result is stored in it and can be reused for unchanged policies in later runs
+	var results *resultStore
+	if o.resultsDb != "" {
+		results, err = openResultStore(o.resultsDb)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open results database: %v", err)
+		}
+		defer results.close()
+	}
+
+	// If a cache directory was given, the number of time series of each query is cached across runs
+	var cache *countCache
+	if o.cacheDir != "" {
+		cache, err = newCountCache(o.cacheDir, o.cacheTTL)
+		if err != nil {
+			return 0, fmt.Errorf("failed to create cache directory: %v", err)
+		}
+	}
+
+	// All clients share the same settings to estimate policies
+	policyEstimator := estimator{
+		limiter:            limiter,
+		cache:              cache,
+		countStrategy:      o.countStrategy,
+		timeSeriesRate:     allClients.timeSeriesRate,
+		conditionThreads:   int(o.conditionThreads),
+		noQuery:            o.noQuery,
+		cardinalities:      o.cardinalities,
+		errors:             runErrors,
+		explain:            o.explain,
+		ingestion:          o.ingestion,
+		suggest:            o.suggestAggregations,
+		suggestGroupBy:     o.suggestGroupBy,
+		simulatedIntervals: o.simulatedIntervals,
+		highCardinality:    o.highCardinality,
+		hygiene:            o.hygiene,
+		disabledFor:        time.Duration(o.hygieneDisabledDays) * 24 * time.Hour,
+		start:              probeStart,
+		end:                end,
+		fixedWindow:        o.windowEnd != "",
+		promqlStep:         o.promqlStep,
+		assumeSeries:       o.assumeSeries,
+		slidingWindow:      o.pubsubSubscription != "" && o.windowEnd == "",
+	}
+	for _, c := range router.all() {
+		e := policyEstimator
+		e.queryClient = c.query
+		e.metricClient = c.metric
+		e.monitoring_v1Service = c.monitoring_v1
+		e.loggingService = c.logging
+		c.estimator = &e
+	}
+	settings := policyEstimator.settings()
+
+	// We create a third wait group with the number of threads to use for querying time series
 	// These threads will loop over the found policies and execute their queries to estimate their cost
 	var wg3 sync.WaitGroup
-	wg3.Add(int(threads))
-	for i := 0; i < int(threads); i++ {
+	wg3.Add(int(o.queryThreads))
+	for i := 0; i < int(o.queryThreads); i++ {
 		go func() {
 			for policy := range policiesIn {
-				processAlertPolicy(ctx, queryClient, metricClient, monitoring_v1Service, policy, start, end, policiesOut)
+				if stopCtx.Err() != nil {
+					continue
+				}
+				// In a dry run, we only print the queries instead of executing them
+				if o.dryRun {
+					dryRunQueries.Add(int64(printQueries(policy)))
+					dryRunPolicies.Add(1)
+					runProgress.policiesDone.Add(1)
+					continue
+				}
+				// Policies that were processed in a previous run don't need to be queried again
+				p, stored := state.storedPolicy(policy.GetName())
+				// Policies that haven't changed since they were last estimated with the same settings don't need to be queried again
+				cached := false
+				if !stored && o.cacheOnlyChanged {
+					p, cached = results.lookup(policy, settings)
+				}
+				if !stored && !cached {
+					c := router.forProject(getProjectId(policy))
+					p = c.estimator.processAlertPolicy(c.quota.context(ctx, getProjectId(policy)), policy)
+					// If the deadline passed while the policy was processed, its result is incomplete and we drop it
+					if ctx.Err() != nil {
+						continue
+					}
+					if results != nil {
+						err := results.store(policy, settings, p)
+						if err != nil {
+							slog.Error("Failed to store result", "policy", p.Name, "error", err)
+						}
+					}
+				}
+				err := state.processed(p, stored)
+				if err != nil {
+					slog.Error("Failed to write state file", "error", err)
+				}
+				runProgress.policiesDone.Add(1)
+				policiesOut <- p
 			}
 			wg3.Done()
 		}()
@@ -257,65 +857,248 @@ func Execute() {
 		close(policiesOut)
 	}()
 
-	// If the --csvOut flag was used, we create a CSV writer and write each policy as a line to the file
-	if csvOut != "" {
-		csvFile, err := os.Create(csvOut)
-		if err != nil {
-			log.Fatalf("Failed to create CSV file: %v", err)
+	// Every result is passed on to the sink as soon as it is available.
+	// If the --csvOut flag was used, we write each policy as a line to the file, otherwise the application will just output to stdout.
+	// The projects and folders of the policies are looked up once for all outputs that need them
+	paths := newFolderPaths(router)
+	out, err := newRunSink(ctx, o, flags, allClients, metadata, paths, customMetrics, gate, regressions, definitions)
+	if err != nil {
+		return 0, err
+	}
+	for policy := range policiesOut {
+		// Once a result couldn't be written, the remaining results are only received so that all threads can finish
+		if failed() != nil {
+			continue
 		}
-		defer csvFile.Close()
-		csvWriter := csv.NewWriter(csvFile)
-		err = csvWriter.Write([]string{"ProjectId", "Policy Name", "Link", "DisplayName", "Conditions", "Time Series", "Price", "Error"})
-		if err != nil {
-			log.Fatalln("Failed writing header to file", err)
+		extrapolatedPrice += policy.Price * policySampler.factor(policy.ProjectId)
+		if policy.Error != "" {
+			failedPolicies++
 		}
-		csvWriter.Flush()
-		for policy := range policiesOut {
-			err = csvWriter.Write([]string{policy.ProjectId, policy.Name, fmt.Sprintf("https://console.cloud.google.com/monitoring/alerting/policies/%s?project=%s", policy.Name[strings.LastIndex(policy.Name, "/")+1:], policy.ProjectId), policy.DisplayName, strconv.Itoa(policy.Conditions), strconv.Itoa(policy.TimeSeries), strconv.FormatFloat(policy.Price, 'f', 2, 64), policy.Error})
-			if err != nil {
-				log.Fatalln("Failed writing record to file", err)
-			}
-			csvWriter.Flush()
-		}
-		// Otherwise, the application will just output to stdout
-	} else if summary {
-		policiesSum := 0
-		conditionsSum := 0
-		timeSeriesSum := 0
-		priceSum := 0.0
-		for policy := range policiesOut {
-			policiesSum++
-			conditionsSum += policy.Conditions
-			timeSeriesSum += policy.TimeSeries
-			priceSum += policy.Price
-		}
-		log.Printf("Summary: You have %d policies with a combined total of %d conditions and %d time series. It will cost approximately $%f\n", policiesSum, conditionsSum, timeSeriesSum, priceSum)
-	} else {
-		for policy := range policiesOut {
-			log.Printf("Alerting Policy %s (%s) has %d condition(s) and %d time series. It will cost approximately $%f\n", policy.DisplayName, policy.Name, policy.Conditions, policy.TimeSeries, policy.Price)
+		workspaces.add(policy)
+		if o.projectNames {
+			policy.ProjectName = paths.project(ctx, policy.ProjectId).GetDisplayName()
+		}
+		if o.includeFolderPaths {
+			policy.FolderPath = paths.path(ctx, policy.ProjectId)
+		}
+		// The results of multiple organizations are told apart by the organization of their project
+		if len(o.organizations) > 1 {
+			policy.Organization = paths.organization(ctx, policy.ProjectId)
 		}
+		policy.Tags = o.tags
+		err = out.write(policy)
+		if err != nil {
+			fail(fmt.Errorf("failed writing result: %v", err))
+			continue
+		}
+		// Changes of policies are only acknowledged once their estimate was written, so that they are delivered again otherwise
+		watcher.written(ctx, policy.Name)
+	}
+	err = out.close(stopCtx.Err() != nil)
+	if err != nil {
+		err = fmt.Errorf("failed closing output: %v", err)
+	}
+	if err = errors.Join(failed(), err); err != nil {
+		return 0, err
+	}
+	if interrupted.Load() {
+		log.Println("The run was interrupted before all policies were processed, the results are incomplete")
+	} else if ctx.Err() != nil {
+		log.Printf("The deadline of %s was reached before all policies were processed, the results are incomplete\n", o.deadline)
+	}
+	if policySampler != nil {
+		log.Printf("Extrapolated from a sample of up to %d policies per project, all %d matching policies will cost approximately $%f\n", o.samplePolicies, policySampler.policies(), extrapolatedPrice)
 	}
+	workspaces.print()
+	gate.print()
+	regressions.print()
+	if o.dryRun {
+		log.Printf("Dry run: %d policies would be estimated with %d queries\n", dryRunPolicies.Load(), dryRunQueries.Load())
+	}
+	runErrors.print(skippedProjects.Load(), failedProjects.Load(), disabledProjects.Load(), failedPolicies)
+
+	// In strict mode, the exit code tells automation whether the results are complete and free of errors
+	if o.strict {
+		code = strictExitCode(failedPolicies > 0, skippedProjects.Load() > 0, stopCtx.Err() != nil || limit.exceeded() || failedProjects.Load() > 0)
+	} else if interrupted.Load() {
+		code = exitInterrupted
+	}
+	if gate.violated() && code != exitInterrupted {
+		code |= exitViolations
+	}
+	if regressions.violated() && code != exitInterrupted {
+		code |= exitRegressions
+	}
+	return code, nil
 }
 
 func init() {
+	rootCmd.PreRunE = loadFlags
+	rootCmd.Run = run
+	rootCmd.Flags().String("config", "", "Path to a config file with default values for flags in the format \"name = value\", one per line. Defaults to \"appe/config\" in your user config directory (e.g. ~/.config/appe/config) if it exists.")
+	rootCmd.Flags().StringSlice("targetCredentials", nil, "One or more credentials files to scan specific targets with in the format \"TARGET=PATH\", e.g. \"organizations/example.com=customer-a.json\". Targets are given as organizations/ORG, folders/FOLDER or projects/PROJECT with the same value as in --organization, --folder or --project. All other targets use --credentials. Separated by \",\".")
+	rootCmd.Flags().StringSlice("scopes", readOnlyScopes, "The OAuth scopes to request for all API calls. Only applies to service account and workload identity federation credentials. Separated by \",\".")
+	rootCmd.Flags().Bool("quotaPerProject", false, "If the quota of each scanned project should be used for its own requests instead of a single quota project. Falls back to the default for projects without the serviceusage.services.use permission. (default false)")
+	rootCmd.Flags().String("credentials", "", "Path to a service account key or workload identity federation configuration to use for all API calls. Defaults to the credentials stored by \"appe auth login\" if they exist, otherwise the application default credentials are used.")
 	rootCmd.Flags().StringP("quotaProject", "q", "", "A quota or billing project. Useful if you don't have the serviceusage.services.use permission in the target project.")
-	rootCmd.Flags().StringP("csvOut", "c", "", "Path to a CSV file to redirect output to. If this is not set, human-readable output will be given on stdout.")
-	rootCmd.Flags().StringSlice("policy", nil, "One or more alerting policies to analyze. Names must be given in full in the format \"projects/PROJECT_ID/alertPolicies/POLICY_ID\". Separated by \",\".")
-	rootCmd.Flags().StringSliceP("project", "p", nil, "One or more projects to scan. Separated by \",\".")
-	rootCmd.Flags().StringSliceP("folder", "f", nil, "One or more folders to scan. Use the \"-r\" flag to scan recursively. Separated by \",\".")
-	rootCmd.Flags().StringSliceP("organization", "o", nil, "One or more organizations to scan. Use the \"-r\" flag to scan recursively. Separated by \",\".")
-	rootCmd.Flags().StringSliceP("excludeFolder", "e", nil, "One or more folders to exclude. Separated by  \",\".")
+	rootCmd.Flags().StringP("csvOut", "c", "", "Path to a CSV file to redirect output to, or a GCS object as gs://BUCKET/OBJECT that is uploaded once the run completes. If this is not set, human-readable output will be given on stdout.")
+	rootCmd.Flags().Bool("csvMetadata", false, "If the CSV file should start with comment lines (starting with \"#\") that record the version of appe, the time of the run, the flags, the time window and the pricing version. (default false)")
+	rootCmd.Flags().StringSlice("policy", nil, "One or more alerting policies to analyze. Names must be given in full in the format \"projects/PROJECT_ID/alertPolicies/POLICY_ID\". Separated by \",\". Use \"-\" to read newline-separated names from stdin.")
+	rootCmd.Flags().StringSlice("terraformState", nil, "One or more Terraform state files to estimate all managed alerting policies of, either local paths or objects of a GCS backend as gs://BUCKET/OBJECT. The price is also summed up per workspace. Separated by \",\".")
+	rootCmd.Flags().StringSlice("grafanaRules", nil, "One or more files of provisioned or exported Grafana alert rules (YAML or JSON) to estimate as if they were migrated to Cloud Monitoring in --targetProject. Separated by \",\".")
+	rootCmd.Flags().StringSlice("kccManifests", nil, "One or more YAML files with MonitoringAlertPolicy resources of Config Connector to estimate as if they were created in --targetProject. Separated by \",\".")
+	rootCmd.Flags().StringSlice("datadogMonitors", nil, "One or more JSON exports of Datadog monitors to estimate as if they were migrated to Cloud Monitoring in --targetProject. Only metric monitors with simple queries are converted. Separated by \",\".")
+	rootCmd.Flags().StringSlice("pulumiPreview", nil, "One or more outputs of \"pulumi preview --json\" to estimate the alerting policies of that exist after the deployment. Separated by \",\".")
+	rootCmd.Flags().String("pubsubSubscription", "", "Pub/Sub subscription of a Cloud Asset Inventory feed on alerting policies, in the format projects/PROJECT/subscriptions/SUBSCRIPTION. Every created or changed policy is estimated until the run is stopped.")
+	rootCmd.Flags().String("targetProject", "", "Project to estimate policies from local definitions like --grafanaRules, --kccManifests or --datadogMonitors in. Their queries are run against the time series of this project. Policies from --pulumiPreview only use it if neither they nor the gcp:project config set a project.")
+	rootCmd.Flags().String("projectsFile", "", "Path to a file with newline-separated project IDs or policy names to analyze. Use \"-\" to read from stdin.")
+	rootCmd.Flags().StringSliceP("project", "p", nil, "One or more projects to scan, given by their ID or number. Separated by \",\".")
+	rootCmd.Flags().StringSliceP("folder", "f", nil, "One or more folders to scan, given by their ID or display name path (e.g. \"Production/Platform\"). Use the \"-r\" flag to scan recursively. Separated by \",\".")
+	rootCmd.Flags().StringSliceP("organization", "o", nil, "One or more organizations to scan, given by their ID or domain (e.g. \"example.com\"). Use the \"-r\" flag to scan recursively. Separated by \",\".")
+	rootCmd.Flags().Bool("allOrganizations", false, "If the application should scan all organizations the caller has access to. Use the \"-r\" flag to scan recursively. (default false)")
+	rootCmd.Flags().Bool("expandMetricsScopes", false, "If the projects monitored by the metrics scope of a scanned project should also be scanned. (default false)")
+	rootCmd.Flags().String("discovery", "api", "How to discover the policies in folders and organizations. \"api\" lists the projects and then the policies in each project, \"asset\" lists all policies at once with Cloud Asset Inventory.")
+	rootCmd.Flags().StringSliceP("excludeFolder", "e", nil, "One or more folders to exclude, given by their ID or display name path. Separated by  \",\".")
+	rootCmd.Flags().String("policyFilter", "", "A regular expression that the display name of a policy has to match in order to be processed, e.g. \"^\\[payments\\]\".")
+	rootCmd.Flags().StringSlice("policyLabel", nil, "One or more user labels in the format \"key=value\" that a policy needs to have in order to be processed. Separated by \",\".")
+	rootCmd.Flags().StringSlice("excludePolicy", nil, "One or more alerting policies to skip. Names must be given in full in the format \"projects/PROJECT_ID/alertPolicies/POLICY_ID\". Separated by \",\".")
+	rootCmd.Flags().String("excludePolicyFilter", "", "A regular expression for the display names of policies to skip.")
+	rootCmd.Flags().String("modifiedSince", "", "Only process policies that were created or modified since the given date (e.g. \"2024-01-01\") or RFC3339 timestamp.")
+	rootCmd.Flags().Int("samplePolicies", 0, "Only estimate a random sample of up to this many policies per project and extrapolate the total from them. Useful for a quick estimate of very large scopes.")
 	rootCmd.Flags().BoolP("testPermissions", "t", false, "If the application should verify that the user has the necessary permissions before processing a project. (default false)")
 	rootCmd.Flags().BoolP("includeDisabled", "i", false, "If the application should also include disabled policies. (default false)")
+	rootCmd.Flags().Bool("includeDeleteRequested", false, "If the application should also scan projects that are pending deletion (DELETE_REQUESTED). (default false)")
+	rootCmd.Flags().Bool("onlyDisabled", false, "If the application should only process disabled policies to estimate what it would cost to re-enable them. (default false)")
 	rootCmd.Flags().BoolP("summary", "s", false, "Whether the output should just be a summary (sum of all scanned policies) (default false)")
 	rootCmd.Flags().BoolP("recursive", "r", false, "If parent should be scanned recursively. If this is not set, only projects at the root of the folder or organization will be scanned. (default false)")
+	rootCmd.Flags().Int64("maxProjects", 0, "The maximum number of projects to process in a single run. 0 means no limit.")
 	rootCmd.Flags().Int64("threads", 4, "Number of threads to use to process folders, projects and policies in parallel.")
-	rootCmd.Flags().DurationP("duration", "d", 12*time.Hour, "The delta from now to go back in time for query. Default is 12 hours.")
-	rootCmd.MarkFlagsOneRequired("policy", "project", "folder", "organization")
+	rootCmd.Flags().Int64("projectThreads", 0, "Number of threads to use to discover projects. Defaults to the value of --threads.")
+	rootCmd.Flags().Int64("permissionThreads", 16, "Number of threads to use to verify permissions on projects when --testPermissions is set.")
+	rootCmd.Flags().Int64("listThreads", 0, "Number of threads to use to list policies in projects. Defaults to the value of --threads.")
+	rootCmd.Flags().Int64("queryThreads", 0, "Number of threads to use to query the time series of policies. Defaults to the value of --threads.")
+	rootCmd.Flags().Float64("qpsPolicies", 0, "The maximum number of requests per second to get and list alerting policies. 0 means no limit.")
+	rootCmd.Flags().Float64("qpsTimeSeries", 0, "The maximum number of requests per second to query time series. 0 means no limit.")
+	rootCmd.Flags().String("monitoringEndpoint", "", "A host or host:port to send all Cloud Monitoring requests to instead of monitoring.googleapis.com, e.g. a Private Service Connect endpoint.")
+	rootCmd.Flags().String("fake", "", "Path to a JSON file with organizations, folders, projects, policies and time series counts to answer all Cloud Monitoring and Resource Manager requests with locally instead of Google Cloud, or \"demo\" for built-in demo data. See the README for the format.")
+	rootCmd.Flags().String("record", "", "Directory to record the responses of all Cloud Monitoring and Resource Manager requests of the run in, to replay it with --replay")
+	rootCmd.Flags().String("replay", "", "Directory of a recording made with --record to answer all Cloud Monitoring and Resource Manager requests from instead of Google Cloud. The run must use the same flags as the recording.")
+	rootCmd.Flags().String("resourcemanagerEndpoint", "", "A host or host:port to send all Resource Manager requests to instead of cloudresourcemanager.googleapis.com, e.g. a Private Service Connect endpoint.")
+	rootCmd.Flags().String("proxy", "", "URL of an HTTP(S) proxy to send all requests through, e.g. \"http://proxy.example.com:3128\". Overrides the HTTPS_PROXY and HTTP_PROXY environment variables.")
+	rootCmd.Flags().StringSlice("noProxy", nil, "One or more hosts to connect to directly instead of through --proxy. Overrides the NO_PROXY environment variable. Separated by \",\".")
+	rootCmd.Flags().Int("grpcPoolSize", 0, "Number of gRPC connections each monitoring client opens. Raise this if you use a lot of threads. 0 uses the client library's default.")
+	rootCmd.Flags().Duration("grpcKeepalive", 0, "How often to send keepalive pings on idle gRPC connections of the monitoring clients. 0 disables keepalive pings.")
+	rootCmd.Flags().Duration("grpcKeepaliveTimeout", 20*time.Second, "How long to wait for a response to a keepalive ping before closing the connection.")
+	rootCmd.Flags().Int64("conditionThreads", 4, "Number of conditions of a single policy that each query thread processes in parallel.")
+	rootCmd.Flags().DurationP("duration", "d", 12*time.Hour, "The delta from now, or from --end, to go back in time for query. Default is 12 hours.")
+	rootCmd.Flags().String("start", "", "The start of a fixed time window to query instead of --duration before now, as an RFC3339 timestamp (e.g. \"2024-11-25T00:00:00Z\") or a date. Without --end, the window ends now.")
+	rootCmd.Flags().String("end", "", "The end of a fixed time window to query, as an RFC3339 timestamp or a date. Without --start, the window starts --duration before it.")
+	rootCmd.Flags().String("countStrategy", "list", "How to count the time series of threshold and absence conditions. \"list\" lists all of them, \"reduce\" lets the API count them, which is a lot faster but may be less accurate.")
+	rootCmd.Flags().Duration("deadline", 0, "The maximum duration of the run. Once it passes, all remaining work is cancelled and only the results gathered until then are output. 0 means no deadline.")
+	rootCmd.Flags().String("otlpEndpoint", "", "A host:port of an OTLP gRPC endpoint (e.g. \"localhost:4317\" for a local OpenTelemetry Collector) to export traces of project discovery, policy listing and every condition query to.")
+	rootCmd.Flags().Bool("otlpInsecure", false, "If the connection to --otlpEndpoint should not use TLS. (default false)")
+	rootCmd.Flags().String("metricsAddr", "", "An address (e.g. \":9090\") to serve metrics about the run on under /metrics for Prometheus, such as the number of projects and policies processed per second, API errors and retries.")
+	rootCmd.Flags().String("costMetricsProject", "", "A project to write the estimated monthly price of every policy to as the custom metric custom.googleapis.com/appe/policy_cost, labeled by the project and name of the policy.")
+	rootCmd.Flags().String("metricsProject", "", "A project to write metrics about the run to every minute as custom metrics under custom.googleapis.com/appe/.")
+	rootCmd.Flags().String("pprof", "", "An address (e.g. \":6060\") to serve net/http/pprof on during the run, to profile where time is spent.")
+	rootCmd.Flags().CountP("verbose", "v", "Print more details. -v prints what happens with each project, -vv also prints every query and the raw errors of failed conditions.")
+	rootCmd.Flags().String("logFile", "", "Path to a file to append all log messages to in addition to stderr, e.g. to keep a full log of errors while piping the results.")
+	rootCmd.Flags().String("logFormat", "text", "The format of log messages. \"text\" is human-readable, \"json\" writes one JSON object per line that can be parsed by log processors, \"cloud\" uses the field names of Cloud Logging for JSON. Defaults to \"cloud\" in Cloud Run Jobs.")
+	rootCmd.Flags().Bool("githubActions", false, "If the results should additionally be output as GitHub Actions annotations for policies above --warnAbove or with errors, and as a Markdown table in the job summary. (default false)")
+	rootCmd.Flags().Float64("warnAbove", 0, "The monthly price in USD above which a policy is reported as a warning with --githubActions or --sarifOut, or recommended for review with --recommendationsOut.")
+	rootCmd.Flags().String("metricIngestionOut", "", "Path to a CSV file, or a GCS object as gs://BUCKET/OBJECT, to write the estimated monthly ingestion of custom, external, workload and log-based metrics of every scanned project to, next to the price of its alerting policies.")
+	rootCmd.Flags().String("unusedMetricsOut", "", "Path to a CSV file, or a GCS object as gs://BUCKET/OBJECT, to write the custom, external, workload and log-based metrics of the scanned projects to that are ingested but not referenced by any scanned alerting policy, as candidates for deletion.")
+	rootCmd.Flags().Bool("unusedMetricsDashboards", false, "If metrics that are shown on a dashboard of a scanned project should not be reported by --unusedMetricsOut either. (default false)")
+	rootCmd.Flags().String("overlapsOut", "", "Path to a CSV file, or a GCS object as gs://BUCKET/OBJECT, to write the pairs of policies in the same project to whose conditions query identical or overlapping time series, with their combined price.")
+	rootCmd.Flags().Bool("overlapsAcrossProjects", false, "If --overlapsOut should also compare the policies of different projects. (default false)")
+	rootCmd.Flags().String("recommendationsOut", "", "Path to a JSON file, or a GCS object as gs://BUCKET/OBJECT, to write optimization findings to in the format of a ListRecommendations response of the Recommender API.")
+	rootCmd.Flags().String("sarifOut", "", "Path to a SARIF file, or a GCS object as gs://BUCKET/OBJECT, to write policies above --warnAbove and failed estimates to as findings, e.g. for GitHub code scanning.")
+	rootCmd.Flags().String("billingAccount", "", "ID of a billing account whose Cloud Billing budgets for Cloud Monitoring the estimate should be compared to, with a warning for budgets it would exceed.")
+	rootCmd.Flags().String("lookerStudioOut", "", "Path to a CSV file, or a GCS object as gs://BUCKET/OBJECT, to write a denormalized table for Looker Studio to, with the folder path, labels and price components of each policy.")
+	rootCmd.Flags().String("bigQueryTable", "", "A BigQuery table in the format PROJECT.DATASET.TABLE to append the results of the run to, with a run ID and time. The table is created partitioned by day if it doesn't exist. See \"appe history\".")
+	rootCmd.Flags().String("pubsubTopic", "", "Pub/Sub topic to publish every estimate to as JSON, in the format projects/PROJECT/topics/TOPIC.")
+	rootCmd.Flags().String("firestoreCollection", "", "Firestore collection to upsert the latest estimate of every policy into, in the format projects/PROJECT/databases/DATABASE/documents/COLLECTION. Each policy has a document whose ID is its escaped name.")
+	rootCmd.Flags().String("webhook", "", "URL to POST the results to as JSON. See --webhookBatch and --webhookSecret.")
+	rootCmd.Flags().Int("webhookBatch", 100, "Number of results to post to --webhook at once. The last request of a run is marked as final. Use 0 to post all results at the end of the run.")
+	rootCmd.Flags().String("webhookSecret", "", "Secret to sign the requests to --webhook with. The HMAC-SHA256 of the body is sent in the X-Appe-Signature header as \"sha256=HEX\".")
+	rootCmd.Flags().String("slackWebhook", "", "URL of a Slack incoming webhook to post a summary with the total price and the most expensive policies to once the run completes.")
+	rootCmd.Flags().String("chatWebhook", "", "URL of a Google Chat webhook to post a card with the total price and links to the most expensive policies to once the run completes.")
+	rootCmd.Flags().String("baseline", "", "Path to a CSV file of a previous run (written with --csvOut), or a JSON file if it ends with .json (written with --baselineOut), to compare the prices to in notifications and with --maxIncrease.")
+	rootCmd.Flags().String("baselineOut", "", "Path to a JSON file, or a GCS object as gs://BUCKET/OBJECT, to write the results to for later runs to use as their --baseline. It isn't written if the run is stopped before all policies are processed.")
+	rootCmd.Flags().String("maxIncrease", "", "The allowed increase of the total price and of the price of each policy over the --baseline, as a percentage (e.g. \"10%\") or an amount in USD (e.g. \"5\"). Any larger increase adds 32 to the exit code. New policies are only checked against amounts.")
+	rootCmd.Flags().String("annotate", "", "Write the estimate of every policy into its user label \"appe-monthly-cost\", e.g. \"12usd_2026-01-31\". \"preview\" only lists the labels that would change, \"apply\" updates the policies.")
+	rootCmd.Flags().StringSlice("regoPolicy", nil, "One or more Rego files or directories to evaluate against the results once the run is done. The input has the fields runTime, partial, total and policies. Any violation adds 16 to the exit code. Separated by \",\".")
+	rootCmd.Flags().String("regoQuery", "data.appe.deny", "The Rego query whose values are the violations of --regoPolicy, usually a set of messages.")
+	rootCmd.Flags().Bool("checkPricing", false, "If the built-in prices should be compared to the alerting prices in the Cloud Billing Catalog before the run, with a warning if they differ. (default false)")
+	rootCmd.Flags().Bool("suggestAggregations", false, "If the application should additionally count the time series of threshold conditions without a cross-series reducer with a suggested aggregation, which keeps the labels of --suggestGroupBy, and report the potential saving. Adds the columns \"Suggested Time Series\" and \"Potential Saving\" to the CSV file. (default false)")
+	rootCmd.Flags().StringSlice("suggestGroupBy", []string{}, "The labels that the suggested aggregations of --suggestAggregations keep, e.g. resource.label.zone. By default, all time series of a condition are reduced to one.")
+	rootCmd.Flags().Bool("projectNames", false, "If the display name of the project of each policy should be looked up and included in the outputs. Adds the column \"Project Name\" to the CSV file. (default false)")
+	rootCmd.Flags().Bool("folderPaths", false, "If the folder path of the project of each policy, e.g. \"example.com > Prod > Payments\", should be looked up and included in the outputs. Adds the column \"Folder Path\" to the CSV file. (default false)")
+	rootCmd.Flags().String("snapshotBucket", "", "A GCS bucket given as gs://BUCKET or gs://BUCKET/PREFIX to store the results of every run in and to compare them to the latest earlier snapshot of the same targets.")
+	rootCmd.Flags().StringSlice("tag", nil, "One or more tags in the format \"key=value\" to attach to every result of the run, e.g. the release version or environment. They are added as columns to --csvOut and included in --lookerStudioOut, --bigQueryTable, --firestoreCollection, --sarifOut, the JSON results and the snapshots. Separated by \",\".")
+	rootCmd.Flags().String("chargebackKey", "", "The label to sum up the estimates by once the run completes, e.g. team. The label is looked up on the policy and otherwise on its project, policy:LABEL or project:LABEL only looks it up on one of them.")
+	rootCmd.Flags().String("chargebackOut", "", "Path to a CSV file, or a GCS object as gs://BUCKET/OBJECT, to additionally write the sums of --chargebackKey to.")
+	rootCmd.Flags().Bool("hygiene", false, "If the application should additionally check the hygiene of the policies and report policies without notification channels, documentation or severity, or disabled for longer than --hygieneDisabledDays, along with their price. Adds the column \"Hygiene\" to the CSV file. (default false)")
+	rootCmd.Flags().Int("hygieneDisabledDays", 30, "The number of days since its last change after which a disabled policy is reported by --hygiene.")
+	rootCmd.Flags().Int("highCardinality", 10000, "The number of time series above which a condition is reported with a warning, as it usually has a misconfigured filter. If set explicitly, adds the column \"High Cardinality\" to the CSV file. 0 disables the warnings.")
+	rootCmd.Flags().Int("assumeSeries", 0, "The number of time series to price conditions with whose query fails, e.g. for missing permissions or quota, instead of only their base price. The conditions are reported with a warning and in the column \"Assumed Series\" of the CSV file. 0 disables it.")
+	rootCmd.Flags().StringSlice("simulateInterval", []string{}, "Hypothetical evaluation intervals to additionally calculate the price of all policies at and report the delta to the current price, e.g. 300s for all conditions or promql=60s,threshold=120s for conditions of a kind (threshold, absence, mql or promql). Adds the columns \"Simulated Price\" and \"Simulated Delta\" to the CSV file.")
+	rootCmd.Flags().Bool("ingestion", false, "If the application should additionally estimate the monthly price of ingesting the samples of the metrics that PromQL conditions select into Managed Service for Prometheus, based on the samples of the last hour, and of ingesting the log entries that log-based metrics of threshold and absence conditions count into Cloud Logging, based on the time window. Adds the column \"Ingestion Price\" to the CSV file. (default false)")
+	rootCmd.Flags().Bool("explain", false, "If the application should print how the price of each policy was calculated from the fee of its conditions and the number of time series of each condition. (default false)")
+	rootCmd.Flags().Bool("dryRun", false, "If the application should only list the projects and the queries of the policies that would be estimated, without executing the queries. (default false)")
+	rootCmd.Flags().Bool("strict", false, "If the application should exit with a non-zero exit code when policies failed, projects were skipped for missing permissions or the scan was incomplete. See the README for the exit codes. (default false)")
+	rootCmd.Flags().Bool("progress", true, "If a status line with the progress of the run and the estimated remaining time should be shown when running in a terminal.")
+	rootCmd.Flags().String("cacheDir", "", "Path to a directory to cache the number of time series of each query in across runs. If this is not set, nothing is cached.")
+	rootCmd.Flags().Duration("cacheTTL", 24*time.Hour, "How long cached time series counts are valid for.")
+	rootCmd.Flags().String("stateFile", "", "Path to a file to persist the progress of the run to. Use it with --resume to continue an interrupted run.")
+	rootCmd.Flags().Bool("resume", false, "If the application should continue the run persisted in the file given with --stateFile instead of starting from scratch. (default false)")
+	rootCmd.Flags().Bool("noQuery", false, "If the time series of threshold and absence conditions should be estimated from metric and resource descriptors instead of querying them. Less accurate, but needs a lot less quota. MQL and PromQL conditions are not supported. (default false)")
+	rootCmd.Flags().StringSlice("labelCardinality", []string{}, "One or more assumed numbers of distinct values of a label in the format \"label=count\" (e.g. \"resource.label.zone=3\") for --noQuery. Use \"*\" as label to change the default of 1. Separated by \",\".")
+	rootCmd.Flags().Duration("probeWindow", 0, "A shorter window than --duration to count the time series of threshold, absence and PromQL conditions in. Scans a lot less data, but misses time series that only existed earlier. 0 means the whole duration is used.")
+	rootCmd.Flags().Duration("promqlStep", 0, "The step of the range queries that count the time series of PromQL conditions, instead of their evaluation interval. A larger step, e.g. 1h, returns a lot less data over long windows, but may miss time series that only existed between two steps. 0 means the evaluation interval is used.")
+	rootCmd.Flags().String("resultsDb", "", "Path to a SQLite database to store the result of each policy in across runs.")
+	rootCmd.Flags().Bool("cacheOnlyChanged", false, "If the application should only estimate policies that were modified since their result was stored in --resultsDb and reuse the stored results for all others. (default false)")
+	rootCmd.MarkFlagsMutuallyExclusive("fake", "credentials")
+	rootCmd.MarkFlagsMutuallyExclusive("fake", "targetCredentials")
+	rootCmd.MarkFlagsMutuallyExclusive("fake", "monitoringEndpoint")
+	rootCmd.MarkFlagsMutuallyExclusive("fake", "resourcemanagerEndpoint")
+	rootCmd.MarkFlagsMutuallyExclusive("fake", "proxy")
+	rootCmd.MarkFlagsMutuallyExclusive("replay", "record")
+	// The fake and the replay only answer the read requests of the run, so metrics would be written to Google Cloud instead
+	for _, name := range []string{"metricsProject", "costMetricsProject"} {
+		rootCmd.MarkFlagsMutuallyExclusive("fake", name)
+		rootCmd.MarkFlagsMutuallyExclusive("replay", name)
+	}
+	rootCmd.MarkFlagsMutuallyExclusive("replay", "fake")
+	rootCmd.MarkFlagsMutuallyExclusive("replay", "credentials")
+	rootCmd.MarkFlagsMutuallyExclusive("replay", "targetCredentials")
+	rootCmd.MarkFlagsMutuallyExclusive("replay", "monitoringEndpoint")
+	rootCmd.MarkFlagsMutuallyExclusive("replay", "resourcemanagerEndpoint")
+	rootCmd.MarkFlagsMutuallyExclusive("replay", "proxy")
+	rootCmd.MarkFlagsMutuallyExclusive("replay", "cacheDir")
+	rootCmd.MarkFlagsMutuallyExclusive("record", "cacheDir")
 	rootCmd.MarkFlagsMutuallyExclusive("policy", "project", "recursive")
 	rootCmd.MarkFlagsMutuallyExclusive("policy", "testPermissions")
 	rootCmd.MarkFlagsMutuallyExclusive("policy", "includeDisabled")
+	rootCmd.MarkFlagsMutuallyExclusive("policy", "includeDisabled", "onlyDisabled")
+	rootCmd.MarkFlagsMutuallyExclusive("policy", "policyFilter")
+	rootCmd.MarkFlagsMutuallyExclusive("policy", "policyLabel")
+	rootCmd.MarkFlagsMutuallyExclusive("policy", "excludePolicy")
+	rootCmd.MarkFlagsMutuallyExclusive("policy", "excludePolicyFilter")
+	rootCmd.MarkFlagsMutuallyExclusive("policy", "modifiedSince")
+	rootCmd.MarkFlagsMutuallyExclusive("start", "duration")
+	rootCmd.MarkFlagsMutuallyExclusive("policy", "samplePolicies")
+	rootCmd.MarkFlagsMutuallyExclusive("policy", "maxProjects")
 	rootCmd.MarkFlagsMutuallyExclusive("policy", "project", "excludeFolder")
-	rootCmd.MarkFlagsMutuallyExclusive("policy", "project", "folder", "organization")
+	rootCmd.MarkFlagsMutuallyExclusive("policy", "project", "includeDeleteRequested")
+	rootCmd.MarkFlagsMutuallyExclusive("policy", "expandMetricsScopes")
+	rootCmd.MarkFlagsMutuallyExclusive("policy", "project", "discovery")
+	rootCmd.MarkFlagsMutuallyExclusive("policy", "project", "folder", "organization", "projectsFile", "allOrganizations", "terraformState", "grafanaRules", "kccManifests", "datadogMonitors", "pulumiPreview", "pubsubSubscription")
 	rootCmd.MarkFlagsMutuallyExclusive("csvOut", "summary")
+	rootCmd.MarkFlagsMutuallyExclusive("dryRun", "csvOut")
+	rootCmd.MarkFlagsMutuallyExclusive("dryRun", "summary")
+	rootCmd.MarkFlagsMutuallyExclusive("dryRun", "annotate")
+	rootCmd.MarkFlagsMutuallyExclusive("noQuery", "ingestion")
+	rootCmd.MarkFlagsRequiredTogether("resume", "stateFile")
+	rootCmd.MarkFlagsMutuallyExclusive("quotaProject", "quotaPerProject")
 }
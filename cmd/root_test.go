@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// thresholdPolicy returns a policy in the format of the fake with a threshold condition for each filter
+func thresholdPolicy(name string, filters ...string) json.RawMessage {
+	var conditions []string
+	for i, filter := range filters {
+		conditions = append(conditions, fmt.Sprintf(`{"displayName": "Condition %d", "conditionThreshold": {"filter": %q, "comparison": "COMPARISON_GT", "duration": "60s"}}`, i, filter))
+	}
+	return json.RawMessage(fmt.Sprintf(`{"name": %q, "displayName": %q, "enabled": true, "conditions": [%s]}`, name, name, strings.Join(conditions, ", ")))
+}
+
+// estimateFake runs the pipeline with args against a fake with state and returns the fake, the rows of the CSV file by policy name and the exit code
+func estimateFake(t *testing.T, state fakeState, args ...string) (*fakeServer, map[string][]string, int, error) {
+	t.Helper()
+	dir := t.TempDir()
+	b, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakePath, csvPath := filepath.Join(dir, "fake.json"), filepath.Join(dir, "out.csv")
+	err = os.WriteFile(fakePath, b, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := rootCmd.Flags()
+	t.Cleanup(func() { resetFlags(flags) })
+	err = resetFlags(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = flags.Parse(append([]string{"--fake", fakePath, "--csvOut", csvPath}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := parseRunOptions(flags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := estimate(context.Background(), o, flags)
+	if err != nil {
+		return o.fakeServer, nil, code, err
+	}
+	f, err := os.Open(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := map[string][]string{}
+	for _, record := range records[1:] {
+		rows[record[1]] = record
+	}
+	return o.fakeServer, rows, code, nil
+}
+
+// fakeCalls returns how often the fake answered a gRPC method
+func fakeCalls(f *fakeServer, method string) int64 {
+	calls, ok := f.calls.Load(method)
+	if !ok {
+		return 0
+	}
+	return calls.(*atomic.Int64).Load()
+}
+
+func TestEstimatePrices(t *testing.T) {
+	state := fakeState{
+		Projects: []fakeResource{{Id: "app"}},
+		Policies: []json.RawMessage{
+			thresholdPolicy("projects/app/alertPolicies/1", `metric.type="a"`),
+			json.RawMessage(`{"name": "projects/app/alertPolicies/2", "displayName": "Two conditions", "enabled": true, "conditions": [
+				{"displayName": "Threshold", "conditionThreshold": {"filter": "metric.type=\"b\"", "comparison": "COMPARISON_GT", "duration": "60s"}},
+				{"displayName": "PromQL", "conditionPrometheusQueryLanguage": {"query": "up == 0", "evaluationInterval": "30s"}}]}`),
+			thresholdPolicy("projects/app/alertPolicies/3", `metric.type="c"`),
+			json.RawMessage(`{"name": "projects/app/alertPolicies/4", "displayName": "Disabled", "enabled": false, "conditions": [
+				{"displayName": "Threshold", "conditionThreshold": {"filter": "metric.type=\"a\"", "comparison": "COMPARISON_GT", "duration": "60s"}}]}`),
+		},
+		TimeSeries:        map[string]int{`metric.type="a"`: 10, `metric.type="b"`: 20, "up == 0": 5},
+		DefaultTimeSeries: 7,
+	}
+	_, rows, code, err := estimateFake(t, state, "--project", "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != 0 {
+		t.Errorf("exit code = %d, want 0", code)
+	}
+	// Every condition costs $1.50 and every time series $0.35 per million executions every 30 seconds for a month
+	want := map[string][2]string{
+		"projects/app/alertPolicies/1": {"10", "1.80"},
+		"projects/app/alertPolicies/2": {"25", "3.76"},
+		"projects/app/alertPolicies/3": {"7", "1.71"},
+	}
+	if len(rows) != len(want) {
+		t.Errorf("got %d policies, want %d", len(rows), len(want))
+	}
+	for name, w := range want {
+		row, ok := rows[name]
+		if !ok {
+			t.Errorf("policy %s is missing", name)
+			continue
+		}
+		if row[5] != w[0] || row[6] != w[1] || row[7] != "" {
+			t.Errorf("policy %s has %s time series for $%s with error %q, want %s for $%s", name, row[5], row[6], row[7], w[0], w[1])
+		}
+	}
+}
+
+func TestEstimateErrors(t *testing.T) {
+	state := fakeState{
+		Projects: []fakeResource{{Id: "app"}, {Id: "denied"}},
+		Policies: []json.RawMessage{
+			thresholdPolicy("projects/app/alertPolicies/1", `metric.type="a"`),
+			thresholdPolicy("projects/app/alertPolicies/2", `metric.type="broken"`),
+			thresholdPolicy("projects/denied/alertPolicies/3", `metric.type="a"`),
+		},
+		TimeSeries: map[string]int{`metric.type="a"`: 10},
+		Errors:     map[string]string{`metric.type="broken"`: "INVALID_ARGUMENT", "denied": "PERMISSION_DENIED"},
+	}
+	_, rows, code, err := estimateFake(t, state, "--project", "app,denied", "--strict")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := exitPolicyErrors | exitPartial; code != want {
+		t.Errorf("exit code = %d, want %d", code, want)
+	}
+	if row := rows["projects/app/alertPolicies/1"]; row == nil || row[6] != "1.80" || row[7] != "" {
+		t.Errorf("policy 1 = %v, want $1.80 without error", row)
+	}
+	if row := rows["projects/app/alertPolicies/2"]; row == nil || row[7] == "" {
+		t.Errorf("policy 2 = %v, want an error", row)
+	}
+	if _, ok := rows["projects/denied/alertPolicies/3"]; ok {
+		t.Errorf("policy 3 of a project that can't be listed was estimated")
+	}
+
+	// A policy that can't be fetched fails the run with an error instead of exiting
+	_, _, _, err = estimateFake(t, state, "--policy", "projects/app/alertPolicies/9")
+	if err == nil || !strings.Contains(err.Error(), "projects/app/alertPolicies/9") {
+		t.Errorf("error = %v, want the missing policy", err)
+	}
+}
+
+func TestEstimateConcurrency(t *testing.T) {
+	state := fakeState{
+		Projects:          []fakeResource{{Id: "app"}},
+		DefaultTimeSeries: 3,
+		Latency:           "10ms",
+	}
+	for i := range 20 {
+		state.Policies = append(state.Policies, thresholdPolicy(fmt.Sprintf("projects/app/alertPolicies/%d", i), fmt.Sprintf(`metric.type="%d/a"`, i), fmt.Sprintf(`metric.type="%d/b"`, i), fmt.Sprintf(`metric.type="%d/c"`, i)))
+	}
+	for _, threads := range []struct{ threads, conditionThreads int64 }{{1, 1}, {2, 3}, {4, 1}} {
+		t.Run(fmt.Sprintf("%dx%d", threads.threads, threads.conditionThreads), func(t *testing.T) {
+			f, rows, _, err := estimateFake(t, state, "--project", "app", "--threads", fmt.Sprint(threads.threads), "--conditionThreads", fmt.Sprint(threads.conditionThreads))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != 20 {
+				t.Errorf("got %d policies, want 20", len(rows))
+			}
+			if calls := fakeCalls(f, "/google.monitoring.v3.MetricService/ListTimeSeries"); calls != 60 {
+				t.Errorf("got %d time series requests, want one per condition", calls)
+			}
+			limit := threads.threads * threads.conditionThreads
+			if n := f.maxInFlight.Load(); n > limit {
+				t.Errorf("%d requests were in flight at once, want at most %d", n, limit)
+			}
+			if n := f.maxInFlight.Load(); limit > 1 && n < 2 {
+				t.Errorf("only %d request was in flight at once, want up to %d", n, limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"math/rand/v2"
+	"sync"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+)
+
+// sampler randomly selects up to n policies per project and keeps track of the number of policies in each project,
+// so that the results of the selected policies can be extrapolated to the whole project.
+type sampler struct {
+	n      int
+	mu     sync.Mutex
+	total  map[string]int
+	picked map[string]int
+}
+
+func newSampler(n int) *sampler {
+	return &sampler{
+		n:      n,
+		total:  map[string]int{},
+		picked: map[string]int{},
+	}
+}
+
+func (s *sampler) sample(projectId string, alertPolicies []*monitoringpb.AlertPolicy) []*monitoringpb.AlertPolicy {
+	total := len(alertPolicies)
+	if len(alertPolicies) > s.n {
+		rand.Shuffle(len(alertPolicies), func(i, j int) {
+			alertPolicies[i], alertPolicies[j] = alertPolicies[j], alertPolicies[i]
+		})
+		alertPolicies = alertPolicies[:s.n]
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.total[projectId] += total
+	s.picked[projectId] += len(alertPolicies)
+	return alertPolicies
+}
+
+// factor returns the factor by which the results of a project's sampled policies need to be multiplied.
+// It is safe to call on a nil sampler, in which case no extrapolation is done.
+func (s *sampler) factor(projectId string) float64 {
+	if s == nil {
+		return 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.picked[projectId] == 0 {
+		return 1
+	}
+	return float64(s.total[projectId]) / float64(s.picked[projectId])
+}
+
+// policies returns the total number of policies in all sampled projects
+func (s *sampler) policies() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sum := 0
+	for _, n := range s.total {
+		sum += n
+	}
+	return sum
+}
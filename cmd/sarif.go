@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+// sarifRules are the kinds of findings that are reported in SARIF
+var sarifRules = []sarifRule{
+	{
+		ID:                   "expensive-policy",
+		Name:                 "ExpensivePolicy",
+		ShortDescription:     sarifMessage{Text: "Expensive alerting policy"},
+		FullDescription:      sarifMessage{Text: "The alerting policy costs more per month than the threshold given with --warnAbove."},
+		HelpURI:              "https://cloud.google.com/stackdriver/pricing#alerting-pricing",
+		DefaultConfiguration: sarifConfiguration{Level: "warning"},
+	},
+	{
+		ID:                   "high-cardinality",
+		Name:                 "HighCardinality",
+		ShortDescription:     sarifMessage{Text: "High cardinality condition"},
+		FullDescription:      sarifMessage{Text: "A condition of the alerting policy matches more time series than the threshold given with --highCardinality, which usually means that its filter is misconfigured."},
+		DefaultConfiguration: sarifConfiguration{Level: "warning"},
+	},
+	{
+		ID:                   "policy-hygiene",
+		Name:                 "PolicyHygiene",
+		ShortDescription:     sarifMessage{Text: "Alerting policy hygiene"},
+		FullDescription:      sarifMessage{Text: "The alerting policy has no notification channels, no documentation or no severity, or has been disabled for longer than --hygieneDisabledDays."},
+		DefaultConfiguration: sarifConfiguration{Level: "note"},
+	},
+	{
+		ID:                   "estimate-failed",
+		Name:                 "EstimateFailed",
+		ShortDescription:     sarifMessage{Text: "Failed to estimate alerting policy"},
+		FullDescription:      sarifMessage{Text: "The price of the alerting policy couldn't be estimated, e.g. because a query of one of its conditions failed."},
+		DefaultConfiguration: sarifConfiguration{Level: "error"},
+	},
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver struct {
+		Name           string      `json:"name"`
+		Version        string      `json:"version"`
+		InformationURI string      `json:"informationUri"`
+		Rules          []sarifRule `json:"rules"`
+	} `json:"driver"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	FullDescription      sarifMessage       `json:"fullDescription"`
+	HelpURI              string             `json:"helpUri,omitempty"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID              string            `json:"ruleId"`
+	Level               string            `json:"level"`
+	Message             sarifMessage      `json:"message"`
+	Locations           []sarifLocation   `json:"locations"`
+	PartialFingerprints map[string]string `json:"partialFingerprints"`
+	Properties          map[string]any    `json:"properties"`
+}
+
+// sarifLocation points to the policy. Policies aren't files, so their name is used as the URI of the artifact.
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+	} `json:"physicalLocation"`
+	LogicalLocations []struct {
+		FullyQualifiedName string `json:"fullyQualifiedName"`
+		Kind               string `json:"kind"`
+	} `json:"logicalLocations"`
+}
+
+// sarifSink writes findings about the policies (expensive policies and failed estimates) to a SARIF file once the run is done,
+// so that they appear in code scanning tools like GitHub code scanning
+type sarifSink struct {
+	path      string
+	storage   *storage.Service
+	version   string
+	warnAbove float64
+	results   []sarifResult
+}
+
+func newSarifSink(path string, storageService *storage.Service, version string, warnAbove float64) *sarifSink {
+	return &sarifSink{path: path, storage: storageService, version: version, warnAbove: warnAbove, results: []sarifResult{}}
+}
+
+func (s *sarifSink) write(p *policy) error {
+	if p.Error != "" {
+		s.add(p, "estimate-failed", "error", fmt.Sprintf("Failed to estimate %s (%s): %s", p.DisplayName, p.Name, p.Error))
+	}
+	if p.Price > s.warnAbove {
+		s.add(p, "expensive-policy", "warning", fmt.Sprintf("%s (%s) has %d condition(s) and %d time series. It will cost approximately $%.2f per month", p.DisplayName, p.Name, p.Conditions, p.TimeSeries, p.Price))
+	}
+	if len(p.Hygiene) > 0 {
+		s.add(p, "policy-hygiene", "note", fmt.Sprintf("%s (%s) costs approximately $%.2f per month and has hygiene findings: %s", p.DisplayName, p.Name, p.Price, strings.Join(p.Hygiene, ", ")))
+	}
+	for _, condition := range p.HighCardinality {
+		s.add(p, "high-cardinality", "warning", fmt.Sprintf("Condition %s of %s (%s) has a high cardinality", condition, p.DisplayName, p.Name))
+	}
+	return nil
+}
+
+// add adds a finding about p
+func (s *sarifSink) add(p *policy, ruleId string, level string, message string) {
+	var location sarifLocation
+	location.PhysicalLocation.ArtifactLocation.URI = p.Name
+	location.LogicalLocations = append(location.LogicalLocations, struct {
+		FullyQualifiedName string `json:"fullyQualifiedName"`
+		Kind               string `json:"kind"`
+	}{p.Name, "resource"})
+	properties := map[string]any{
+		"projectId":  p.ProjectId,
+		"conditions": p.Conditions,
+		"timeSeries": p.TimeSeries,
+		"price":      p.Price,
+	}
+	if len(p.Tags) > 0 {
+		properties["tags"] = p.Tags
+	}
+	s.results = append(s.results, sarifResult{
+		RuleID:    ruleId,
+		Level:     level,
+		Message:   sarifMessage{Text: message},
+		Locations: []sarifLocation{location},
+		// The fingerprint lets code scanning track a finding across runs, even if the price changes
+		PartialFingerprints: map[string]string{"policyName/v1": p.Name},
+		Properties:          properties,
+	})
+}
+
+func (s *sarifSink) close(partial bool) error {
+	run := sarifRun{Results: s.results}
+	run.Tool.Driver.Name = "appe"
+	run.Tool.Driver.Version = s.version
+	run.Tool.Driver.InformationURI = "https://github.com/doitintl/gcp-tool-appe"
+	run.Tool.Driver.Rules = sarifRules
+	b, err := json.MarshalIndent(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, upload, err := createOutput(s.path, s.storage)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if partial {
+		slog.Warn("The SARIF file is incomplete, as the run was stopped before all policies were processed", "path", s.path)
+	}
+	return finishOutput(f, upload)
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"google.golang.org/api/option"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	monitoredrespb "google.golang.org/genproto/googleapis/api/monitoredres"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// runMetrics are metrics about the run itself, so that scheduled runs can be monitored like any other batch job
+type runMetrics struct {
+	started   time.Time
+	apiErrors atomic.Int64
+	// progress and limiter are set once they were created
+	progress *progress
+	limiter  *adaptiveLimiter
+}
+
+// selfMetric is a single value of the run metrics
+type selfMetric struct {
+	name       string
+	help       string
+	cumulative bool
+	value      float64
+}
+
+func newRunMetrics() *runMetrics {
+	return &runMetrics{started: time.Now()}
+}
+
+// errorCountOption returns a client option that counts every failed call of a gRPC client
+func (m *runMetrics) errorCountOption() option.ClientOption {
+	return option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			m.apiErrors.Add(1)
+		}
+		return err
+	}))
+}
+
+// values returns the current value of all metrics
+func (m *runMetrics) values() []selfMetric {
+	seconds := max(time.Since(m.started).Seconds(), 1)
+	projects := float64(m.progress.projectsDone.Load())
+	policies := float64(m.progress.policiesDone.Load())
+	return []selfMetric{
+		{"projects_done", "Number of projects whose policies were listed.", true, projects},
+		{"policies_done", "Number of policies that were estimated.", true, policies},
+		{"projects_per_second", "Average number of projects listed per second.", false, projects / seconds},
+		{"policies_per_second", "Average number of policies estimated per second.", false, policies / seconds},
+		{"api_errors", "Number of failed API calls.", true, float64(m.apiErrors.Load())},
+		{"retries", "Number of queries that were retried because the quota was exhausted.", true, float64(m.limiter.retries.Load())},
+	}
+}
+
+// serve exposes the metrics in the Prometheus text format on addr under /metrics
+func (m *runMetrics) serve(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, metric := range m.values() {
+			metricType := "gauge"
+			name := "appe_" + metric.name
+			if metric.cumulative {
+				metricType = "counter"
+				name += "_total"
+			}
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, metric.help, name, metricType, name, metric.value)
+		}
+	})
+	go func() {
+		slog.Info("Serving metrics", "url", "http://"+addr+"/metrics")
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			slog.Error("Failed to serve metrics", "error", err)
+		}
+	}()
+}
+
+// export writes the metrics to Cloud Monitoring in projectId as custom metrics every minute.
+// The returned function stops exporting after writing the final values and needs to be called before exiting.
+func (m *runMetrics) export(client *monitoring.MetricClient, projectId string) func() {
+	host, _ := os.Hostname()
+	write := func() {
+		now := timestamppb.Now()
+		var timeSeries []*monitoringpb.TimeSeries
+		for _, metric := range m.values() {
+			kind := metricpb.MetricDescriptor_GAUGE
+			interval := &monitoringpb.TimeInterval{EndTime: now}
+			if metric.cumulative {
+				kind = metricpb.MetricDescriptor_CUMULATIVE
+				interval.StartTime = timestamppb.New(m.started)
+			}
+			timeSeries = append(timeSeries, &monitoringpb.TimeSeries{
+				Metric: &metricpb.Metric{
+					Type:   "custom.googleapis.com/appe/" + metric.name,
+					Labels: map[string]string{"host": host},
+				},
+				Resource: &monitoredrespb.MonitoredResource{
+					Type:   "global",
+					Labels: map[string]string{"project_id": projectId},
+				},
+				MetricKind: kind,
+				Points: []*monitoringpb.Point{{
+					Interval: interval,
+					Value:    &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_DoubleValue{DoubleValue: metric.value}},
+				}},
+			})
+		}
+		err := client.CreateTimeSeries(context.Background(), &monitoringpb.CreateTimeSeriesRequest{
+			Name:       "projects/" + projectId,
+			TimeSeries: timeSeries,
+		})
+		if err != nil {
+			slog.Error("Failed to write metrics", "project", projectId, "error", err, "code", errorCode(err))
+		}
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				write()
+			case <-stop:
+				write()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// notifyInterrupt calls stop once the application receives SIGINT or SIGTERM and reports whether that happened.
+// After the first signal the default handling is restored, so that a second one exits immediately.
+// The returned function stops listening for signals once the run is done.
+func notifyInterrupt(stop func()) (*atomic.Bool, func()) {
+	interrupted := &atomic.Bool{}
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			interrupted.Store(true)
+			log.Printf("Received %s, finishing the policies in progress and writing the results gathered so far. Press Ctrl+C again to exit immediately\n", sig)
+			stop()
+		case <-done:
+			signal.Stop(signals)
+		}
+	}()
+	return interrupted, func() {
+		close(done)
+	}
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+)
+
+// simulatedKinds are the kinds of conditions that --simulateInterval can set the interval of
+var simulatedKinds = []string{"threshold", "absence", "mql", "promql"}
+
+// parseSimulatedIntervals parses evaluation intervals in the format "DURATION" for all conditions or "KIND=DURATION" for the conditions
+// of a kind, e.g. "promql=60s". The interval of all conditions is stored with an empty kind.
+func parseSimulatedIntervals(values []string) (map[string]time.Duration, error) {
+	intervals := make(map[string]time.Duration, len(values))
+	for _, value := range values {
+		kind, duration, found := strings.Cut(value, "=")
+		if !found {
+			kind, duration = "", value
+		}
+		kind = strings.ToLower(kind)
+		if kind != "" && !slices.Contains(simulatedKinds, kind) {
+			return nil, fmt.Errorf("invalid condition kind %q in %q, must be one of %s", kind, value, strings.Join(simulatedKinds, ", "))
+		}
+		interval, err := time.ParseDuration(duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval in %q: %v", value, err)
+		}
+		if interval < time.Second {
+			return nil, fmt.Errorf("interval in %q must be at least 1s", value)
+		}
+		intervals[kind] = interval
+	}
+	return intervals, nil
+}
+
+// simulatedPrice returns the price of the time series of a condition if it was executed at the simulated interval of its kind,
+// or price if no interval is simulated for it. The number of time series is assumed to stay the same.
+func simulatedPrice(condition *monitoringpb.AlertPolicy_Condition, timeSeries int, price float64, intervals map[string]time.Duration) float64 {
+	kind, _ := conditionKind(condition)
+	if kind == "other" {
+		return price
+	}
+	interval, ok := intervals[strings.ToLower(kind)]
+	if !ok {
+		interval, ok = intervals[""]
+	}
+	if !ok {
+		return price
+	}
+	// 2592000 (seconds per month) * 0.35 (price) / 1000000 (per 1M) = 0.9072
+	return 0.9072 / interval.Seconds() * float64(timeSeries)
+}
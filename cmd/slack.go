@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// slackTopPolicies is the number of the most expensive policies listed in the Slack message
+const slackTopPolicies = 10
+
+// slackSink posts a summary of the run to a Slack incoming webhook once all results have been received
+type slackSink struct {
+	webhook   string
+	client    *http.Client
+	baseline  *baseline
+	policies  int
+	price     float64
+	failed    int
+	expensive topPolicies
+}
+
+func newSlackSink(webhook string, client *http.Client, b *baseline) *slackSink {
+	return &slackSink{webhook: webhook, client: client, baseline: b, expensive: topPolicies{n: slackTopPolicies}}
+}
+
+func (s *slackSink) write(p *policy) error {
+	s.policies++
+	s.price += p.Price
+	if p.Error != "" {
+		s.failed++
+	}
+	s.expensive.add(p)
+	return nil
+}
+
+func (s *slackSink) close(partial bool) error {
+	var b strings.Builder
+	b.WriteString("*Alerting Policy Price Estimate*\n")
+	if partial {
+		b.WriteString(":warning: The run was stopped before all policies were processed, the results are incomplete.\n")
+	}
+	fmt.Fprintf(&b, "%d policies will cost approximately *$%.2f* per month", s.policies, s.price)
+	if s.baseline != nil {
+		fmt.Fprintf(&b, " (%s vs. baseline)", formatDelta(s.price-s.baseline.total))
+	}
+	b.WriteString(".\n")
+	if s.failed > 0 {
+		fmt.Fprintf(&b, "%d policies failed to be estimated.\n", s.failed)
+	}
+	top := s.expensive.sorted()
+	if len(top) > 0 {
+		fmt.Fprintf(&b, "\n*Top %d policies:*\n", len(top))
+		for _, p := range top {
+			fmt.Fprintf(&b, "• <%s|%s> (%s): $%.2f", policyLink(p), escapeSlack(p.DisplayName), p.ProjectId, p.Price)
+			if delta, ok := s.baseline.delta(p); ok {
+				fmt.Fprintf(&b, " (%s)", formatDelta(delta))
+			} else if s.baseline != nil {
+				b.WriteString(" (new)")
+			}
+			b.WriteString("\n")
+		}
+	}
+	body, err := json.Marshal(map[string]string{"text": b.String()})
+	if err != nil {
+		return err
+	}
+	// The results were already output, so a failed notification doesn't fail the run
+	err = postJSON(s.client, s.webhook, body, nil)
+	if err != nil {
+		slog.Error("Failed to post summary to Slack", "error", err)
+	}
+	return nil
+}
+
+// escapeSlack escapes the characters that Slack uses for its formatting of links and mentions
+func escapeSlack(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
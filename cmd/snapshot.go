@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// snapshotChanges is the number of policies with the largest changes in price that are shown in the comparison to a snapshot
+const snapshotChanges = 10
+
+// snapshot are the results of a run as they are stored in GCS by --snapshotBucket
+type snapshot struct {
+	RunTime  time.Time      `json:"runTime"`
+	Scope    string         `json:"scope"`
+	Policies []policyResult `json:"policies"`
+	// Tags are the tags of the run given with --tag
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// snapshotScope describes what a run scanned by the values of its target flags, so that only snapshots of the same targets are compared
+func snapshotScope(flags *pflag.FlagSet) string {
+	var targets []string
+	for _, name := range append(slices.Clone(targetFlags), "recursive") {
+		if flag := flags.Lookup(name); flag != nil && flag.Changed {
+			targets = append(targets, fmt.Sprintf("--%s=%s", name, flag.Value.String()))
+		}
+	}
+	return strings.Join(targets, " ")
+}
+
+// snapshotSink stores the results of every run as an object in a GCS bucket and compares them to the latest earlier snapshot
+// of the same scope once the run is done. The objects are stored as PREFIX/SCOPE_HASH/TIMESTAMP.json, so that their names sort by time.
+type snapshotSink struct {
+	ctx      context.Context
+	storage  *storage.Service
+	bucket   string
+	prefix   string
+	current  snapshot
+	previous *snapshot
+	// previousObject is the name of the latest snapshot, for the log message
+	previousObject string
+}
+
+// newSnapshotSink reads the latest snapshot of the scope from location, given as gs://BUCKET or gs://BUCKET/PREFIX
+func newSnapshotSink(ctx context.Context, storageService *storage.Service, location string, scope string, tags map[string]string, runTime time.Time) (*snapshotSink, error) {
+	if !strings.HasPrefix(location, "gs://") {
+		return nil, fmt.Errorf("%q must be in the format gs://BUCKET or gs://BUCKET/PREFIX", location)
+	}
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(location, "gs://"), "/")
+	hash := sha256.Sum256([]byte(scope))
+	prefix = strings.TrimPrefix(strings.TrimSuffix(prefix, "/")+"/"+hex.EncodeToString(hash[:8])+"/", "/")
+	s := &snapshotSink{ctx: ctx, storage: storageService, bucket: bucket, prefix: prefix, current: snapshot{RunTime: runTime, Scope: scope, Tags: tags, Policies: []policyResult{}}}
+
+	latest := ""
+	err := storageService.Objects.List(bucket).Prefix(prefix).Fields("nextPageToken", "items/name").Pages(ctx, func(objects *storage.Objects) error {
+		for _, object := range objects.Items {
+			if strings.HasSuffix(object.Name, ".json") && object.Name > latest {
+				latest = object.Name
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %v", err)
+	}
+	if latest == "" {
+		return s, nil
+	}
+	response, err := storageService.Objects.Get(bucket, latest).Context(ctx).Download()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %v", latest, err)
+	}
+	defer response.Body.Close()
+	s.previous = &snapshot{}
+	err = json.NewDecoder(response.Body).Decode(s.previous)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %v", latest, err)
+	}
+	s.previousObject = latest
+	return s, nil
+}
+
+func (s *snapshotSink) write(p *policy) error {
+	s.current.Policies = append(s.current.Policies, newPolicyResult(p, s.current.RunTime))
+	return nil
+}
+
+func (s *snapshotSink) close(partial bool) error {
+	if s.previous != nil {
+		s.compare()
+	}
+	// A partial snapshot would show all policies that weren't processed as removed in the next comparison
+	if partial {
+		log.Println("The snapshot isn't stored, as the run was stopped before all policies were processed")
+		return nil
+	}
+	b, err := json.Marshal(s.current)
+	if err != nil {
+		return err
+	}
+	object := s.prefix + s.current.RunTime.UTC().Format("20060102T150405Z") + ".json"
+	_, err = s.storage.Objects.Insert(s.bucket, &storage.Object{Name: object, ContentType: "application/json"}).Media(bytes.NewReader(b)).Context(s.ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to store snapshot: %v", err)
+	}
+	log.Printf("Stored the snapshot of the results as gs://%s/%s\n", s.bucket, object)
+	return nil
+}
+
+// compare logs the difference of the total price to the previous snapshot, the policies that were added or removed since,
+// and the policies whose price changed the most
+func (s *snapshotSink) compare() {
+	previous := map[string]policyResult{}
+	previousTotal := 0.0
+	for _, p := range s.previous.Policies {
+		previous[p.Name] = p
+		previousTotal += p.Price
+	}
+	type change struct {
+		result policyResult
+		delta  float64
+		// note says if the policy was added or removed since the snapshot
+		note string
+	}
+	var changes []change
+	total := 0.0
+	added, changed := 0, 0
+	for _, p := range s.current.Policies {
+		total += p.Price
+		before, ok := previous[p.Name]
+		delete(previous, p.Name)
+		if !ok {
+			added++
+			changes = append(changes, change{p, p.Price, ", new"})
+			continue
+		}
+		if delta := p.Price - before.Price; math.Abs(delta) >= 0.005 {
+			changed++
+			changes = append(changes, change{p, delta, ""})
+		}
+	}
+	for _, p := range previous {
+		changes = append(changes, change{p, -p.Price, ", removed"})
+	}
+	slices.SortFunc(changes, func(a, b change) int {
+		if math.Abs(a.delta) != math.Abs(b.delta) {
+			if math.Abs(a.delta) > math.Abs(b.delta) {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.result.Name, b.result.Name)
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Compared to the snapshot of %s (gs://%s/%s): $%f (%s), %d new, %d removed and %d changed policies", s.previous.RunTime.Format(time.RFC3339), s.bucket, s.previousObject, total, formatDelta(total-previousTotal), added, len(previous), changed)
+	for _, c := range changes[:min(len(changes), snapshotChanges)] {
+		fmt.Fprintf(&b, "\n  %s %s (%s%s)", formatDelta(c.delta), c.result.DisplayName, c.result.Name, c.note)
+	}
+	log.Println(b.String())
+}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"slices"
+	"sync"
+)
+
+// runState persists the progress of a run to a file, so that an interrupted run can be resumed.
+// The file contains one JSON record per line for each processed policy and each completed project.
+// A project is completed once its policies have been listed and all of them have been processed.
+type runState struct {
+	mu        sync.Mutex
+	file      *os.File
+	enc       *json.Encoder
+	policies  map[string]*policy
+	projects  map[string][]*policy
+	completed map[string]bool
+	pending   map[string]int
+	listed    map[string]bool
+	// failed are the projects with policies that failed, they aren't completed so that the policies are retried on resume
+	failed map[string]bool
+}
+
+type stateRecord struct {
+	Policy  *policy `json:"policy,omitempty"`
+	Project string  `json:"project,omitempty"`
+}
+
+// openState opens the state file at path. If resume is true, the progress of the previous run is loaded from it
+// and new progress is appended. Otherwise, the file is truncated.
+func openState(path string, resume bool) (*runState, error) {
+	s := &runState{
+		policies:  map[string]*policy{},
+		projects:  map[string][]*policy{},
+		completed: map[string]bool{},
+		pending:   map[string]int{},
+		listed:    map[string]bool{},
+		failed:    map[string]bool{},
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		f, err := os.Open(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			scanner := bufio.NewScanner(f)
+			scanner.Buffer(nil, 1024*1024)
+			for scanner.Scan() {
+				record := &stateRecord{}
+				// The last line may be incomplete if the previous run was killed while writing it
+				if json.Unmarshal(scanner.Bytes(), record) != nil {
+					continue
+				}
+				if record.Policy != nil {
+					s.policies[record.Policy.Name] = record.Policy
+					s.projects[record.Policy.ProjectId] = append(s.projects[record.Policy.ProjectId], record.Policy)
+				}
+				if record.Project != "" {
+					s.completed[record.Project] = true
+				}
+			}
+			f.Close()
+			if err := scanner.Err(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s.file = f
+	s.enc = json.NewEncoder(f)
+	return s, nil
+}
+
+// isCompleted reports whether the project was completed in a previous run
+func (s *runState) isCompleted(projectId string) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.completed[projectId]
+}
+
+// storedPolicies returns the results of all policies of a project that were processed in a previous run.
+// The results are only returned once and released afterwards, so that they don't stay in memory for the rest of the run.
+func (s *runState) storedPolicies(projectId string) []*policy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	policies := s.projects[projectId]
+	delete(s.projects, projectId)
+	for _, p := range policies {
+		delete(s.policies, p.Name)
+	}
+	return policies
+}
+
+// storedPolicy returns the result of a policy if it was processed in a previous run. Like storedPolicies, it is only returned once.
+func (s *runState) storedPolicy(name string) (*policy, bool) {
+	if s == nil {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.policies[name]
+	if !ok {
+		return nil, false
+	}
+	delete(s.policies, name)
+	s.projects[p.ProjectId] = slices.DeleteFunc(s.projects[p.ProjectId], func(stored *policy) bool { return stored == p })
+	if len(s.projects[p.ProjectId]) == 0 {
+		delete(s.projects, p.ProjectId)
+	}
+	return p, true
+}
+
+// listedPolicies records that n policies of the project were put on the policies channel
+func (s *runState) listedPolicies(projectId string, n int) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listed[projectId] = true
+	s.pending[projectId] += n
+	return s.completeIfDone(projectId)
+}
+
+// processed records the result of a policy. If stored is true, the result was loaded from a previous run and isn't written again.
+// Policies that failed aren't written, so that they are processed again when the run is resumed.
+func (s *runState) processed(p *policy, stored bool) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[p.ProjectId]--
+	if p.Error != "" {
+		s.failed[p.ProjectId] = true
+		return nil
+	}
+	if !stored {
+		err := s.enc.Encode(&stateRecord{Policy: p})
+		if err != nil {
+			return err
+		}
+	}
+	return s.completeIfDone(p.ProjectId)
+}
+
+func (s *runState) completeIfDone(projectId string) error {
+	if s.listed[projectId] && s.pending[projectId] == 0 && !s.completed[projectId] && !s.failed[projectId] {
+		s.completed[projectId] = true
+		return s.enc.Encode(&stateRecord{Project: projectId})
+	}
+	return nil
+}
+
+func (s *runState) close() error {
+	if s == nil {
+		return nil
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRunStateResume(t *testing.T) {
+	ok := func(name string) *policy { return &policy{Name: "projects/a/alertPolicies/" + name, ProjectId: "a"} }
+	failed := func(name string) *policy {
+		return &policy{Name: "projects/a/alertPolicies/" + name, ProjectId: "a", Error: "rpc error: code = Unavailable"}
+	}
+	tests := []struct {
+		name      string
+		listed    int
+		processed []*policy
+		// truncated appends an incomplete record like the one of a run that was killed while writing
+		truncated bool
+		completed bool
+		stored    []string
+	}{
+		{name: "completed", listed: 2, processed: []*policy{ok("1"), ok("2")}, completed: true, stored: []string{"1", "2"}},
+		{name: "interrupted", listed: 2, processed: []*policy{ok("1")}, stored: []string{"1"}},
+		{name: "not listed", listed: -1, processed: []*policy{ok("1")}, stored: []string{"1"}},
+		{name: "failed", listed: 2, processed: []*policy{ok("1"), failed("2")}, stored: []string{"1"}},
+		{name: "truncated", listed: 1, processed: []*policy{ok("1")}, truncated: true, completed: true, stored: []string{"1"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "appe.state")
+			s, err := openState(path, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.listed >= 0 {
+				err = s.listedPolicies("a", test.listed)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, p := range test.processed {
+				err = s.processed(p, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = s.close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.truncated {
+				f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.WriteString(`{"policy": {"Name": "projects/a/alertPo`)
+				f.Close()
+			}
+
+			s, err = openState(path, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.close()
+			if got := s.isCompleted("a"); got != test.completed {
+				t.Errorf("isCompleted = %v, want %v", got, test.completed)
+			}
+			for _, p := range test.processed {
+				_, got := s.storedPolicy(p.Name)
+				if want := slices.Contains(test.stored, filepath.Base(p.Name)); got != want {
+					t.Errorf("storedPolicy(%s) = %v, want %v", p.Name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRunStateWriteError(t *testing.T) {
+	s, err := openState(filepath.Join(t.TempDir(), "appe.state"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.close()
+	err = s.listedPolicies("a", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.processed(&policy{Name: "projects/a/alertPolicies/1", ProjectId: "a"}, false)
+	if err == nil {
+		t.Error("processed didn't return the error of writing to a closed file")
+	}
+}
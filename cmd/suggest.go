@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// minSuggestedAlignment is the shortest alignment period of a suggested aggregation, as finer alignments rarely change when a policy fires
+const minSuggestedAlignment = time.Minute
+
+// suggestedReducers are the cross-series reducers that keep a threshold condition firing whenever any of its time series crosses the threshold
+var suggestedReducers = map[monitoringpb.ComparisonType]monitoringpb.Aggregation_Reducer{
+	monitoringpb.ComparisonType_COMPARISON_GT: monitoringpb.Aggregation_REDUCE_MAX,
+	monitoringpb.ComparisonType_COMPARISON_GE: monitoringpb.Aggregation_REDUCE_MAX,
+	monitoringpb.ComparisonType_COMPARISON_LT: monitoringpb.Aggregation_REDUCE_MIN,
+	monitoringpb.ComparisonType_COMPARISON_LE: monitoringpb.Aggregation_REDUCE_MIN,
+}
+
+// suggestAggregation returns an aggregation for a threshold condition without a cross-series reducer, which reduces its time series
+// to one per combination of the groupBy labels. The aligner of the condition is kept, the alignment period is raised to minSuggestedAlignment.
+// Returns nil if the condition already reduces its time series or can't be reduced without changing when it fires.
+func suggestAggregation(threshold *monitoringpb.AlertPolicy_Condition_MetricThreshold, groupBy []string) *monitoringpb.Aggregation {
+	reducer, ok := suggestedReducers[threshold.GetComparison()]
+	if !ok || threshold.GetDenominatorFilter() != "" {
+		return nil
+	}
+	suggested := &monitoringpb.Aggregation{
+		AlignmentPeriod:    durationpb.New(minSuggestedAlignment),
+		PerSeriesAligner:   monitoringpb.Aggregation_ALIGN_MEAN,
+		CrossSeriesReducer: reducer,
+		GroupByFields:      groupBy,
+	}
+	for _, aggregation := range threshold.GetAggregations() {
+		if aggregation.GetCrossSeriesReducer() != monitoringpb.Aggregation_REDUCE_NONE {
+			return nil
+		}
+		if aggregation.GetPerSeriesAligner() != monitoringpb.Aggregation_ALIGN_NONE {
+			suggested.PerSeriesAligner = aggregation.GetPerSeriesAligner()
+		}
+		if aggregation.GetAlignmentPeriod().AsDuration() > minSuggestedAlignment {
+			suggested.AlignmentPeriod = aggregation.GetAlignmentPeriod()
+		}
+	}
+	return suggested
+}
+
+// suggestedTimeSeries counts the time series of a condition with the aggregation from suggestAggregation.
+// Returns the current number of time series if there is no suggestion or it wouldn't reduce them.
+func (e *estimator) suggestedTimeSeries(ctx context.Context, name string, condition *monitoringpb.AlertPolicy_Condition, timeSeries int) (int, error) {
+	threshold := condition.GetConditionThreshold()
+	if threshold == nil {
+		return timeSeries, nil
+	}
+	aggregation := suggestAggregation(threshold, e.suggestGroupBy)
+	if aggregation == nil {
+		return timeSeries, nil
+	}
+	var count int
+	var err error
+	if e.noQuery {
+		count, err = e.estimateTimeSeries(ctx, name, threshold.GetFilter(), []*monitoringpb.Aggregation{aggregation})
+	} else {
+		tsReq := &monitoringpb.ListTimeSeriesRequest{
+			Name:        name,
+			Filter:      threshold.GetFilter(),
+			Interval:    &monitoringpb.TimeInterval{StartTime: e.start, EndTime: e.end},
+			Aggregation: aggregation,
+			View:        monitoringpb.ListTimeSeriesRequest_HEADERS,
+		}
+		count, err = e.cache.count(timeSeriesCacheKey(tsReq, e.countStrategy, e.end.AsTime().Sub(e.start.AsTime()).String()), func() (count int, err error) {
+			err = e.limiter.run(ctx, func() (err error) {
+				count, err = countTimeSeries(ctx, e.metricClient, tsReq, e.countStrategy)
+				return err
+			})
+			return count, err
+		})
+	}
+	if err != nil || count >= timeSeries {
+		return timeSeries, err
+	}
+	return count, nil
+}
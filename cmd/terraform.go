@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+// terraformState is the part of a Terraform state file that holds the managed alerting policies
+type terraformState struct {
+	Version   int `json:"version"`
+	Resources []struct {
+		Mode      string `json:"mode"`
+		Type      string `json:"type"`
+		Instances []struct {
+			Attributes struct {
+				Name string `json:"name"`
+			} `json:"attributes"`
+		} `json:"instances"`
+	} `json:"resources"`
+}
+
+// readTerraformState returns the names of all alerting policies managed in a Terraform state file.
+// The path is either a local file or an object in a GCS backend given as gs://BUCKET/OBJECT.
+func readTerraformState(ctx context.Context, storageService *storage.Service, path string) ([]string, error) {
+	var r io.ReadCloser
+	if bucket, object, found := strings.Cut(strings.TrimPrefix(path, "gs://"), "/"); strings.HasPrefix(path, "gs://") {
+		if !found || object == "" {
+			return nil, fmt.Errorf("%q must be in the format gs://BUCKET/OBJECT", path)
+		}
+		response, err := storageService.Objects.Get(bucket, object).Context(ctx).Download()
+		if err != nil {
+			return nil, err
+		}
+		r = response.Body
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	}
+	defer r.Close()
+	var state terraformState
+	err := json.NewDecoder(r).Decode(&state)
+	if err != nil {
+		return nil, err
+	}
+	if state.Version != 4 {
+		return nil, fmt.Errorf("unsupported state version %d, only version 4 is supported", state.Version)
+	}
+	var policies []string
+	for _, resource := range state.Resources {
+		if resource.Mode != "managed" || resource.Type != "google_monitoring_alert_policy" {
+			continue
+		}
+		for _, instance := range resource.Instances {
+			if strings.Contains(instance.Attributes.Name, "/alertPolicies/") {
+				policies = append(policies, instance.Attributes.Name)
+			}
+		}
+	}
+	return policies, nil
+}
+
+// terraformWorkspace derives the name of the workspace from the path of its state file.
+// The GCS backend stores each workspace as PREFIX/WORKSPACE.tfstate, the local backend the default workspace as terraform.tfstate
+// and all others as terraform.tfstate.d/WORKSPACE/terraform.tfstate.
+func terraformWorkspace(path string) string {
+	dir, file := filepath.Split(strings.TrimPrefix(path, "gs://"))
+	if file == "terraform.tfstate" {
+		if filepath.Base(filepath.Dir(filepath.Clean(dir))) == "terraform.tfstate.d" {
+			return filepath.Base(dir)
+		}
+		return "default"
+	}
+	return strings.TrimSuffix(file, ".tfstate")
+}
+
+// workspaceReport sums up the estimated price of the policies managed by each Terraform state
+type workspaceReport struct {
+	// states maps each policy name to the state that manages it
+	states map[string]string
+	// order is the order the states were given in
+	order    []string
+	policies map[string]int
+	prices   map[string]float64
+}
+
+func newWorkspaceReport() *workspaceReport {
+	return &workspaceReport{
+		states:   map[string]string{},
+		policies: map[string]int{},
+		prices:   map[string]float64{},
+	}
+}
+
+// manage records that the policies are managed by the state at path
+func (r *workspaceReport) manage(path string, policies []string) {
+	state := fmt.Sprintf("%s (%s)", terraformWorkspace(path), path)
+	r.order = append(r.order, state)
+	for _, name := range policies {
+		r.states[name] = state
+	}
+}
+
+// add adds the price of a policy to the state that manages it
+func (r *workspaceReport) add(p *policy) {
+	if r == nil {
+		return
+	}
+	if state, ok := r.states[p.Name]; ok {
+		r.policies[state]++
+		r.prices[state] += p.Price
+	}
+}
+
+// print logs the number of policies and their price for each state
+func (r *workspaceReport) print() {
+	if r == nil {
+		return
+	}
+	for _, state := range r.order {
+		log.Printf("Terraform workspace %s manages %d policies. They will cost approximately $%f\n", state, r.policies[state], r.prices[state])
+	}
+}
@@ -0,0 +1,333 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// trendCmd shows how the estimated prices developed over past runs that were stored as snapshots or written to BigQuery
+var trendCmd = &cobra.Command{
+	Use:   "trend",
+	Short: "Show the price over time per scope, the top risers and the fastest growing policies of past runs",
+	Long: `Reads the results of past runs from the snapshots stored with --snapshotBucket, either in GCS or copied to a
+local directory, or from the BigQuery table they were written to with --bigQueryTable. Shows the total price of every
+run per scope, the policies whose price rose the most and the policies whose number of time series grows the fastest
+over the requested days.`,
+	Example: `./appe trend --snapshots gs://BUCKET/appe --days 90
+./appe trend --snapshots ./snapshots
+./appe trend --bigQueryTable PROJECT_ID.appe.history`,
+	Args: cobra.NoArgs,
+	Run:  trend,
+}
+
+// trendPolicy is the estimate of a policy in a past run
+type trendPolicy struct {
+	name        string
+	displayName string
+	projectId   string
+	timeSeries  int
+	price       float64
+}
+
+// trendRun is a past run of a scope
+type trendRun struct {
+	time     time.Time
+	scope    string
+	policies []trendPolicy
+}
+
+// trendSeries is the development of a policy from its first to its last run in the window
+type trendSeries struct {
+	first, last         trendPolicy
+	firstTime, lastTime time.Time
+}
+
+func trend(cmd *cobra.Command, args []string) {
+	snapshots, err := cmd.Flags().GetString("snapshots")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	tableName, err := cmd.Flags().GetString("bigQueryTable")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	days, err := cmd.Flags().GetInt("days")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	top, err := cmd.Flags().GetInt("top")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	credentials, err := cmd.Flags().GetString("credentials")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	ctx := context.Background()
+	since := time.Now().AddDate(0, 0, -days)
+	var runs []trendRun
+	switch {
+	case tableName != "":
+		runs, err = readBigQueryRuns(ctx, credentials, tableName, days)
+	case strings.HasPrefix(snapshots, "gs://"):
+		runs, err = readGCSSnapshots(ctx, credentials, snapshots, since)
+	default:
+		runs, err = readLocalSnapshots(snapshots, since)
+	}
+	if err != nil {
+		log.Fatalf("Failed to read past runs: %v", err)
+	}
+	if len(runs) == 0 {
+		log.Fatalf("No runs were found in the last %d days", days)
+	}
+	slices.SortFunc(runs, func(a, b trendRun) int {
+		if a.scope != b.scope {
+			return strings.Compare(a.scope, b.scope)
+		}
+		return a.time.Compare(b.time)
+	})
+
+	// The price of every run, grouped by scope
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Scope\tRun\tPolicies\tPrice\tChange\n")
+	series := map[string]*trendSeries{}
+	for i, run := range runs {
+		price := 0.0
+		for _, p := range run.policies {
+			price += p.price
+			key := run.scope + "|" + p.name
+			if s, ok := series[key]; ok {
+				s.last, s.lastTime = p, run.time
+			} else {
+				series[key] = &trendSeries{first: p, last: p, firstTime: run.time, lastTime: run.time}
+			}
+		}
+		change := ""
+		if i > 0 && runs[i-1].scope == run.scope {
+			previous := 0.0
+			for _, p := range runs[i-1].policies {
+				previous += p.price
+			}
+			change = formatDelta(price - previous)
+		}
+		scope := run.scope
+		if scope == "" {
+			scope = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t$%.2f\t%s\n", scope, run.time.Format("2006-01-02 15:04"), len(run.policies), price, change)
+	}
+	w.Flush()
+
+	// Policies are compared between the first and the last run they appear in
+	all := slices.Collect(maps.Values(series))
+	slices.SortFunc(all, func(a, b *trendSeries) int {
+		return compareDescending(a.last.price-a.first.price, b.last.price-b.first.price, a.last.name, b.last.name)
+	})
+	fmt.Printf("\nTop risers in the last %d days:\n", days)
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Policy\tProject\tFirst\tLast\tChange\n")
+	rows := 0
+	for _, s := range all {
+		if rows == top || s.last.price-s.first.price < 0.01 {
+			break
+		}
+		fmt.Fprintf(w, "%s\t%s\t$%.2f\t$%.2f\t%s\n", s.last.displayName, s.last.projectId, s.first.price, s.last.price, formatDelta(s.last.price-s.first.price))
+		rows++
+	}
+	w.Flush()
+	if rows == 0 {
+		fmt.Println("No policy rose in price.")
+	}
+
+	// The growth of the time series is compared per day, so that policies that were created recently are ranked fairly
+	growth := func(s *trendSeries) float64 {
+		days := s.lastTime.Sub(s.firstTime).Hours() / 24
+		if days < 1 {
+			return 0
+		}
+		return float64(s.last.timeSeries-s.first.timeSeries) / days
+	}
+	slices.SortFunc(all, func(a, b *trendSeries) int {
+		return compareDescending(growth(a), growth(b), a.last.name, b.last.name)
+	})
+	fmt.Printf("\nFastest growing time series in the last %d days:\n", days)
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Policy\tProject\tFirst\tLast\tPer Day\n")
+	rows = 0
+	for _, s := range all {
+		if rows == top || growth(s) <= 0 {
+			break
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t+%.1f\n", s.last.displayName, s.last.projectId, s.first.timeSeries, s.last.timeSeries, growth(s))
+		rows++
+	}
+	w.Flush()
+	if rows == 0 {
+		fmt.Println("No policy grew in time series.")
+	}
+}
+
+// compareDescending orders by a value from the highest to the lowest, and by name if the values are equal
+func compareDescending(a float64, b float64, nameA string, nameB string) int {
+	if a != b {
+		if a > b {
+			return -1
+		}
+		return 1
+	}
+	return strings.Compare(nameA, nameB)
+}
+
+// snapshotRun converts a snapshot to a run
+func snapshotRun(s snapshot) trendRun {
+	run := trendRun{time: s.RunTime, scope: s.Scope}
+	for _, p := range s.Policies {
+		run.policies = append(run.policies, trendPolicy{name: p.Name, displayName: p.DisplayName, projectId: p.ProjectId, timeSeries: p.TimeSeries, price: p.Price})
+	}
+	return run
+}
+
+// readLocalSnapshots reads all snapshots in a directory and its subdirectories that were stored after since
+func readLocalSnapshots(dir string, since time.Time) ([]trendRun, error) {
+	var runs []trendRun
+	err := filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			return err
+		}
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		var s snapshot
+		err = json.Unmarshal(b, &s)
+		if err != nil {
+			return fmt.Errorf("failed to read snapshot %s: %v", name, err)
+		}
+		if s.RunTime.After(since) {
+			runs = append(runs, snapshotRun(s))
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// readGCSSnapshots reads all snapshots below gs://BUCKET/PREFIX that were stored after since.
+// The time of a snapshot is taken from its object name, so that older snapshots don't have to be downloaded.
+func readGCSSnapshots(ctx context.Context, credentials string, location string, since time.Time) ([]trendRun, error) {
+	service, err := newStorageService(ctx, credentials)
+	if err != nil {
+		return nil, err
+	}
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(location, "gs://"), "/")
+	var names []string
+	err = service.Objects.List(bucket).Prefix(prefix).Fields("nextPageToken", "items/name").Pages(ctx, func(objects *storage.Objects) error {
+		for _, object := range objects.Items {
+			t, err := time.Parse("20060102T150405Z", strings.TrimSuffix(path.Base(object.Name), ".json"))
+			if err == nil && t.After(since) {
+				names = append(names, object.Name)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var runs []trendRun
+	for _, name := range names {
+		response, err := service.Objects.Get(bucket, name).Context(ctx).Download()
+		if err != nil {
+			return nil, err
+		}
+		var s snapshot
+		err = json.NewDecoder(response.Body).Decode(&s)
+		response.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot %s: %v", name, err)
+		}
+		runs = append(runs, snapshotRun(s))
+	}
+	return runs, nil
+}
+
+// readBigQueryRuns reads all runs of the last days from the results history in BigQuery, which has no scopes
+func readBigQueryRuns(ctx context.Context, credentials string, tableName string, days int) ([]trendRun, error) {
+	table, err := parseBigQueryTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+	service, err := newBigQueryService(ctx, credentials)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := queryBigQuery(ctx, service, table.project, fmt.Sprintf(
+		"SELECT run_id, UNIX_MICROS(run_time), policy_name, display_name, project_id, time_series, price FROM `%s` WHERE run_time >= TIMESTAMP_SUB(CURRENT_TIMESTAMP(), INTERVAL %d DAY) ORDER BY run_time",
+		table, days), nil)
+	if err != nil {
+		return nil, err
+	}
+	var runs []trendRun
+	index := map[string]int{}
+	for _, row := range rows {
+		i, ok := index[row[0]]
+		if !ok {
+			micros, _ := strconv.ParseInt(row[1], 10, 64)
+			runs = append(runs, trendRun{time: time.UnixMicro(micros).UTC()})
+			i = len(runs) - 1
+			index[row[0]] = i
+		}
+		timeSeries, _ := strconv.Atoi(row[5])
+		runs[i].policies = append(runs[i].policies, trendPolicy{name: row[2], displayName: row[3], projectId: row[4], timeSeries: timeSeries, price: parseFloat(row[6])})
+	}
+	return runs, nil
+}
+
+// newStorageService creates a read-only GCS client for the commands that only read results, using the credentials file at path
+// or the credentials stored by "appe auth login" if it is empty and they exist, otherwise the application default credentials
+func newStorageService(ctx context.Context, path string) (*storage.Service, error) {
+	if path == "" {
+		if _, err := os.Stat(defaultCredentialsPath()); err == nil {
+			path = defaultCredentialsPath()
+		}
+	}
+	var options []option.ClientOption
+	if path != "" {
+		creds, err := loadCredentials(ctx, path, []string{storage.DevstorageReadOnlyScope}, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load credentials %s: %v", path, err)
+		}
+		options = append(options, option.WithAuthCredentials(creds))
+	}
+	service, err := storage.NewService(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage client: %v", err)
+	}
+	return service, nil
+}
+
+func init() {
+	rootCmd.AddCommand(trendCmd)
+	trendCmd.Flags().String("snapshots", "", "The snapshots of past runs stored with --snapshotBucket, given as gs://BUCKET/PREFIX or a local directory they were copied to.")
+	trendCmd.Flags().String("bigQueryTable", "", "The BigQuery table the results of past runs were written to in the format PROJECT.DATASET.TABLE.")
+	trendCmd.Flags().Int("days", 30, "The number of days to analyze the runs of.")
+	trendCmd.Flags().Int("top", 10, "The number of policies to show as top risers and fastest growing.")
+	trendCmd.Flags().String("credentials", "", "Path to a service account key or workload identity federation configuration. Defaults to the credentials stored by \"appe auth login\" if they exist, otherwise the application default credentials are used.")
+	trendCmd.MarkFlagsOneRequired("snapshots", "bigQueryTable")
+	trendCmd.MarkFlagsMutuallyExclusive("snapshots", "bigQueryTable")
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// webhookPayload is the body of a request to a webhook. The last request of a run is marked as final, even if it has no results.
+type webhookPayload struct {
+	RunTime time.Time      `json:"runTime"`
+	Final   bool           `json:"final"`
+	Partial bool           `json:"partial"`
+	Results []policyResult `json:"results"`
+}
+
+// webhookSink posts the results in batches of JSON to an arbitrary endpoint.
+// If a secret is given, every request is signed with HMAC-SHA256 of its body in the X-Appe-Signature header, like GitHub signs its webhooks.
+// If a batch can't be posted, its results are kept and posted again with the next batch or at the end of the run, so that a failing
+// endpoint doesn't stop the scan and the other outputs.
+type webhookSink struct {
+	url     string
+	client  *http.Client
+	secret  string
+	batch   int
+	runTime time.Time
+	results []policyResult
+	// added counts the results since the last post, so that a failed batch is retried once the next batch is full
+	added int
+}
+
+func newWebhookSink(url string, client *http.Client, secret string, batch int, runTime time.Time) *webhookSink {
+	return &webhookSink{url: url, client: client, secret: secret, batch: batch, runTime: runTime, results: []policyResult{}}
+}
+
+func (s *webhookSink) write(p *policy) error {
+	s.results = append(s.results, newPolicyResult(p, s.runTime))
+	s.added++
+	if s.batch > 0 && s.added >= s.batch {
+		err := s.post(false, false)
+		if err != nil {
+			slog.Error("Failed to post results to webhook, they will be posted again with the next batch", "results", len(s.results), "error", err)
+		}
+	}
+	return nil
+}
+
+// post sends all buffered results
+func (s *webhookSink) post(final bool, partial bool) error {
+	body, err := json.Marshal(webhookPayload{RunTime: s.runTime, Final: final, Partial: partial, Results: s.results})
+	if err != nil {
+		return err
+	}
+	s.added = 0
+	var header http.Header
+	if s.secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write(body)
+		header = http.Header{"X-Appe-Signature": {"sha256=" + hex.EncodeToString(mac.Sum(nil))}}
+	}
+	err = postJSON(s.client, s.url, body, header)
+	if err != nil {
+		return fmt.Errorf("failed to post %d results to webhook: %v", len(s.results), err)
+	}
+	s.results = s.results[:0]
+	return nil
+}
+
+func (s *webhookSink) close(partial bool) error {
+	return s.post(true, partial)
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// monitoringRetention is how long Cloud Monitoring keeps the data of most metrics
+const monitoringRetention = 6 * 7 * 24 * time.Hour
+
+// absoluteWindow returns the time window given with --start and --end. If one of them is missing, the window ends now or starts duration before end.
+func absoluteWindow(startFlag string, endFlag string, duration time.Duration, now time.Time) (*timestamppb.Timestamp, *timestamppb.Timestamp, error) {
+	end := now
+	if endFlag != "" {
+		t, err := parseTime(endFlag)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid end: %v", err)
+		}
+		if t.After(now) {
+			return nil, nil, fmt.Errorf("end %s is in the future", t.Format(time.RFC3339))
+		}
+		end = t
+	}
+	start := end.Add(-duration)
+	if startFlag != "" {
+		t, err := parseTime(startFlag)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid start: %v", err)
+		}
+		start = t
+	}
+	if !start.Before(end) {
+		return nil, nil, fmt.Errorf("start %s isn't before end %s", start.Format(time.RFC3339), end.Format(time.RFC3339))
+	}
+	if now.Sub(start) > monitoringRetention {
+		slog.Warn("The time window starts more than 6 weeks ago, Cloud Monitoring only keeps most metrics for 6 weeks, so the time series of earlier data won't be counted", "start", start.Format(time.RFC3339))
+	}
+	return timestamppb.New(start), timestamppb.New(end), nil
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// targetFlags are the flags of which at least one is needed to know what to scan
+var targetFlags = []string{"policy", "project", "folder", "organization", "projectsFile", "allOrganizations", "terraformState", "grafanaRules", "kccManifests", "datadogMonitors", "pulumiPreview", "pubsubSubscription"}
+
+// hasTarget reports whether any of the target flags was set
+func hasTarget(flags *pflag.FlagSet) bool {
+	for _, name := range targetFlags {
+		if flags.Changed(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// runWizard asks the user for the scope, time window and output of the run and sets the corresponding flags.
+// The project defaults to the project of the active gcloud configuration.
+func runWizard(flags *pflag.FlagSet) error {
+	in := bufio.NewReader(os.Stdin)
+	ask := func(question string, fallback string) (string, error) {
+		if fallback != "" {
+			fmt.Fprintf(os.Stderr, "%s [%s]: ", question, fallback)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: ", question)
+		}
+		answer, err := in.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			return fallback, nil
+		}
+		return answer, nil
+	}
+
+	fmt.Fprintln(os.Stderr, "No projects, folders, organizations or policies were given. Answer a few questions to start a scan, or press Ctrl+C and run appe --help to see all flags.")
+	scope, err := ask("Scan a (p)roject, (f)older or (o)rganization?", "p")
+	if err != nil {
+		return err
+	}
+	var flag, question, fallback string
+	switch strings.ToLower(scope) {
+	case "p", "project":
+		flag, question, fallback = "project", "Project ID", gcloudProject()
+	case "f", "folder":
+		flag, question = "folder", "Folder ID or display name path"
+	case "o", "organization":
+		flag, question = "organization", "Organization ID or domain"
+	default:
+		return fmt.Errorf("invalid scope %q", scope)
+	}
+	target, err := ask(question, fallback)
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		return fmt.Errorf("no %s given", flag)
+	}
+	err = flags.Set(flag, target)
+	if err != nil {
+		return err
+	}
+	if flag != "project" {
+		recursive, err := ask("Also scan all subfolders? (y/n)", "y")
+		if err != nil {
+			return err
+		}
+		err = flags.Set("recursive", fmt.Sprint(strings.HasPrefix(strings.ToLower(recursive), "y")))
+		if err != nil {
+			return err
+		}
+	}
+
+	duration, err := ask("Time window to count time series in", flags.Lookup("duration").Value.String())
+	if err != nil {
+		return err
+	}
+	err = flags.Set("duration", duration)
+	if err != nil {
+		return err
+	}
+	csvOut, err := ask("CSV file to write the results to (leave empty to print them)", "")
+	if err != nil {
+		return err
+	}
+	if csvOut != "" {
+		return flags.Set("csvOut", csvOut)
+	}
+	return nil
+}
+
+// gcloudConfigDir returns the default configuration directory of gcloud, which is %APPDATA%\gcloud on Windows and
+// ~/.config/gcloud on all other operating systems including macOS, unlike the user config directory of Go
+func gcloudConfigDir() string {
+	if runtime.GOOS == "windows" {
+		if appData := os.Getenv("APPDATA"); appData != "" {
+			return filepath.Join(appData, "gcloud")
+		}
+		return ""
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gcloud")
+}
+
+// gcloudProject returns the project of the active gcloud configuration, or an empty string if it isn't set.
+// Like gcloud, the CLOUDSDK_CORE_PROJECT environment variable takes precedence over the configuration.
+func gcloudProject() string {
+	if project := os.Getenv("CLOUDSDK_CORE_PROJECT"); project != "" {
+		return project
+	}
+	dir := os.Getenv("CLOUDSDK_CONFIG")
+	if dir == "" {
+		dir = gcloudConfigDir()
+	}
+	if dir == "" {
+		return ""
+	}
+	active := os.Getenv("CLOUDSDK_ACTIVE_CONFIG_NAME")
+	if active == "" {
+		b, err := os.ReadFile(filepath.Join(dir, "active_config"))
+		if err != nil {
+			active = "default"
+		} else {
+			active = strings.TrimSpace(string(b))
+		}
+	}
+	f, err := os.Open(filepath.Join(dir, "configurations", "config_"+active))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	// The configuration is an INI file, the project is set in the [core] section
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = line[1 : len(line)-1]
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if found && section == "core" && strings.TrimSpace(key) == "project" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+// Package function exposes appe as an HTTP handler for Cloud Functions, so that estimates can be triggered
+// from Cloud Scheduler or Workflows without managing binaries.
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/doitintl/gcp-tool-appe/cmd"
+)
+
+// request is the scope of an estimate. All other flags are taken from APPE_* environment variables of the function,
+// e.g. APPE_CSV_OUT=gs://BUCKET/appe.csv for the output, so that callers can't change where results are sent or which credentials are used.
+type request struct {
+	Projects      []string `json:"projects"`
+	Folders       []string `json:"folders"`
+	Organizations []string `json:"organizations"`
+	Policies      []string `json:"policies"`
+	Recursive     bool     `json:"recursive"`
+	// Duration is the time window to count time series in, e.g. "24h"
+	Duration string `json:"duration"`
+}
+
+// response reports the exit code of the run, which is 0 if it succeeded
+type response struct {
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Estimate runs an estimate for the scope in the JSON body of the request and responds with its exit code.
+// Runs are processed one at a time.
+func Estimate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	var req request
+	err := decoder.Decode(&req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	scope, err := req.scope()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	code, err := cmd.Estimate(r.Context(), scope)
+	if err != nil {
+		log.Printf("Estimate failed: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(response{ExitCode: 1, Error: err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(response{ExitCode: code})
+}
+
+// scope returns the scope of the estimate for the request
+func (req request) scope() (cmd.Scope, error) {
+	scope := cmd.Scope{
+		Projects:      req.Projects,
+		Folders:       req.Folders,
+		Organizations: req.Organizations,
+		Policies:      req.Policies,
+		Recursive:     req.Recursive,
+	}
+	if len(scope.Projects)+len(scope.Folders)+len(scope.Organizations)+len(scope.Policies) == 0 {
+		return scope, fmt.Errorf("one of projects, folders, organizations or policies is required")
+	}
+	if req.Duration != "" {
+		duration, err := time.ParseDuration(req.Duration)
+		if err != nil {
+			return scope, fmt.Errorf("invalid duration: %v", err)
+		}
+		if duration <= 0 {
+			return scope, fmt.Errorf("duration must be positive")
+		}
+		scope.Duration = duration
+	}
+	return scope, nil
+}